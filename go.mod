@@ -3,14 +3,20 @@ module github.com/Jimdo/download-watch
 go 1.17
 
 require (
+	filippo.io/age v1.0.0
 	github.com/Luzifer/rconfig v1.1.0
 	golang.org/x/net v0.7.0
+	golang.org/x/term v0.5.0
+	golang.org/x/text v0.7.0
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/onsi/ginkgo v1.16.5 // indirect
 	github.com/onsi/gomega v1.18.1 // indirect
 	github.com/spf13/pflag v0.0.0-20160718215057-1560c1005499 // indirect
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5 // indirect
+	golang.org/x/sys v0.5.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
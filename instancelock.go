@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultLockFile derives the path of the instance lock from the
+// configuration file so daemons with different configurations do not
+// block each other
+func defaultLockFile(configFile string) string {
+	abs, err := filepath.Abs(configFile)
+	if err != nil {
+		abs = configFile
+	}
+
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("download-watch-%x.lock", sum[:8]))
+}
+
+// lockHolder returns the PID written into the lock file by the instance
+// holding the lock
+func lockHolder(lockFile string) string {
+	raw, err := ioutil.ReadFile(lockFile)
+	if err != nil || strings.TrimSpace(string(raw)) == "" {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// runningInstance returns the PID of the daemon holding the lock, ok is
+// false if no daemon holds it
+func runningInstance(lockFile string) (int, bool) {
+	lock, err := acquireInstanceLock(lockFile)
+	if err == nil {
+		lock.Release()
+		return 0, false
+	}
+
+	pid, err := strconv.Atoi(lockHolder(lockFile))
+	return pid, err == nil
+}
+
+// writeLockHolder stores the PID of this process in the held lock file
+func writeLockHolder(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	return err
+}
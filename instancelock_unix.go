@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// instanceLock is an exclusive flock on the lock file which is released
+// by the kernel when the process dies
+type instanceLock struct {
+	f *os.File
+}
+
+func acquireInstanceLock(lockFile string) (*instanceLock, error) {
+	f, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("Another instance is already running (PID %s, lock %s)", lockHolder(lockFile), lockFile)
+		}
+		return nil, err
+	}
+
+	if err := writeLockHolder(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &instanceLock{f: f}, nil
+}
+
+// Release unlocks the lock file, the file itself is kept as removing it
+// would race with another instance acquiring it
+func (l *instanceLock) Release() error {
+	if err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
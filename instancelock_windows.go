@@ -0,0 +1,49 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// instanceLock is the lock file opened without write sharing, Windows
+// closes the handle and thereby releases the lock when the process dies
+type instanceLock struct {
+	f *os.File
+}
+
+func acquireInstanceLock(lockFile string) (*instanceLock, error) {
+	name, err := syscall.UTF16PtrFromString(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := syscall.CreateFile(name,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		syscall.FILE_SHARE_READ,
+		nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errorSharingViolation {
+			return nil, fmt.Errorf("Another instance is already running (PID %s, lock %s)", lockHolder(lockFile), lockFile)
+		}
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(h), lockFile)
+	if err := writeLockHolder(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &instanceLock{f: f}, nil
+}
+
+// Release closes the lock file which allows other instances to open it
+func (l *instanceLock) Release() error {
+	return l.f.Close()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// logFile is the writer of the log when --log-file is set. It is
+// reopened on SIGHUP so the file moved away by logrotate is released.
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	// f is nil after a failed reopen, the log goes to stderr then
+	f *os.File
+}
+
+func openLogFile(path string) (*logFile, error) {
+	f, err := openLogPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return &logFile{path: path, f: f}, nil
+}
+
+func openLogPath(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return os.Stderr.Write(p)
+	}
+	return l.f.Write(p)
+}
+
+// Reopen closes the file and opens the path again. If that fails the log
+// is written to stderr until the next successful reopen.
+func (l *logFile) Reopen() error {
+	f, err := openLogPath(l.path)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.f = f
+	if err != nil {
+		l.f = nil
+		return fmt.Errorf("Could not reopen log file, logging to stderr: %s", err)
+	}
+	return nil
+}
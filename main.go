@@ -1,93 +1,429 @@
 package main
 
 import (
-	"crypto/sha256"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/Luzifer/rconfig"
+	"golang.org/x/net/context"
+
+	"github.com/Jimdo/download-watch/pkg/watch"
 )
 
 var (
 	cfg = struct {
-		ConfigFile     string `flag:"config-file,f" default:"files.yaml" description:"Configuration file"`
-		Verbose        bool   `flag:"verbose,v" default:"false" description:"Show more debug output"`
-		VersionAndExit bool   `flag:"version" default:"false" description:"Prints current version and exits"`
+		ConfigFile    string `flag:"config-file,f" default:"files.yaml" description:"Configuration file"`
+		StateFile     string `flag:"state-file" default:"" description:"Persist ETags and schedule state to this file (e.g. /var/lib/download-watch/state.json)"`
+		RunAs         string `flag:"run-as" default:"" description:"Switch to this user[:group] after startup preparations"`
+		ControlSocket string `flag:"control-socket" default:"" description:"Unix socket to accept control commands on and to send ctl commands to"`
+		ListenAdmin   string `flag:"listen-admin" default:"" description:"Address to serve the HTTP admin API on (e.g. 127.0.0.1:8081)"`
+		AdminToken    string `flag:"admin-token" env:"DW_ADMIN_TOKEN" default:"" description:"Bearer token required for the admin API"`
+		// Set is only declared for the help, the values are taken from
+		// the arguments by extractSetFlags
+		Set            []string      `flag:"set" default:"" description:"Override a config value by its dotted path, e.g. files./etc/app/x.json.fetch_interval=30s (repeatable)"`
+		AgeIdentity    string        `flag:"age-identity" env:"SOPS_AGE_KEY_FILE" default:"" description:"age identity file to decrypt !encrypted values of the config with"`
+		AdminAllow     []string      `flag:"admin-allow" default:"" description:"IPs / networks allowed to access the admin API"`
+		ListenWebhook  string        `flag:"listen-webhook" default:"" description:"Address to accept signed fetch triggers on (e.g. :9091)"`
+		EnablePprof    bool          `flag:"enable-pprof" default:"false" description:"Serve pprof handlers below /debug/pprof/ on the admin API"`
+		LogFile        string        `flag:"log-file" default:"" description:"Write the log of the daemon to this file instead of stderr, reopened on SIGHUP"`
+		Events         string        `flag:"events" default:"" description:"Write lifecycle events to stdout in this format (json), logs stay on stderr"`
+		MaxStale       string        `flag:"max-stale" default:"2x" description:"healthcheck: Maximum age of the last fetch as multiple of the fetch_interval (2x) or duration (1h)"`
+		Path           string        `flag:"path" default:"" description:"add / remove: Target path of the entry"`
+		URL            string        `flag:"url" default:"" description:"add: URL of the entry"`
+		Interval       time.Duration `flag:"interval" default:"0" description:"add: fetch_interval of the entry"`
+		SHA256         string        `flag:"sha256" default:"" description:"add: Expected SHA256 of the file"`
+		SuccessCommand string        `flag:"success-command" default:"" description:"add: success_command of the entry"`
+		Force          bool          `flag:"force" default:"false" description:"add: Replace an existing entry of the path"`
+		Reload         bool          `flag:"reload" default:"false" description:"add / remove: Reload the running daemon afterwards"`
+		Output         string        `flag:"output" default:"table" description:"list: Output format (table, json)"`
+		CheckURLs      bool          `flag:"check-urls" default:"false" description:"validate: Request every source URL with HEAD using the settings of its entry"`
+		Concurrency    int           `flag:"concurrency" default:"10" description:"validate: URLs checked at once with --check-urls"`
+		All            bool          `flag:"all" default:"false" description:"fetch: Fetch all files"`
+		Wait           bool          `flag:"wait" default:"false" description:"fetch: Wait for the fetches in the daemon and exit with their outcome"`
+		WaitTimeout    time.Duration `flag:"wait-timeout" default:"10m" description:"fetch: Maximum time to wait with --wait (0 waits forever)"`
+		StartupDelay   time.Duration `flag:"startup-delay" default:"0" description:"Hold off the first fetches for this duration after the start, listeners are up meanwhile"`
+		WaitForDNS     string        `flag:"wait-for-dns" default:"" description:"Hold off the first fetches until this host name resolves"`
+		WaitForDNSMax  time.Duration `flag:"wait-for-dns-timeout" default:"5m" description:"Start the fetches after this time even if --wait-for-dns does not resolve (0 waits forever)"`
+		MaxFailures    int           `flag:"max-global-failures" default:"0" description:"Exit with code 3 once every file failed this many times in a row (0 disables)"`
+		MaxFailingFor  time.Duration `flag:"max-failure-duration" default:"0" description:"Exit with code 3 once every file is failing for this duration (0 disables)"`
+		LockFile       string        `flag:"lock-file" default:"" description:"Lock file to prevent multiple instances (default: derived from the config file path in the temp directory)"`
+		Verbose        bool          `flag:"verbose,v" default:"false" description:"Show more debug output"`
+		Quiet          bool          `flag:"quiet,q" default:"false" description:"Only log warnings and errors"`
+		VersionAndExit bool          `flag:"version" default:"false" description:"Prints current version and exits"`
 	}{}
 
-	downloadConfig = &configFile{
-		CommandShell: []string{"/bin/bash", "-c"},
-		Files:        make(map[string]*configFileSource),
-	}
+	watcher *watch.Watcher
+
+	// loadOptions are passed to every load of the configuration
+	loadOptions watch.Options
 
 	version = "dev"
 )
 
+const statusInterval = 10 * time.Second
+
+// exitTotalFailure is the exit code of the daemon when all files kept
+// failing beyond --max-global-failures / --max-failure-duration
+const exitTotalFailure = 3
+
 func debug(format string, args ...interface{}) {
-	if cfg.Verbose {
+	if debugEnabled() {
 		log.Printf(format, args...)
 	}
 }
 
+// info logs the message unless only warnings and errors are logged
+func info(format string, args ...interface{}) {
+	quiet := cfg.Quiet
+	if watcher != nil {
+		quiet = watcher.QuietEnabled()
+	}
+	if !quiet || debugEnabled() {
+		log.Printf(format, args...)
+	}
+}
+
+// debugEnabled follows the log level of the watcher once it is running,
+// SIGUSR2 toggles it
+func debugEnabled() bool {
+	if watcher != nil {
+		return watcher.DebugEnabled()
+	}
+	return cfg.Verbose
+}
+
 func init() {
+	var overrides []string
+	os.Args, overrides = extractSetFlags(os.Args)
+
 	if err := rconfig.Parse(&cfg); err != nil {
 		log.Fatalf("Unable to parse commandline options: %s", err)
 	}
 
+	loadOptions = watch.Options{
+		AgeIdentity: cfg.AgeIdentity,
+		Overrides:   overrides,
+		Debug:       cfg.Verbose,
+		Quiet:       cfg.Quiet,
+	}
+	watch.Version = version
+
 	if cfg.VersionAndExit {
 		fmt.Printf("download-watch %s\n", version)
 		os.Exit(0)
 	}
 }
 
+// extractSetFlags removes the --set flags from the arguments before the
+// flag parser sees them: it would split their values at commas and fail
+// on quotes, both common in config values
+func extractSetFlags(args []string) (rest, sets []string) {
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "--":
+			return append(rest, args[i:]...), sets
+		case a == "--set" && i+1 < len(args):
+			sets = append(sets, args[i+1])
+			i++
+		case strings.HasPrefix(a, "--set="):
+			sets = append(sets, strings.TrimPrefix(a, "--set="))
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return rest, sets
+}
+
 func reloadConfig() error {
 	debug("Reloading configuration")
-	c, err := loadConfigFile(cfg.ConfigFile)
+	c, err := watch.LoadConfig(cfg.ConfigFile, loadOptions)
 	if err != nil {
 		return err
 	}
 
-	downloadConfig.Lock()
-	defer downloadConfig.Unlock()
+	return watcher.Reload(c)
+}
 
-	return downloadConfig.Patch(c)
+// editConfig adds or removes the entry given by the flags in the
+// configuration file and reloads the daemon if requested
+func editConfig(command string) error {
+	var err error
+	if command == "add" {
+		err = watch.AddFile(cfg.ConfigFile, loadOptions, watch.FileEntry{
+			Path:           cfg.Path,
+			URL:            cfg.URL,
+			FetchInterval:  cfg.Interval,
+			SHA256:         cfg.SHA256,
+			SuccessCommand: cfg.SuccessCommand,
+		}, cfg.Force)
+	} else {
+		err = watch.RemoveFile(cfg.ConfigFile, loadOptions, cfg.Path)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Updated %s", cfg.ConfigFile)
+
+	if !cfg.Reload {
+		return nil
+	}
+
+	lockFile := cfg.LockFile
+	if lockFile == "" {
+		lockFile = defaultLockFile(cfg.ConfigFile)
+	}
+	pid, ok := runningInstance(lockFile)
+	if !ok {
+		log.Printf("No running daemon found (lock %s), nothing to reload", lockFile)
+		return nil
+	}
+	if err := signalReload(pid); err != nil {
+		return fmt.Errorf("Could not reload daemon (PID %d): %s", pid, err)
+	}
+	log.Printf("Reloaded daemon (PID %d)", pid)
+	return nil
+}
+
+// fetchNow fetches the files in the running daemon or, if it can't be
+// reached, in this process and reports whether all fetches succeeded
+func fetchNow(paths []string) bool {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	lockFile := cfg.LockFile
+	if lockFile == "" {
+		lockFile = defaultLockFile(cfg.ConfigFile)
+	}
+	pid, _ := runningInstance(lockFile)
+
+	ok, err := watch.Fetch(ctx, watch.FetchOptions{
+		ConfigPath: cfg.ConfigFile,
+		StateFile:  cfg.StateFile,
+		SocketPath: cfg.ControlSocket,
+		AdminAddr:  cfg.ListenAdmin,
+		AdminToken: cfg.AdminToken,
+		Paths:      paths,
+		All:        cfg.All,
+		Wait:       cfg.Wait,
+		Timeout:    cfg.WaitTimeout,
+		RunningPID: pid,
+		Load:       loadOptions,
+	})
+	if err != nil {
+		log.Printf("Fetch failed: %s", err)
+		return false
+	}
+	return ok
 }
 
 func main() {
-	if err := reloadConfig(); err != nil {
+	// rconfig passes the program name on to the positional arguments
+	if args := rconfig.Args()[1:]; len(args) > 0 {
+		switch args[0] {
+		case "ctl":
+			if err := watch.RunControlClient(cfg.ControlSocket, args[1:]); err != nil {
+				log.Fatalf("Command failed: %s", err)
+			}
+		case "healthcheck":
+			if !watch.Healthcheck(cfg.ConfigFile, loadOptions, cfg.ControlSocket, cfg.MaxStale) {
+				os.Exit(1)
+			}
+		case "validate":
+			if !watch.Validate(cfg.ConfigFile, loadOptions, cfg.CheckURLs, cfg.Concurrency) {
+				os.Exit(1)
+			}
+		case "print-config":
+			if err := watch.PrintConfig(cfg.ConfigFile, loadOptions); err != nil {
+				log.Fatalf("Could not print config: %s", err)
+			}
+		case "list":
+			if err := watch.List(cfg.ConfigFile, loadOptions, cfg.ControlSocket, cfg.StateFile, cfg.Output); err != nil {
+				log.Fatalf("Could not list files: %s", err)
+			}
+		case "top":
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			err := watch.Top(ctx, cfg.ControlSocket, cfg.ListenAdmin, cfg.AdminToken)
+			cancel()
+			if err != nil {
+				log.Fatalf("Could not show status: %s", err)
+			}
+		case "fetch":
+			if !fetchNow(args[1:]) {
+				os.Exit(1)
+			}
+		case "add", "remove":
+			if err := editConfig(args[0]); err != nil {
+				log.Fatalf("Could not %s entry: %s", args[0], err)
+			}
+		default:
+			log.Fatalf("Unknown command %q", args[0])
+		}
+		return
+	}
+
+	var logOut *logFile
+	if cfg.LogFile != "" {
+		var err error
+		if logOut, err = openLogFile(cfg.LogFile); err != nil {
+			log.Fatalf("Unable to open log file: %s", err)
+		}
+		log.SetOutput(logOut)
+	}
+
+	// Registered first to exit after all other deferred cleanups ran
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	lockFile := cfg.LockFile
+	if lockFile == "" {
+		lockFile = defaultLockFile(cfg.ConfigFile)
+	}
+
+	lock, err := acquireInstanceLock(lockFile)
+	if err != nil {
+		log.Fatalf("Unable to acquire instance lock: %s", err)
+	}
+	defer lock.Release()
+
+	// The root context of the daemon, fetches and commands are aborted
+	// once it is cancelled
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	c, err := watch.LoadConfig(cfg.ConfigFile, loadOptions)
+	if err == nil {
+		c.StateFile = cfg.StateFile
+		watcher, err = watch.New(c)
+	}
+	if err != nil {
 		log.Fatalf("Initial load of config failed: %s", err)
 	}
+	if err := watcher.EnableEvents(cfg.Events, os.Stdout); err != nil {
+		log.Fatalf("Unable to enable events: %s", err)
+	}
+
+	if cfg.ControlSocket != "" {
+		ctl, err := watcher.ListenControlSocket(cfg.ControlSocket, reloadConfig)
+		if err != nil {
+			log.Fatalf("Unable to listen on control socket: %s", err)
+		}
+		defer ctl.Close()
+	}
+
+	if cfg.ListenAdmin != "" {
+		admin, err := watcher.ListenAdmin(cfg.ListenAdmin, cfg.AdminToken, cfg.AdminAllow, cfg.EnablePprof, reloadConfig)
+		if err != nil {
+			log.Fatalf("Unable to start admin API: %s", err)
+		}
+		defer admin.Close()
+	}
 
-	hupChan := make(chan os.Signal)
-	signal.Notify(hupChan, syscall.SIGHUP)
+	if cfg.ListenWebhook != "" {
+		trigger, err := watcher.ListenTrigger(cfg.ListenWebhook)
+		if err != nil {
+			log.Fatalf("Unable to start webhook listener: %s", err)
+		}
+		defer trigger.Close()
+	}
 
-	waiter := downloadConfig.WaitNextExecution()
+	if cfg.RunAs != "" {
+		if err := watcher.RunAs(cfg.RunAs); err != nil {
+			log.Fatalf("Unable to run as %s: %s", cfg.RunAs, err)
+		}
+	}
+
+	if err := watcher.HoldOff(ctx, cfg.StartupDelay, cfg.WaitForDNS, cfg.WaitForDNSMax); err != nil {
+		log.Printf("Startup aborted: %s", err)
+		return
+	}
+
+	if err := watcher.FetchRequired(ctx); err != nil {
+		if ctx.Err() != nil {
+			log.Printf("Startup aborted: %s", err)
+			return
+		}
+		log.Fatalf("Startup failed: %s", err)
+	}
+
+	if err := sdNotify(sdNotifyReady); err != nil {
+		log.Printf("Could not notify systemd: %s", err)
+	}
+
+	var watchdog <-chan time.Time
+	if interval := sdWatchdogInterval(); interval > 0 {
+		debug("Sending systemd watchdog pings every %s", interval)
+		watchdog = time.NewTicker(interval).C
+	}
+
+	hupChan := make(chan os.Signal, 1)
+	notifyReload(hupChan)
+
+	pauseChan := make(chan os.Signal, 1)
+	resumeChan := make(chan os.Signal, 1)
+	notifyPause(pauseChan, resumeChan)
+
+	fetchAllChan := make(chan os.Signal, 1)
+	notifyFetchAll(fetchAllChan)
+
+	debugChan := make(chan os.Signal, 1)
+	notifyToggleDebug(debugChan)
+
+	done := make(chan struct{})
+	go func() {
+		watcher.Run(ctx)
+		close(done)
+	}()
+
+	statusTicker := time.NewTicker(statusInterval)
+	sdNotify("STATUS=" + watcher.Summary())
 
 	for {
 		select {
-		case <-waiter:
-			downloadConfig.ExecuteExpired()
+		case <-statusTicker.C:
+			sdNotify("STATUS=" + watcher.Summary())
+			if summary, failed := watcher.TotalFailure(cfg.MaxFailures, cfg.MaxFailingFor); failed && exitCode == 0 {
+				log.Printf("ERROR: All files kept failing, exiting: %s", summary)
+				exitCode = exitTotalFailure
+				cancel()
+			}
+		case <-watchdog:
+			sdNotify(sdNotifyWatchdog)
 		case <-hupChan:
+			if logOut != nil {
+				if err := logOut.Reopen(); err != nil {
+					log.Printf("WARNING: %s", err)
+				}
+			}
 			if err := reloadConfig(); err != nil {
 				log.Printf("Reload of config failed: %s", err)
 			}
+		case sig := <-fetchAllChan:
+			triggered, err := watcher.TriggerFetch("")
+			if err != nil {
+				log.Printf("Ignoring %s: %s", sig, err)
+				continue
+			}
+			info("Operator-triggered fetch of %d files (%s)", len(triggered), sig)
+		case <-debugChan:
+			watcher.SetDebug(!watcher.DebugEnabled())
+			log.Printf("Log level changed to %s", watcher.LogLevel())
+		case <-pauseChan:
+			watcher.SetPaused("", true)
+		case <-resumeChan:
+			watcher.SetPaused("", false)
+		case <-ctx.Done():
+			sdNotify(sdNotifyStopping)
+			<-done
+			return
 		}
 	}
 }
-
-func calculateFileSha256(filePath string) (string, bool) {
-	if _, err := os.Stat(filePath); err != nil {
-		return "", false
-	}
-
-	raw, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return "", false
-	}
-
-	return fmt.Sprintf("%x", sha256.Sum256(raw)), true
-}
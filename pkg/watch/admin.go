@@ -0,0 +1,280 @@
+package watch
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminServer exposes the control commands over HTTP
+type adminServer struct {
+	server   *http.Server
+	listener net.Listener
+
+	token  string
+	allow  []*net.IPNet
+	config *configFile
+	reload func() error
+}
+
+// parseAllowList parses IPs and CIDR networks allowed to access the
+// admin API
+func parseAllowList(in []string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, a := range in {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP %q", a)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network %q", a)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+// listenAdmin binds the admin listener. A token or an allow list is
+// required as the API can trigger the execution of commands.
+func listenAdmin(addr, token string, allow []string, enablePprof bool, config *configFile, reload func() error) (*adminServer, error) {
+	nets, err := parseAllowList(allow)
+	if err != nil {
+		return nil, err
+	}
+
+	if token == "" && len(nets) == 0 {
+		return nil, errors.New("Admin API needs --admin-token or --admin-allow")
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &adminServer{listener: l, token: token, allow: nets, config: config, reload: reload}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fetch", s.handleFetch)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/reload", s.handleReload)
+	mux.HandleFunc("/pause", s.handlePause(true))
+	mux.HandleFunc("/resume", s.handlePause(false))
+
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	s.server = &http.Server{
+		Handler:           s.guard(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+		// No WriteTimeout as CPU profiles and traces take their time
+	}
+
+	go func() {
+		if err := s.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			config.log.logf(levelWarn, "Admin API stopped: %s", err)
+		}
+	}()
+
+	return s, nil
+}
+
+// Close stops the admin listener
+func (s *adminServer) Close() error {
+	return s.server.Close()
+}
+
+// guard rejects requests from addresses not in the allow list or
+// without the correct bearer token, whatever is configured
+func (s *adminServer) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.allow) > 0 && !s.allowed(r.RemoteAddr) {
+			writeAdminResponse(s.config.log, w, http.StatusForbidden, controlResponse{Error: "Address not allowed"})
+			return
+		}
+
+		if s.token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeAdminResponse(s.config.log, w, http.StatusUnauthorized, controlResponse{Error: "Invalid or missing token"})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *adminServer) allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	for _, n := range s.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *adminServer) handleFetch(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(s.config.log, w, r, http.MethodPost) {
+		return
+	}
+
+	trigger := s.config.TriggerFetch
+	if force, _ := strconv.ParseBool(r.URL.Query().Get("force")); force {
+		trigger = s.config.ForceFetch
+	}
+
+	triggered, err := trigger(r.URL.Query().Get("path"))
+	switch err {
+	case nil:
+		writeAdminResponse(s.config.log, w, http.StatusAccepted, controlResponse{OK: true, Message: fmt.Sprintf("Triggered fetch of %d files", len(triggered)), Paths: triggered})
+	case ErrUnknownFile:
+		writeAdminResponse(s.config.log, w, http.StatusNotFound, controlResponse{Error: err.Error()})
+	case ErrFetchInProgress, ErrFilePaused, ErrPaused:
+		writeAdminResponse(s.config.log, w, http.StatusConflict, controlResponse{Error: err.Error()})
+	default:
+		writeAdminResponse(s.config.log, w, http.StatusInternalServerError, controlResponse{Error: err.Error()})
+	}
+}
+
+func (s *adminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(s.config.log, w, r, http.MethodGet) {
+		return
+	}
+
+	stats := s.config.Stats()
+	writeAdminResponse(s.config.log, w, http.StatusOK, controlResponse{OK: true, Status: s.config.Status(), Paused: s.config.IsPaused(), Starting: s.config.IsStarting(),
+		Stats: &stats, LogLevel: s.config.log.level()})
+}
+
+func (s *adminServer) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(s.config.log, w, r, http.MethodPost) {
+			return
+		}
+
+		switch err := s.config.SetPaused(r.URL.Query().Get("path"), paused); err {
+		case nil:
+			writeAdminResponse(s.config.log, w, http.StatusOK, controlResponse{OK: true, Paused: s.config.IsPaused()})
+		case ErrUnknownFile:
+			writeAdminResponse(s.config.log, w, http.StatusNotFound, controlResponse{Error: err.Error()})
+		default:
+			writeAdminResponse(s.config.log, w, http.StatusInternalServerError, controlResponse{Error: err.Error()})
+		}
+	}
+}
+
+func (s *adminServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(s.config.log, w, r, http.MethodPost) {
+		return
+	}
+
+	if err := s.reload(); err != nil {
+		writeAdminResponse(s.config.log, w, http.StatusInternalServerError, controlResponse{Error: err.Error()})
+		return
+	}
+
+	writeAdminResponse(s.config.log, w, http.StatusOK, controlResponse{OK: true, Message: "Configuration reloaded"})
+}
+
+func requireMethod(l *logger, w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeAdminResponse(l, w, http.StatusMethodNotAllowed, controlResponse{Error: fmt.Sprintf("Method %s not allowed", r.Method)})
+	return false
+}
+
+func writeAdminResponse(l *logger, w http.ResponseWriter, status int, res controlResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		l.debug("Could not write admin API response: %s", err)
+	}
+}
+
+// callAdmin sends the fetch, force-fetch or status request to the admin
+// API of the daemon listening on addr, responses not being OK are
+// returned as error
+func callAdmin(addr, token string, req controlRequest) (controlResponse, error) {
+	var res controlResponse
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return res, err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	base := "http://" + net.JoinHostPort(host, port)
+
+	var r *http.Request
+	switch req.Command {
+	case "fetch", "force-fetch":
+		query := "path=" + url.QueryEscape(req.Path)
+		if req.Command == "force-fetch" {
+			query += "&force=1"
+		}
+		r, err = http.NewRequest(http.MethodPost, base+"/fetch?"+query, nil)
+	case "status":
+		r, err = http.NewRequest(http.MethodGet, base+"/status", nil)
+	default:
+		return res, fmt.Errorf("Command %q is not available on the admin API", req.Command)
+	}
+	if err != nil {
+		return res, err
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := (&http.Client{Timeout: controlClientTimeout}).Do(r)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, fmt.Errorf("Invalid response (%s): %s", resp.Status, err)
+	}
+	if !res.OK {
+		return res, errors.New(res.Error)
+	}
+	return res, nil
+}
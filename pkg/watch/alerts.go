@@ -0,0 +1,123 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	failureEventFailing   = "failing"
+	failureEventRecovered = "recovered"
+	// failureEventMirrorFailed is sent when all uploads to mirror_to
+	// failed, the local file is still installed
+	failureEventMirrorFailed = "mirror_failed"
+)
+
+func validateFailureThresholds(thresholds []int) error {
+	for i, t := range thresholds {
+		if t <= 0 {
+			return fmt.Errorf("Invalid failure threshold %d, needs to be positive", t)
+		}
+		if i > 0 && t <= thresholds[i-1] {
+			return errors.New("Failure thresholds need to be in ascending order")
+		}
+	}
+	return nil
+}
+
+// crossedFailureThreshold reports whether the amount of consecutive
+// failures just reached one of the configured thresholds
+func (c *configFileSource) crossedFailureThreshold(failures int) bool {
+	for _, t := range c.FailureThresholds {
+		if failures == t {
+			return true
+		}
+	}
+	return false
+}
+
+// escalated reports whether the amount of consecutive failures reached
+// at least the first configured threshold
+func (c *configFileSource) escalated(failures int) bool {
+	return len(c.FailureThresholds) > 0 && failures >= c.FailureThresholds[0]
+}
+
+// failureEvent describes a crossed failure threshold or the recovery of
+// an escalated entry
+type failureEvent struct {
+	Event    string
+	Host     string
+	Path     string
+	URL      string
+	Error    string
+	Failures int
+	Since    time.Time
+	Duration time.Duration
+}
+
+// alertFailure executes the failure command and sends notifications
+// for the event in the background
+func (c *configFile) alertFailure(targetPath string, fc *configFileSource, event string, es fileErrorState) {
+	ev := failureEvent{
+		Event:    event,
+		Host:     c.hostname,
+		Path:     targetPath,
+		URL:      fc.displayURL(),
+		Failures: es.ConsecutiveFailures,
+		Since:    es.FirstErrorAt,
+		Duration: time.Since(es.FirstErrorAt),
+	}
+	if event != failureEventRecovered {
+		ev.Error = es.LastError
+	}
+
+	go c.executeFailureCommand(ev)
+
+	if !fc.MuteNotifications {
+		c.notifyFailure(ev)
+	}
+}
+
+// executeFailureCommand runs the failure command of the entry when a
+// failure threshold was crossed or the entry recovered after escalation
+func (c *configFile) executeFailureCommand(ev failureEvent) {
+	c.RLock()
+	fc, ok := c.Files[ev.Path]
+	var argv, env []string
+	if ok {
+		argv = c.commandLine(fc, fc.FailureCommand)
+		env = c.commandEnv(fc,
+			"DW_PATH="+ev.Path,
+			"DW_URL="+ev.URL,
+			"DW_FAILURE_EVENT="+ev.Event,
+			"DW_FAILURES="+strconv.Itoa(ev.Failures),
+			"DW_ERROR="+ev.Error,
+		)
+	}
+	c.RUnlock()
+
+	if !ok || fc.FailureCommand == "" {
+		return
+	}
+
+	cmd := exec.CommandContext(c.rootContext(), argv[0], argv[1:]...)
+	cmd.Env = env
+
+	c.emitEvent(streamEvent{Event: eventCommandStarted, Path: ev.Path, Command: "failure_command"})
+	start := time.Now()
+	err := cmd.Run()
+	c.emitEvent(streamEvent{
+		Event:      eventCommandFinished,
+		Path:       ev.Path,
+		Command:    "failure_command",
+		ExitCode:   commandExitCode(cmd),
+		DurationMS: int64(time.Since(start) / time.Millisecond),
+		Error:      errString(err),
+	})
+	if err != nil {
+		fc.logf(levelWarn, "Could not execute failure-command for '%s': %s", ev.Path, err)
+	}
+}
@@ -0,0 +1,124 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// errNotAllowed is wrapped by all errors of URLs outside of the
+// allowed_hosts and allowed_schemes
+var errNotAllowed = errors.New("URL not allowed")
+
+// urlAllowlist restricts the hosts and schemes fetched from, an empty
+// list does not restrict anything
+type urlAllowlist struct {
+	hosts   []string
+	schemes []string
+}
+
+func (c *configFile) allowlist() urlAllowlist {
+	return urlAllowlist{hosts: c.AllowedHosts, schemes: c.AllowedSchemes}
+}
+
+func validateAllowlist(hosts, schemes []string) error {
+	for _, h := range hosts {
+		name := strings.TrimPrefix(h, "*.")
+		if name == "" || strings.ContainsAny(name, "*/:") {
+			return fmt.Errorf("Invalid allowed_hosts entry %q, use a host name or *.domain", h)
+		}
+	}
+	for _, s := range schemes {
+		if s == "" || strings.Contains(s, ":") {
+			return fmt.Errorf("Invalid allowed_schemes entry %q, use a scheme like https", s)
+		}
+	}
+	return nil
+}
+
+// check returns an error wrapping errNotAllowed if the URL is outside of
+// the allowlist
+func (a urlAllowlist) check(raw string) error {
+	if len(a.hosts) == 0 && len(a.schemes) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errNotAllowed, err)
+	}
+	return a.checkURL(u)
+}
+
+func (a urlAllowlist) checkURL(u *url.URL) error {
+	if len(a.schemes) > 0 && !a.schemeAllowed(u.Scheme) {
+		return fmt.Errorf("%w: scheme %q of %s is not in allowed_schemes", errNotAllowed, u.Scheme, sanitizeURL(u.String()))
+	}
+	if len(a.hosts) > 0 && !a.hostAllowed(u.Hostname()) {
+		return fmt.Errorf("%w: host %q of %s is not in allowed_hosts", errNotAllowed, u.Hostname(), sanitizeURL(u.String()))
+	}
+	return nil
+}
+
+func (a urlAllowlist) schemeAllowed(scheme string) bool {
+	for _, s := range a.schemes {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAllowed matches the host against exact names and *.domain
+// patterns, which match all subdomains but not the domain itself
+func (a urlAllowlist) hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range a.hosts {
+		h = strings.ToLower(h)
+		if strings.HasPrefix(h, "*.") {
+			if strings.HasSuffix(host, h[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAllowed checks the static URLs of the entry, URLs known only
+// at fetch time are checked then
+func (c *configFileSource) validateAllowed(a urlAllowlist) error {
+	urls := append([]string{c.URL}, c.URLs...)
+	urls = append(urls, c.FallbackURLs...)
+	for _, raw := range urls {
+		if raw == "" || c.dateTemplated() && raw == c.URL {
+			continue
+		}
+		if err := a.check(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allowlistTransport refuses requests outside of the allowlist, which
+// covers redirects, fallbacks, parts and mirrored files alike
+type allowlistTransport struct {
+	http.RoundTripper
+	allow urlAllowlist
+}
+
+func (t allowlistTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.allow.checkURL(req.URL); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,149 @@
+package watch
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	modeReplace = "replace"
+	modeAppend  = "append"
+
+	targetSizeTruncate = "truncate"
+	targetSizeRotate   = "rotate"
+)
+
+func (c *configFileSource) validateMode() error {
+	switch c.Mode {
+	case "", modeReplace:
+		if c.MaxTargetSize > 0 || c.OnMaxTargetSize != "" {
+			return errors.New("max_target_size needs mode append")
+		}
+		return nil
+	case modeAppend:
+	default:
+		return fmt.Errorf("Invalid mode %q, use replace or append", c.Mode)
+	}
+
+	switch {
+	case c.SHA256 != "":
+		return errors.New("sha256 can't be used with mode append")
+	case c.Extract != "":
+		return errors.New("extract can't be used with mode append")
+	case c.JSONPath != "":
+		return errors.New("json_path can't be used with mode append")
+	case c.MaxStaleness > 0:
+		// A forced download without validators would append everything again
+		return errors.New("max_staleness can't be used with mode append")
+	}
+
+	switch c.OnMaxTargetSize {
+	case "", targetSizeTruncate, targetSizeRotate:
+	default:
+		return fmt.Errorf("Invalid on_max_target_size %q, use truncate or rotate", c.OnMaxTargetSize)
+	}
+	return nil
+}
+
+func (c *configFileSource) appendMode() bool {
+	return c.Mode == modeAppend
+}
+
+// appendFile appends the validated download to the target and returns
+// the number of bytes appended
+func (c *configFileSource) appendFile(srcPath, targetPath string) (int64, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() == 0 {
+		return 0, nil
+	}
+
+	if c.MaxTargetSize > 0 {
+		if err := c.limitTargetSize(targetPath, fi.Size()); err != nil {
+			return 0, fmt.Errorf("Could not apply max_target_size: %s", err)
+		}
+	}
+
+	dst, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = c.applyFileMode(targetPath)
+	}
+	return n, err
+}
+
+// limitTargetSize makes room for the given number of bytes to keep the
+// target below max_target_size by rotating it to <target>.1 or by
+// dropping lines from its front
+func (c *configFileSource) limitTargetSize(targetPath string, add int64) error {
+	fi, err := os.Stat(targetPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Size()+add <= int64(c.MaxTargetSize) {
+		return nil
+	}
+
+	if c.OnMaxTargetSize != targetSizeTruncate {
+		return os.Rename(targetPath, targetPath+".1")
+	}
+
+	return truncateFront(targetPath, fi.Size()+add-int64(c.MaxTargetSize))
+}
+
+// truncateFront removes at least drop bytes from the start of the file,
+// continuing to the next line break to not leave a partial line
+func truncateFront(filePath string, drop int64) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	t, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(t.Name())
+	defer t.Close()
+
+	if _, err := f.Seek(drop, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	if _, err := r.ReadString('\n'); err != nil && err != io.EOF {
+		return err
+	}
+
+	if _, err := io.Copy(t, r); err != nil {
+		return err
+	}
+	if err := t.Close(); err != nil {
+		return err
+	}
+
+	return replaceFile(t.Name(), filePath)
+}
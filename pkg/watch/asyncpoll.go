@@ -0,0 +1,109 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	defaultAsyncPollInterval = 5 * time.Second
+	defaultAsyncPollMaxWait  = 2 * time.Minute
+)
+
+// asyncPoll configures polling the Location of a 202 Accepted response
+// until the artifact is available
+type asyncPoll struct {
+	Interval time.Duration `yaml:"interval"`
+	MaxWait  time.Duration `yaml:"max_wait"`
+}
+
+func asyncPollEqual(a, b *asyncPoll) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (a *asyncPoll) validate() error {
+	if a.Interval < 0 || a.MaxWait < 0 {
+		return errors.New("async_poll interval and max_wait must not be negative")
+	}
+	return nil
+}
+
+func (a *asyncPoll) interval() time.Duration {
+	if a.Interval > 0 {
+		return a.Interval
+	}
+	return defaultAsyncPollInterval
+}
+
+func (a *asyncPoll) maxWait() time.Duration {
+	if a.MaxWait > 0 {
+		return a.MaxWait
+	}
+	return defaultAsyncPollMaxWait
+}
+
+// pollAsync follows the Location of the 202 response with GET requests
+// until the upstream answers with something else than 202. The body of
+// the accepted response is closed, the final response is returned open.
+// A 303 to the result is followed by the client.
+func (c *configFileSource) pollAsync(ctx context.Context, client *http.Client, accepted *http.Response, userAgent string) (*http.Response, error) {
+	accepted.Body.Close()
+
+	loc, err := accepted.Location()
+	if err != nil {
+		return nil, fmt.Errorf("Got status 202 without usable Location: %s", err)
+	}
+	origin := accepted.Request.URL.Host
+
+	deadline := time.Now().Add(c.AsyncPoll.maxWait())
+	for polls := 1; ; polls++ {
+		if time.Now().Add(c.AsyncPoll.interval()).After(deadline) {
+			return nil, fmt.Errorf("Result not ready within async_poll max_wait of %s (%d polls)", c.AsyncPoll.maxWait(), polls-1)
+		}
+
+		select {
+		case <-time.After(c.AsyncPoll.interval()):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Overall timeout exceeded (timeout) while polling for the result (%d polls)", polls-1)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, loc.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.prepareRequest(req, userAgent); err != nil {
+			return nil, err
+		}
+		if loc.Host != origin {
+			// Do not leak the credentials to another host, like redirects
+			req.Header.Del("Authorization")
+		}
+
+		next, err := ctxhttp.Do(ctx, client, req)
+		if err != nil {
+			if c.URLCommand != "" {
+				err = sanitizeMintedURLError(err)
+			}
+			return nil, wrapTransportError(ctx, client, req, err)
+		}
+
+		if next.StatusCode != http.StatusAccepted {
+			c.log.debug("Result of async request available after %d polls", polls)
+			return next, nil
+		}
+		next.Body.Close()
+
+		// The upstream may point to another status resource
+		if l, err := next.Location(); err == nil {
+			loc = l
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package watch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSize is an amount of bytes which can be specified in the
+// configuration file either as a plain number or with a unit suffix
+// (K, M, G, T - powers of 1024) like "10M"
+type byteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+func parseByteSize(in string) (byteSize, error) {
+	s := strings.TrimSpace(strings.ToUpper(in))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	factor := int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			factor = u.factor
+			s = strings.TrimSuffix(s, u.suffix)
+			break
+		}
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("Invalid byte size %q", in)
+	}
+
+	return byteSize(v * float64(factor)), nil
+}
+
+func (b *byteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	v, err := parseByteSize(raw)
+	if err != nil {
+		return err
+	}
+
+	*b = v
+	return nil
+}
+
+func (b byteSize) String() string {
+	for _, u := range byteSizeUnits {
+		if int64(b) >= u.factor {
+			return strconv.FormatFloat(float64(b)/float64(u.factor), 'f', 1, 64) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
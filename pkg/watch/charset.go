@@ -0,0 +1,122 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+const (
+	charsetInvalidFail    = "fail"
+	charsetInvalidReplace = "replace"
+
+	defaultCharsetTo       = "utf-8"
+	charsetErrorPrefix     = "Could not convert charset"
+	utf8ReplacementCharSeq = "\uFFFD"
+)
+
+var errInvalidCharsetInput = errors.New("Invalid byte sequence in input")
+
+// charsetSpec describes the conversion of a text file between two
+// character sets
+type charsetSpec struct {
+	// From is taken from the Content-Type charset parameter if empty
+	From    string `yaml:"from"`
+	To      string `yaml:"to"`
+	Invalid string `yaml:"invalid"`
+}
+
+func charsetEqual(a, b *charsetSpec) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (c *charsetSpec) validate() error {
+	if c.From != "" {
+		if _, err := htmlindex.Get(c.From); err != nil {
+			return fmt.Errorf("Unknown charset %q", c.From)
+		}
+	}
+	if c.To != "" {
+		if _, err := htmlindex.Get(c.To); err != nil {
+			return fmt.Errorf("Unknown charset %q", c.To)
+		}
+	}
+
+	switch c.Invalid {
+	case "", charsetInvalidFail, charsetInvalidReplace:
+	default:
+		return fmt.Errorf("Invalid convert_charset.invalid %q, use fail or replace", c.Invalid)
+	}
+	return nil
+}
+
+// newCharsetReader converts the body to the target charset, without a
+// configuration the body is returned unchanged. normalize is applied to
+// the decoded UTF-8 text before it is encoded into the target charset,
+// so line endings are converted independent of both charsets.
+func newCharsetReader(c *charsetSpec, contentType string, r io.Reader, normalize func(io.Reader) io.Reader) (io.Reader, error) {
+	if c == nil {
+		return normalize(r), nil
+	}
+
+	from := c.From
+	if from == "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			from = params["charset"]
+		}
+		if from == "" {
+			return nil, errors.New("No charset in Content-Type, set convert_charset.from")
+		}
+	}
+
+	to := c.To
+	if to == "" {
+		to = defaultCharsetTo
+	}
+
+	fromEnc, err := htmlindex.Get(from)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown charset %q", from)
+	}
+	toEnc, err := htmlindex.Get(to)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown charset %q", to)
+	}
+
+	var decoder, encoder transform.Transformer
+	if c.Invalid == charsetInvalidReplace {
+		decoder, encoder = fromEnc.NewDecoder(), encoding.ReplaceUnsupported(toEnc.NewEncoder())
+	} else {
+		decoder, encoder = strictDecoder{fromEnc.NewDecoder(), fromEnc}, toEnc.NewEncoder()
+	}
+
+	decoded := annotatedReader{transform.NewReader(r, decoder), charsetErrorPrefix}
+	return annotatedReader{transform.NewReader(normalize(decoded), encoder), charsetErrorPrefix}, nil
+}
+
+// strictDecoder fails on invalid input. Decoders replace it with the
+// replacement character, which is only accepted if it was literally in
+// the input, i.e. the decoded chunk encodes back to the same bytes.
+type strictDecoder struct {
+	transform.Transformer
+	enc encoding.Encoding
+}
+
+func (d strictDecoder) Transform(dst, src []byte, atEOF bool) (int, int, error) {
+	nDst, nSrc, err := d.Transformer.Transform(dst, src, atEOF)
+	if bytes.Contains(dst[:nDst], []byte(utf8ReplacementCharSeq)) {
+		if b, encErr := d.enc.NewEncoder().Bytes(dst[:nDst]); encErr != nil || !bytes.Equal(b, src[:nSrc]) {
+			return 0, 0, errInvalidCharsetInput
+		}
+	}
+	return nDst, nSrc, err
+}
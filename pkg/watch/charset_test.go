@@ -0,0 +1,67 @@
+package watch
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func convertCharset(t *testing.T, c *charsetSpec, lineEndings, in string) (string, error) {
+	t.Helper()
+
+	normalize := func(r io.Reader) io.Reader { return newLineEndingReader(lineEndings, r, nil) }
+	r, err := newCharsetReader(c, "", strings.NewReader(in), normalize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	return string(out), err
+}
+
+func TestCharsetKeepsLiteralReplacementChar(t *testing.T) {
+	out, err := convertCharset(t, &charsetSpec{From: "utf-8", To: "utf-8"}, "", "a�b")
+	if err != nil {
+		t.Fatalf("replacement character in valid input was rejected: %s", err)
+	}
+	if out != "a�b" {
+		t.Errorf("converted to %q", out)
+	}
+}
+
+func TestCharsetFailsOnInvalidInput(t *testing.T) {
+	for name, c := range map[string]*charsetSpec{
+		"utf-8":    {From: "utf-8", To: "iso-8859-1"},
+		"utf-16le": {From: "utf-16le", To: "utf-8"},
+	} {
+		in := "ok\xff"
+		if name == "utf-16le" {
+			// An unpaired surrogate
+			in = "o\x00\x00\xd8k\x00"
+		}
+		if _, err := convertCharset(t, c, "", in); err == nil {
+			t.Errorf("%s: invalid input was accepted", name)
+		}
+	}
+}
+
+func TestCharsetNormalizesLineEndingsOfDecodedText(t *testing.T) {
+	// UTF-16 input and output contain NUL bytes, which would be taken
+	// for a binary file if the line endings were converted outside the
+	// decoded text
+	out, err := convertCharset(t, &charsetSpec{From: "utf-16le", To: "utf-16le"}, lineEndingsLF, "a\x00\r\x00\n\x00b\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\x00\n\x00b\x00"; out != want {
+		t.Errorf("converted to %q, want %q", out, want)
+	}
+
+	out, err = convertCharset(t, &charsetSpec{From: "iso-8859-1"}, lineEndingsCRLF, "caf\xe9\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "café\r\n"; out != want {
+		t.Errorf("converted to %q, want %q", out, want)
+	}
+}
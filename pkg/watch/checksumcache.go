@@ -0,0 +1,130 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+type checksumCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Inode   uint64    `json:"inode"`
+	SHA256  string    `json:"sha256"`
+}
+
+func (c checksumCacheEntry) matches(fi os.FileInfo) bool {
+	return c.Size == fi.Size() &&
+		c.ModTime.Equal(fi.ModTime()) &&
+		c.Inode == fileInode(fi)
+}
+
+// checksumCache remembers the checksums of local files together with
+// their size, mtime and inode to avoid reading unchanged files again
+type checksumCache struct {
+	mu      sync.Mutex
+	entries map[string]checksumCacheEntry
+}
+
+func newChecksumCache() *checksumCache {
+	return &checksumCache{entries: make(map[string]checksumCacheEntry)}
+}
+
+// Sum returns the SHA256 of the file, reusing the cached value if the
+// file was not modified since it was calculated
+func (c *checksumCache) Sum(filePath string) (string, bool) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		c.Forget(filePath)
+		return "", false
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[filePath]
+	c.mu.Unlock()
+
+	if ok && e.matches(fi) {
+		return e.SHA256, true
+	}
+
+	sum, ok := calculateFileSha256(filePath)
+	if !ok {
+		c.Forget(filePath)
+		return "", false
+	}
+
+	c.store(filePath, fi, sum)
+	return sum, true
+}
+
+// Store records the checksum of a file which was just written
+func (c *checksumCache) Store(filePath, sum string) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		c.Forget(filePath)
+		return
+	}
+
+	c.store(filePath, fi, sum)
+}
+
+func (c *checksumCache) store(filePath string, fi os.FileInfo, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[filePath] = checksumCacheEntry{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Inode:   fileInode(fi),
+		SHA256:  sum,
+	}
+}
+
+// Forget removes the cached checksum of the file
+func (c *checksumCache) Forget(filePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, filePath)
+}
+
+// Export returns a copy of all cached checksums
+func (c *checksumCache) Export() map[string]checksumCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := make(map[string]checksumCacheEntry, len(c.entries))
+	for k, v := range c.entries {
+		res[k] = v
+	}
+	return res
+}
+
+// Import adds the given entries to the cache without replacing newer
+// ones, entries are still validated against the file on use
+func (c *checksumCache) Import(entries map[string]checksumCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, v := range entries {
+		if _, ok := c.entries[k]; !ok {
+			c.entries[k] = v
+		}
+	}
+}
+
+func calculateFileSha256(filePath string) (string, bool) {
+	if _, err := os.Stat(filePath); err != nil {
+		return "", false
+	}
+
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(raw)), true
+}
@@ -0,0 +1,54 @@
+package watch
+
+import "time"
+
+// clockJumpThreshold is how far the wall clock has to be changed while
+// the scheduler sleeps for the change to be logged
+const clockJumpThreshold = 10 * time.Second
+
+// clock is the source of the current time of the scheduler, tests
+// replace it to simulate changes of the wall clock
+type clock interface {
+	// Now returns the current wall clock time
+	Now() time.Time
+	// Since returns the time elapsed since t, which was returned by
+	// Now, unaffected by changes of the wall clock
+	Since(t time.Time) time.Duration
+}
+
+// systemClock uses the monotonic clock reading of time.Now
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// clock returns the clock of the scheduler
+func (c *configFile) clock() clock {
+	if c.clk == nil {
+		return systemClock{}
+	}
+	return c.clk
+}
+
+// withMonotonic returns t with a monotonic clock reading so durations
+// computed from it are not affected by later changes of the wall clock.
+// Times in the future were recorded before the wall clock was set back,
+// they are moved to now and reported by the second return value.
+func withMonotonic(clk clock, t time.Time) (time.Time, bool) {
+	if t.IsZero() {
+		return t, false
+	}
+
+	now := clk.Now()
+	if t.After(now) {
+		return now, true
+	}
+	return now.Add(-now.Sub(t)), false
+}
+
+// wallClockJump returns how far the wall clock was changed since the
+// time, which has to be returned by Now of the clock
+func wallClockJump(clk clock, since time.Time) time.Duration {
+	elapsed := clk.Since(since)
+	return clk.Now().Round(0).Sub(since.Round(0)) - elapsed
+}
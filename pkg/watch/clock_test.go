@@ -0,0 +1,131 @@
+package watch
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// fakeClock is a clock whose wall clock can be changed independently of
+// the elapsed time
+type fakeClock struct {
+	mu   sync.Mutex
+	wall time.Time
+	// elapsed is the monotonic time, readings maps the results of Now
+	// to it
+	elapsed  time.Duration
+	readings map[int64]time.Duration
+	// pending is applied to the wall clock with the next call of Since
+	pending time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		wall:     time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		readings: make(map[int64]time.Duration),
+	}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.readings[f.wall.UnixNano()] = f.elapsed
+	return f.wall
+}
+
+func (f *fakeClock) Since(t time.Time) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.wall = f.wall.Add(f.pending)
+	f.pending = 0
+	return f.elapsed - f.readings[t.UnixNano()]
+}
+
+// advance lets time pass
+func (f *fakeClock) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.wall = f.wall.Add(d)
+	f.elapsed += d
+}
+
+// jump changes the wall clock only
+func (f *fakeClock) jump(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.wall = f.wall.Add(d)
+}
+
+func TestWallClockJump(t *testing.T) {
+	for _, jump := range []time.Duration{0, time.Hour, -time.Hour, -30 * time.Second} {
+		clk := newFakeClock()
+		since := clk.Now()
+		clk.advance(5 * time.Second)
+		clk.jump(jump)
+		clk.advance(5 * time.Second)
+
+		if got := wallClockJump(clk, since); got != jump {
+			t.Errorf("wall clock jumped by %s, reported %s", jump, got)
+		}
+	}
+}
+
+func TestWithMonotonic(t *testing.T) {
+	clk := newFakeClock()
+	now := clk.Now()
+
+	past := now.Add(-time.Hour)
+	if got, future := withMonotonic(clk, past); future || !got.Equal(past) {
+		t.Errorf("time before now became %s (future %t)", got, future)
+	}
+
+	// Recorded before the clock was set back by a day
+	if got, future := withMonotonic(clk, now.Add(24*time.Hour)); !future || !got.Equal(now) {
+		t.Errorf("time after now became %s (future %t), want now", got, future)
+	}
+
+	if got, future := withMonotonic(clk, time.Time{}); future || !got.IsZero() {
+		t.Errorf("zero time became %s (future %t)", got, future)
+	}
+}
+
+func TestRestoreAfterClockSetBack(t *testing.T) {
+	clk := newFakeClock()
+	target := filepath.Join(t.TempDir(), "file")
+	w := newTestWatcher(t, "files:\n  "+target+":\n    url: http://127.0.0.1:1/\n    fetch_interval: 1h\n")
+	fc := w.lookup(target)
+
+	fc.restore(clk, target, fileState{LastSuccess: clk.Now().Add(24 * time.Hour)})
+	if got := fc.snapshot().lastCall; !got.Equal(clk.Now()) {
+		t.Errorf("last success in the future was restored as %s, want now", got)
+	}
+}
+
+func TestSchedulerReschedulesAfterClockJump(t *testing.T) {
+	for _, jump := range []time.Duration{time.Hour, -time.Hour} {
+		logs := captureLog(t)
+		clk := newFakeClock()
+		target := filepath.Join(t.TempDir(), "file")
+		w := newTestWatcher(t, "files:\n  "+target+":\n    url: http://127.0.0.1:1/\n    fetch_interval: 1h\n")
+		w.config.clk = clk
+		clk.pending = jump
+
+		ctx, cancel := context.WithCancel(context.Background())
+		// The never fetched entry is due at once, the first wakeup is
+		// skipped for the jump and the entry rescheduled
+		expectWakeup(t, w.config.WaitNextExecution(ctx), time.Second)
+		cancel()
+
+		if want := "Wall clock jumped by " + jump.String(); !strings.Contains(logs.String(), want) {
+			t.Errorf("log does not contain %q:\n%s", want, logs)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	commandEnvInherit = "inherit"
+	commandEnvClean   = "clean"
+)
+
+func (c *configFile) validateCommandEnv() error {
+	switch c.CommandEnvMode {
+	case "", commandEnvInherit, commandEnvClean:
+	default:
+		return fmt.Errorf("command_env_mode %q is unknown, use inherit or clean", c.CommandEnvMode)
+	}
+
+	for _, name := range c.CommandEnvAllow {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("Invalid command_env_allow entry %q", name)
+		}
+	}
+	return nil
+}
+
+func (c *configFileSource) validateCommandEnv() error {
+	for name := range c.CommandEnv {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("Invalid command_env variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// commandEnv builds the environment of a command of the entry, fc is nil
+// for global commands. Later values win: the environment of the daemon
+// (all of it or only command_env_allow), then the command_env of the
+// entry and finally the DW_* variables. The caller holds the read lock.
+func (c *configFile) commandEnv(fc *configFileSource, dw ...string) []string {
+	var env []string
+	if c.CommandEnvMode == commandEnvClean {
+		for _, name := range c.CommandEnvAllow {
+			if v, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+v)
+			}
+		}
+	} else {
+		env = os.Environ()
+	}
+
+	if fc != nil {
+		names := make([]string, 0, len(fc.CommandEnv))
+		for name := range fc.CommandEnv {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			env = append(env, name+"="+os.ExpandEnv(fc.CommandEnv[name]))
+		}
+	}
+
+	return append(env, dw...)
+}
+
+func stringMapEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
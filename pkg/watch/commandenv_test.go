@@ -0,0 +1,107 @@
+package watch
+
+import (
+	"strings"
+	"testing"
+)
+
+// effectiveEnv resolves duplicates like os/exec, the last value wins
+func effectiveEnv(env []string) map[string]string {
+	res := make(map[string]string, len(env))
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		res[parts[0]] = parts[1]
+	}
+	return res
+}
+
+func TestCommandEnvPrecedence(t *testing.T) {
+	for name, value := range map[string]string{
+		"DW_TEST_INHERITED": "daemon",
+		"DW_TEST_ALLOWED":   "daemon",
+		"DW_TEST_OVERRIDE":  "daemon",
+		"DW_PATH":           "daemon",
+	} {
+		t.Setenv(name, value)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		mode       string
+		allow      []string
+		commandEnv map[string]string
+		want       map[string]string
+		absent     []string
+	}{
+		{
+			name: "inherit",
+			want: map[string]string{"DW_TEST_INHERITED": "daemon", "DW_TEST_OVERRIDE": "daemon", "DW_PATH": "/target"},
+		},
+		{
+			name: "explicit inherit",
+			mode: commandEnvInherit,
+			want: map[string]string{"DW_TEST_INHERITED": "daemon", "DW_PATH": "/target"},
+		},
+		{
+			name:   "clean",
+			mode:   commandEnvClean,
+			want:   map[string]string{"DW_PATH": "/target"},
+			absent: []string{"DW_TEST_INHERITED", "DW_TEST_ALLOWED", "DW_TEST_OVERRIDE"},
+		},
+		{
+			name:   "clean with command_env_allow",
+			mode:   commandEnvClean,
+			allow:  []string{"DW_TEST_ALLOWED", "DW_TEST_UNSET"},
+			want:   map[string]string{"DW_TEST_ALLOWED": "daemon", "DW_PATH": "/target"},
+			absent: []string{"DW_TEST_INHERITED", "DW_TEST_UNSET"},
+		},
+		{
+			name:       "command_env overrides the inherited environment",
+			commandEnv: map[string]string{"DW_TEST_OVERRIDE": "entry", "DW_TEST_EXPANDED": "${DW_TEST_INHERITED}-entry"},
+			want:       map[string]string{"DW_TEST_OVERRIDE": "entry", "DW_TEST_EXPANDED": "daemon-entry"},
+		},
+		{
+			name:       "command_env overrides command_env_allow",
+			mode:       commandEnvClean,
+			allow:      []string{"DW_TEST_ALLOWED"},
+			commandEnv: map[string]string{"DW_TEST_ALLOWED": "entry"},
+			want:       map[string]string{"DW_TEST_ALLOWED": "entry"},
+		},
+		{
+			name:       "DW variables override command_env",
+			commandEnv: map[string]string{"DW_PATH": "entry"},
+			want:       map[string]string{"DW_PATH": "/target"},
+		},
+		{
+			name:       "DW variables override the clean environment",
+			mode:       commandEnvClean,
+			allow:      []string{"DW_PATH"},
+			commandEnv: map[string]string{"DW_PATH": "entry"},
+			want:       map[string]string{"DW_PATH": "/target"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &configFile{CommandEnvMode: tc.mode, CommandEnvAllow: tc.allow}
+			fc := &configFileSource{CommandEnv: tc.commandEnv}
+
+			env := effectiveEnv(c.commandEnv(fc, "DW_PATH=/target"))
+			for name, want := range tc.want {
+				if got, ok := env[name]; !ok || got != want {
+					t.Errorf("%s is %q (set %t), want %q", name, got, ok, want)
+				}
+			}
+			for _, name := range tc.absent {
+				if got, ok := env[name]; ok {
+					t.Errorf("%s is set to %q", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandEnvWithoutEntry(t *testing.T) {
+	c := &configFile{CommandEnvMode: commandEnvClean}
+	if env := c.commandEnv(nil, "DW_EVENT=failed"); len(env) != 1 || env[0] != "DW_EVENT=failed" {
+		t.Errorf("environment of a global command is %v", env)
+	}
+}
@@ -0,0 +1,38 @@
+package watch
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func validateCommandWrapper(wrapper []string) error {
+	if len(wrapper) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(wrapper[0]); err != nil {
+		return fmt.Errorf("command_wrapper %q not found: %s", wrapper[0], err)
+	}
+	return nil
+}
+
+// commandLine returns the argv running the command of the entry through
+// the command_shell, prefixed by the command_wrapper. The wrapper of the
+// entry overrides the global one, an empty list disables it. fc is nil
+// for global commands. The caller holds the read lock.
+func (c *configFile) commandLine(fc *configFileSource, command string) []string {
+	wrapper := c.CommandWrapper
+	if fc != nil && fc.CommandWrapper != nil {
+		wrapper = fc.CommandWrapper
+	}
+
+	argv := make([]string, 0, len(wrapper)+len(c.CommandShell)+1)
+	argv = append(argv, wrapper...)
+	argv = append(argv, c.CommandShell...)
+	return append(argv, command)
+}
+
+// wrapperEqual compares two wrappers, an unset wrapper differs from a
+// disabled one
+func wrapperEqual(a, b []string) bool {
+	return (a == nil) == (b == nil) && stringList(a).Equals(b)
+}
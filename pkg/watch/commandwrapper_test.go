@@ -0,0 +1,60 @@
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandWrapperAppliesToAllCommands(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	wrapped := filepath.Join(dir, "wrapped")
+	wrapper := filepath.Join(dir, "wrapper")
+	// Records the first statement of every wrapped command, which names
+	// the command
+	script := fmt.Sprintf("#!/bin/sh\nfor last; do :; done\necho \"${last%%%%;*}\" >> %s\nexec \"$@\"\n", wrapped)
+	if err := ioutil.WriteFile(wrapper, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "file")
+	w := newTestWatcher(t, fmt.Sprintf(`command_wrapper: [%s]
+notify_command: "CMD=notify; cat > /dev/null"
+files:
+  %s:
+    url_command: "CMD=url; echo %s"
+    transform_command: "CMD=transform; cat"
+    success_command: "CMD=success; true"
+`, wrapper, target, srv.URL))
+	if err := w.config.runFetch(context.Background(), target, w.lookup(target), false); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"CMD=notify", "CMD=success", "CMD=transform", "CMD=url"}
+	var got []string
+	// success_command and notify_command run in the background
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		out, _ := ioutil.ReadFile(wrapped)
+		got = strings.Fields(string(out))
+		if len(got) >= len(want) {
+			break
+		}
+	}
+	sort.Strings(got)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("wrapped commands are %v, want %v", got, want)
+	}
+}
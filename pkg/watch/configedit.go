@@ -0,0 +1,197 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// FileEntry is an entry written to the configuration file by AddFile,
+// empty fields are left out
+type FileEntry struct {
+	Path           string
+	URL            string
+	FetchInterval  time.Duration
+	SHA256         string
+	SuccessCommand string
+}
+
+// AddFile adds the entry to the files of the configuration file, an
+// existing entry of the path is only replaced if replace is set. The
+// changed configuration is validated before it replaces the file, the
+// rest of the file including comments is kept as far as possible. The
+// overrides of opts are not applied.
+func AddFile(configPath string, opts Options, entry FileEntry, replace bool) error {
+	if entry.Path == "" || entry.URL == "" {
+		return errors.New("Path and URL of the entry are required")
+	}
+
+	value := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+	addScalar(value, "url", entry.URL)
+	if entry.FetchInterval > 0 {
+		addScalar(value, "fetch_interval", shortDuration(entry.FetchInterval))
+	}
+	addScalar(value, "sha256", entry.SHA256)
+	addScalar(value, "success_command", entry.SuccessCommand)
+
+	return editConfig(configPath, opts, func(files *yamlv3.Node) error {
+		if i := mappingIndex(files, entry.Path); i >= 0 {
+			if !replace {
+				return fmt.Errorf("File '%s' is already configured", entry.Path)
+			}
+			files.Content[i+1] = value
+			return nil
+		}
+
+		key := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: entry.Path}
+		files.Content = append(files.Content, key, value)
+		return nil
+	})
+}
+
+// RemoveFile removes the entry of the path from the configuration file
+func RemoveFile(configPath string, opts Options, filePath string) error {
+	return editConfig(configPath, opts, func(files *yamlv3.Node) error {
+		i := mappingIndex(files, filePath)
+		if i < 0 {
+			return ErrUnknownFile
+		}
+		files.Content = append(files.Content[:i], files.Content[i+2:]...)
+		return nil
+	})
+}
+
+// editConfig applies the edit to the files mapping of the configuration
+// file, validates the result and atomically replaces the file with it
+func editConfig(configPath string, opts Options, edit func(files *yamlv3.Node) error) error {
+	raw, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		doc = yamlv3.Node{Kind: yamlv3.DocumentNode, Content: []*yamlv3.Node{{Kind: yamlv3.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yamlv3.MappingNode {
+		return errors.New("Config is no mapping")
+	}
+
+	files := mappingValue(root, "files")
+	if files == nil {
+		files = &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "files"}, files)
+	}
+	if files.Kind != yamlv3.MappingNode {
+		return errors.New("files is no mapping")
+	}
+	// An empty "files: {}" would keep all entries on one line
+	files.Style = 0
+
+	if err := edit(files); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	enc.Close()
+
+	out := buf.Bytes()
+	if bytes.HasPrefix(raw, []byte("---")) && !bytes.HasPrefix(out, []byte("---")) {
+		out = append([]byte("---\n"), out...)
+	}
+
+	if _, err := parseConfig(out, Options{AgeIdentity: opts.AgeIdentity}); err != nil {
+		return fmt.Errorf("Changed config is invalid: %s", err)
+	}
+	return writeConfigFile(configPath, out)
+}
+
+// writeConfigFile replaces the configuration file by a temp file with
+// the same mode, symlinks to the file are kept
+func writeConfigFile(configPath string, content []byte) error {
+	if real, err := filepath.EvalSymlinks(configPath); err == nil {
+		configPath = real
+	}
+
+	stat, err := os.Stat(configPath)
+	if err != nil {
+		return err
+	}
+
+	t, err := ioutil.TempFile(filepath.Dir(configPath), "."+filepath.Base(configPath))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(t.Name())
+	defer t.Close()
+
+	if _, err := t.Write(content); err != nil {
+		return err
+	}
+	if err := t.Chmod(stat.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := t.Sync(); err != nil {
+		return err
+	}
+	if err := t.Close(); err != nil {
+		return err
+	}
+	return replaceFile(t.Name(), configPath)
+}
+
+// mappingIndex returns the index of the key node in the mapping or -1
+func mappingIndex(m *yamlv3.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func mappingValue(m *yamlv3.Node, key string) *yamlv3.Node {
+	if i := mappingIndex(m, key); i >= 0 {
+		return m.Content[i+1]
+	}
+	return nil
+}
+
+func addScalar(m *yamlv3.Node, key, value string) {
+	if value == "" {
+		return
+	}
+	m.Content = append(m.Content,
+		&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key},
+		&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: value},
+	)
+}
+
+// shortDuration formats the duration without trailing zero units, 5m
+// instead of 5m0s
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
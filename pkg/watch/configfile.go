@@ -0,0 +1,1630 @@
+package watch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	defaultFetchTimeout = 30 * time.Second
+	defaultUserAgent    = "download-watch/{{version}}"
+
+	onMissingKeep   = "keep"
+	onMissingDelete = "delete"
+	onMissingEmpty  = "empty"
+)
+
+type configFile struct {
+	sync.RWMutex
+
+	Files           map[string]*configFileSource `yaml:"files"`
+	CommandShell    []string                     `yaml:"command_shell"`
+	CommandEnvMode  string                       `yaml:"command_env_mode"`
+	CommandEnvAllow []string                     `yaml:"command_env_allow"`
+	CommandWrapper  []string                     `yaml:"command_wrapper"`
+	MaxTotalRate    byteSize                     `yaml:"max_total_rate"`
+	CopyBufferSize  byteSize                     `yaml:"copy_buffer_size"`
+
+	MaxConcurrentDownloads int               `yaml:"max_concurrent_downloads"`
+	MaxPerHost             int               `yaml:"max_per_host"`
+	MaxPerHostOverrides    map[string]int    `yaml:"max_per_host_overrides"`
+	HistorySize            int               `yaml:"history_size"`
+	Proxy                  string            `yaml:"proxy"`
+	NoProxy                []string          `yaml:"no_proxy"`
+	CAFile                 string            `yaml:"ca_file"`
+	CADir                  string            `yaml:"ca_dir"`
+	IPFamily               string            `yaml:"ip_family"`
+	BindAddress            string            `yaml:"bind_address"`
+	Resolve                map[string]string `yaml:"resolve"`
+	UserAgent              string            `yaml:"user_agent"`
+	AllowedHosts           []string          `yaml:"allowed_hosts"`
+	AllowedSchemes         []string          `yaml:"allowed_schemes"`
+	AllowedTargetRoots     []string          `yaml:"allowed_target_roots"`
+	BlockPrivateAddresses  bool              `yaml:"block_private_addresses"`
+	AllowPrivateHosts      []string          `yaml:"allow_private_hosts"`
+
+	ClientCertExpiryWarning time.Duration `yaml:"client_cert_expiry_warning"`
+	StartupDeadline         time.Duration `yaml:"startup_deadline"`
+	NotifyWebhook           *webhook      `yaml:"notify_webhook"`
+	TriggerSecret           string        `yaml:"trigger_secret"`
+	LogDiff                 bool          `yaml:"log_diff"`
+	SkipInitialCommand      bool          `yaml:"skip_initial_command"`
+	NotifyCommand           string        `yaml:"notify_command"`
+	NotifyCommandTimeout    time.Duration `yaml:"notify_command_timeout"`
+
+	Notifications notificationsConfig `yaml:"notifications"`
+	MQTT          *mqttConfig         `yaml:"mqtt"`
+
+	rootCAs   *x509.CertPool
+	log       *logger
+	hostname  string
+	buffers   *bufferPool
+	checksums *checksumCache
+	fetchers  *fetcherRegistry
+	events    eventStream
+	state     *stateFile
+	totalRate *rateLimiter
+	pool      *downloadPool
+	webhooks  *notifyQueue
+	slack     *notifyQueue
+	emails    *emailBatcher
+	notifiers *commandNotifier
+	hooks     *hookQueue
+	schedule  *scheduleQueue
+	wakeup    chan struct{}
+	clk       clock
+	mqtt      *mqttClient
+	// ctx is cancelled once Run stops, commands running in the
+	// background derive from it
+	ctx  context.Context
+	stop context.CancelFunc
+	// paused stops the scheduling of all entries
+	paused bool
+	// starting is set while the first fetches are held off
+	starting bool
+
+	// slackLimiter is kept across reloads to not reset the budget
+	slackLimiter messageLimiter
+	// rejectedTriggers counts pushes to the webhook listener with an
+	// invalid signature
+	rejectedTriggers int64
+}
+
+type configFileSource struct {
+	BasicAuth              string            `yaml:"basic_auth"`
+	BearerToken            string            `yaml:"bearer_token"`
+	RejectOlder            bool              `yaml:"reject_older"`
+	VersionHeader          string            `yaml:"version_header"`
+	SuccessCommand         string            `yaml:"success_command"`
+	CommandEnv             map[string]string `yaml:"command_env"`
+	CommandWrapper         []string          `yaml:"command_wrapper"`
+	Timeout                time.Duration     `yaml:"timeout"`
+	FetchInterval          time.Duration     `yaml:"fetch_interval"`
+	IgnoreETag             bool              `yaml:"ignore_etag"`
+	MaxRate                byteSize          `yaml:"max_rate"`
+	Proxy                  string            `yaml:"proxy"`
+	CAFile                 string            `yaml:"ca_file"`
+	CADir                  string            `yaml:"ca_dir"`
+	ClientCertFile         string            `yaml:"client_cert_file"`
+	ClientKeyFile          string            `yaml:"client_key_file"`
+	InsecureSkipVerify     bool              `yaml:"insecure_skip_verify"`
+	PinSHA256              stringList        `yaml:"pin_sha256"`
+	MaxRedirects           *int              `yaml:"max_redirects"`
+	RedirectSameHostOnly   bool              `yaml:"redirect_same_host_only"`
+	IPFamily               string            `yaml:"ip_family"`
+	BindAddress            string            `yaml:"bind_address"`
+	UserAgent              string            `yaml:"user_agent"`
+	SuccessMarker          string            `yaml:"success_marker"`
+	Required               bool              `yaml:"required"`
+	FailureThresholds      []int             `yaml:"failure_thresholds"`
+	FailureCommand         string            `yaml:"failure_command"`
+	NotifyWebhook          *webhook          `yaml:"notify_webhook"`
+	MuteNotifications      bool              `yaml:"mute_notifications"`
+	Alias                  string            `yaml:"alias"`
+	TriggerTopic           string            `yaml:"trigger_topic"`
+	MirrorTo               string            `yaml:"mirror_to"`
+	MirrorTimeout          time.Duration     `yaml:"mirror_timeout"`
+	PingURL                string            `yaml:"ping_url"`
+	PingOnFailure          bool              `yaml:"ping_on_failure"`
+	Decompress             string            `yaml:"decompress"`
+	AcceptCompression      bool              `yaml:"accept_compression"`
+	Accept                 string            `yaml:"accept"`
+	NormalizeLineEndings   string            `yaml:"normalize_line_endings"`
+	ConvertCharset         *charsetSpec      `yaml:"convert_charset"`
+	TransformCommand       string            `yaml:"transform_command"`
+	TransformTimeout       time.Duration     `yaml:"transform_timeout"`
+	TransformMaxSize       byteSize          `yaml:"transform_max_size"`
+	ChecksumOf             string            `yaml:"checksum_of"`
+	Extract                string            `yaml:"extract"`
+	ExtractTo              string            `yaml:"extract_to"`
+	ExtractMember          string            `yaml:"extract_member"`
+	JSONPath               string            `yaml:"json_path"`
+	LogDiff                *bool             `yaml:"log_diff"`
+	SkipInitialCommand     *bool             `yaml:"skip_initial_command"`
+	Mode                   string            `yaml:"mode"`
+	FileMode               fileMode          `yaml:"file_mode"`
+	DirMode                fileMode          `yaml:"dir_mode"`
+	MaxTargetSize          byteSize          `yaml:"max_target_size"`
+	OnMaxTargetSize        string            `yaml:"on_max_target_size"`
+	StripComponents        int               `yaml:"strip_components"`
+	DiscardArchive         bool              `yaml:"discard_archive"`
+	MaxStaleness           time.Duration     `yaml:"max_staleness"`
+	MaxAge                 time.Duration     `yaml:"max_age"`
+	OnMissing              string            `yaml:"on_missing"`
+	BootstrapRetryInterval time.Duration     `yaml:"bootstrap_retry_interval"`
+	ConnectTimeout         time.Duration     `yaml:"connect_timeout"`
+	ResponseHeaderTimeout  time.Duration     `yaml:"response_header_timeout"`
+	IdleReadTimeout        time.Duration     `yaml:"idle_read_timeout"`
+	MinThroughput          byteSize          `yaml:"min_throughput"`
+	MinThroughputWindow    time.Duration     `yaml:"min_throughput_window"`
+	MinThroughputGrace     time.Duration     `yaml:"min_throughput_grace"`
+	CheckInterval          time.Duration     `yaml:"check_interval"`
+	WatchOnly              bool              `yaml:"watch_only"`
+	SHA256                 string            `yaml:"sha256"`
+	URL                    string            `yaml:"url"`
+	URLs                   stringList        `yaml:"urls"`
+	URLCommand             string            `yaml:"url_command"`
+	URLCommandTimeout      time.Duration     `yaml:"url_command_timeout"`
+	Method                 string            `yaml:"method"`
+	RequestBody            string            `yaml:"request_body"`
+	RequestBodyFile        string            `yaml:"request_body_file"`
+	ContentType            string            `yaml:"content_type"`
+	ConditionalRequests    bool              `yaml:"conditional_requests"`
+	RetryNonIdempotent     bool              `yaml:"retry_non_idempotent"`
+	AsyncPoll              *asyncPoll        `yaml:"async_poll"`
+	Mirror                 *dirMirror        `yaml:"mirror"`
+	FallbackURLs           stringList        `yaml:"fallback_urls"`
+	DateOffset             time.Duration     `yaml:"date_offset"`
+	DateTimezone           string            `yaml:"date_timezone"`
+	DateFallbackDays       int               `yaml:"date_fallback_days"`
+	LogLevel               string            `yaml:"log_level"`
+
+	rootCAs    *x509.CertPool
+	clientCert *tls.Certificate
+
+	runMu sync.Mutex
+	runState
+
+	log     *logger
+	buffers *bufferPool
+
+	stateMu      sync.Mutex
+	lastAttempt  time.Time
+	errorState   fileErrorState
+	history      []FetchRecord
+	maxAgeWarned bool
+	triggered    bool
+	forced       bool
+	paused       bool
+	mirror       *MirrorStatus
+	ping         *PingStatus
+	sync         *SyncStatus
+	resolvedURL  string
+
+	progress downloadProgress
+}
+
+func (c *configFileSource) Equals(in *configFileSource) bool {
+	return c.BasicAuth == in.BasicAuth &&
+		c.BearerToken == in.BearerToken &&
+		c.RejectOlder == in.RejectOlder &&
+		c.VersionHeader == in.VersionHeader &&
+		c.SuccessCommand == in.SuccessCommand &&
+		stringMapEqual(c.CommandEnv, in.CommandEnv) &&
+		wrapperEqual(c.CommandWrapper, in.CommandWrapper) &&
+		c.Timeout == in.Timeout &&
+		c.FetchInterval == in.FetchInterval &&
+		c.IgnoreETag == in.IgnoreETag &&
+		c.MaxRate == in.MaxRate &&
+		c.Proxy == in.Proxy &&
+		c.CAFile == in.CAFile &&
+		c.CADir == in.CADir &&
+		c.ClientCertFile == in.ClientCertFile &&
+		c.ClientKeyFile == in.ClientKeyFile &&
+		c.InsecureSkipVerify == in.InsecureSkipVerify &&
+		c.PinSHA256.Equals(in.PinSHA256) &&
+		intPtrEqual(c.MaxRedirects, in.MaxRedirects) &&
+		c.RedirectSameHostOnly == in.RedirectSameHostOnly &&
+		c.IPFamily == in.IPFamily &&
+		c.BindAddress == in.BindAddress &&
+		c.UserAgent == in.UserAgent &&
+		c.SuccessMarker == in.SuccessMarker &&
+		c.Required == in.Required &&
+		intsEqual(c.FailureThresholds, in.FailureThresholds) &&
+		c.FailureCommand == in.FailureCommand &&
+		webhookEqual(c.NotifyWebhook, in.NotifyWebhook) &&
+		c.MuteNotifications == in.MuteNotifications &&
+		c.Alias == in.Alias &&
+		c.TriggerTopic == in.TriggerTopic &&
+		c.MirrorTo == in.MirrorTo &&
+		c.MirrorTimeout == in.MirrorTimeout &&
+		c.PingURL == in.PingURL &&
+		c.PingOnFailure == in.PingOnFailure &&
+		c.Decompress == in.Decompress &&
+		c.AcceptCompression == in.AcceptCompression &&
+		c.Accept == in.Accept &&
+		c.NormalizeLineEndings == in.NormalizeLineEndings &&
+		charsetEqual(c.ConvertCharset, in.ConvertCharset) &&
+		c.TransformCommand == in.TransformCommand &&
+		c.TransformTimeout == in.TransformTimeout &&
+		c.TransformMaxSize == in.TransformMaxSize &&
+		c.ChecksumOf == in.ChecksumOf &&
+		c.Extract == in.Extract &&
+		c.ExtractTo == in.ExtractTo &&
+		c.ExtractMember == in.ExtractMember &&
+		c.JSONPath == in.JSONPath &&
+		boolPtrEqual(c.LogDiff, in.LogDiff) &&
+		boolPtrEqual(c.SkipInitialCommand, in.SkipInitialCommand) &&
+		c.Mode == in.Mode &&
+		c.FileMode == in.FileMode &&
+		c.DirMode == in.DirMode &&
+		c.MaxTargetSize == in.MaxTargetSize &&
+		c.OnMaxTargetSize == in.OnMaxTargetSize &&
+		c.StripComponents == in.StripComponents &&
+		c.DiscardArchive == in.DiscardArchive &&
+		c.MaxStaleness == in.MaxStaleness &&
+		c.MaxAge == in.MaxAge &&
+		c.OnMissing == in.OnMissing &&
+		c.BootstrapRetryInterval == in.BootstrapRetryInterval &&
+		c.ConnectTimeout == in.ConnectTimeout &&
+		c.ResponseHeaderTimeout == in.ResponseHeaderTimeout &&
+		c.IdleReadTimeout == in.IdleReadTimeout &&
+		c.MinThroughput == in.MinThroughput &&
+		c.MinThroughputWindow == in.MinThroughputWindow &&
+		c.MinThroughputGrace == in.MinThroughputGrace &&
+		c.CheckInterval == in.CheckInterval &&
+		c.WatchOnly == in.WatchOnly &&
+		c.SHA256 == in.SHA256 &&
+		c.URL == in.URL &&
+		c.URLs.Equals(in.URLs) &&
+		c.URLCommand == in.URLCommand &&
+		c.URLCommandTimeout == in.URLCommandTimeout &&
+		c.Method == in.Method &&
+		c.RequestBody == in.RequestBody &&
+		c.RequestBodyFile == in.RequestBodyFile &&
+		c.ContentType == in.ContentType &&
+		c.ConditionalRequests == in.ConditionalRequests &&
+		c.RetryNonIdempotent == in.RetryNonIdempotent &&
+		asyncPollEqual(c.AsyncPoll, in.AsyncPoll) &&
+		dirMirrorEqual(c.Mirror, in.Mirror) &&
+		c.FallbackURLs.Equals(in.FallbackURLs) &&
+		c.DateOffset == in.DateOffset &&
+		c.DateTimezone == in.DateTimezone &&
+		c.DateFallbackDays == in.DateFallbackDays &&
+		c.LogLevel == in.LogLevel
+}
+
+// userAgent returns the User-Agent header to send for the source
+func (c *configFile) userAgent(src *configFileSource) string {
+	ua := defaultUserAgent
+	if c.UserAgent != "" {
+		ua = c.UserAgent
+	}
+	if src.UserAgent != "" {
+		ua = src.UserAgent
+	}
+
+	return strings.Replace(ua, "{{version}}", Version, -1)
+}
+
+// touchSuccessMarker creates the marker file or updates its mtime so
+// external monitoring can check the age of the last successful fetch
+func (c *configFileSource) touchSuccessMarker() {
+	if c.SuccessMarker == "" {
+		return
+	}
+
+	f, err := os.OpenFile(c.SuccessMarker, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		c.logf(levelWarn, "Could not create success marker '%s': %s", c.SuccessMarker, err)
+		return
+	}
+	f.Close()
+
+	now := time.Now()
+	if err := os.Chtimes(c.SuccessMarker, now, now); err != nil {
+		c.logf(levelWarn, "Could not update success marker '%s': %s", c.SuccessMarker, err)
+	}
+}
+
+func intPtrEqual(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func boolPtrEqual(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *configFileSource) host() string {
+	u, err := url.Parse(c.sourceURL())
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+func loadConfigFile(filePath string, opts Options) (*configFile, error) {
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConfig(raw, opts)
+}
+
+// parseConfig decrypts, parses and validates the content of a
+// configuration file after applying the overrides
+func parseConfig(raw []byte, opts Options) (*configFile, error) {
+	overrides, err := parseOverrides(opts.Overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	l := newLogger(opts.Debug, opts.Quiet)
+	if raw, err = decryptConfig(raw, opts.AgeIdentity, l.secrets); err != nil {
+		return nil, err
+	}
+
+	if raw, err = applyOverrides(raw, overrides, false); err != nil {
+		return nil, err
+	}
+
+	res := newConfigFile(l)
+	if err := yaml.Unmarshal(raw, res); err != nil {
+		return nil, err
+	}
+	for _, fc := range res.Files {
+		fc.log, fc.buffers = l, res.buffers
+	}
+
+	if err := res.validate(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// newConfigFile returns an empty configuration with its own checksum
+// cache and the built-in fetchers
+func newConfigFile(log *logger) *configFile {
+	return &configFile{
+		Files:     make(map[string]*configFileSource),
+		log:       log,
+		buffers:   newBufferPool(defaultCopyBufferSize),
+		checksums: newChecksumCache(),
+		fetchers:  newFetcherRegistry(),
+	}
+}
+
+func (c *configFile) validate() error {
+	var err error
+
+	if err = validateProxyURL(c.Proxy); err != nil {
+		return fmt.Errorf("Global proxy: %s", err)
+	}
+
+	if c.rootCAs, err = loadCertPool(c.CAFile, c.CADir); err != nil {
+		return fmt.Errorf("Global CA: %s", err)
+	}
+
+	if err = validateDialOptions(c.IPFamily, c.BindAddress); err != nil {
+		return fmt.Errorf("Global: %s", err)
+	}
+
+	if err = validateResolve(c.Resolve); err != nil {
+		return err
+	}
+
+	if err = validateAllowlist(c.AllowedHosts, c.AllowedSchemes); err != nil {
+		return fmt.Errorf("Global: %s", err)
+	}
+
+	if err = validateTargetRoots(c.AllowedTargetRoots); err != nil {
+		return fmt.Errorf("Global: %s", err)
+	}
+
+	if err = c.validateCommandEnv(); err != nil {
+		return fmt.Errorf("Global: %s", err)
+	}
+
+	if err = validateCommandWrapper(c.CommandWrapper); err != nil {
+		return fmt.Errorf("Global: %s", err)
+	}
+
+	if err = validateAllowlist(c.AllowPrivateHosts, nil); err != nil {
+		return fmt.Errorf("Global: allow_private_hosts: %s", err)
+	}
+
+	if c.CopyBufferSize != 0 && c.CopyBufferSize < minCopyBufferSize {
+		return fmt.Errorf("Global: copy_buffer_size needs to be at least %d bytes", minCopyBufferSize)
+	}
+
+	if c.Notifications.Email != nil {
+		if err = c.Notifications.Email.validate(); err != nil {
+			return fmt.Errorf("Global: %s", err)
+		}
+	}
+
+	if c.MQTT != nil {
+		if err = c.MQTT.validate(); err != nil {
+			return fmt.Errorf("Global: %s", err)
+		}
+	}
+
+	var insecure []string
+	aliases := map[string]string{}
+	for filePath, fc := range c.Files {
+		if fc.Alias != "" {
+			if other, ok := aliases[fc.Alias]; ok {
+				return fmt.Errorf("File '%s': Alias %q is already used by '%s'", filePath, fc.Alias, other)
+			}
+			aliases[fc.Alias] = filePath
+		}
+
+		if fc.InsecureSkipVerify {
+			insecure = append(insecure, filePath)
+		}
+
+		if err = validateProxyURL(fc.Proxy); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = validateFileLogLevel(fc.LogLevel); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if fc.rootCAs, err = loadCertPool(fc.CAFile, fc.CADir); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		switch fc.OnMissing {
+		case "", onMissingKeep, onMissingDelete, onMissingEmpty:
+		default:
+			return fmt.Errorf("File '%s': Invalid on_missing %q, use keep, delete or empty", filePath, fc.OnMissing)
+		}
+
+		if err = validateDialOptions(fc.IPFamily, fc.BindAddress); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = validateFailureThresholds(fc.FailureThresholds); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if fc.ConvertCharset != nil {
+			if err = fc.ConvertCharset.validate(); err != nil {
+				return fmt.Errorf("File '%s': %s", filePath, err)
+			}
+		}
+
+		if err = validateLineEndings(fc.NormalizeLineEndings); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = validateDecompress(fc.Decompress); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if fc.JSONPath != "" {
+			if _, err = parseJSONPath(fc.JSONPath); err != nil {
+				return fmt.Errorf("File '%s': %s", filePath, err)
+			}
+		}
+
+		if err = fc.validateTransform(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateExtract(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateExtractMember(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateMode(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateFileModes(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateCredentials(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateCommandEnv(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateRejectOlder(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = validateCommandWrapper(fc.CommandWrapper); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateWatchOnly(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateURLCommand(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateURLs(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateDirMirror(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateFallbackURLs(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateAllowed(c.allowlist()); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.checkTargetRoots(c.AllowedTargetRoots, filePath); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateDateTemplate(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = fc.validateRequest(); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if fc.AsyncPoll != nil {
+			if err = fc.AsyncPoll.validate(); err != nil {
+				return fmt.Errorf("File '%s': %s", filePath, err)
+			}
+		}
+
+		if err = validatePingURL(fc.PingURL); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = validateMirrorTo(fc.MirrorTo); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if err = validatePins(fc.PinSHA256); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+
+		if fc.clientCert, err = loadClientCertificate(fc.ClientCertFile, fc.ClientKeyFile); err != nil {
+			return fmt.Errorf("File '%s': %s", filePath, err)
+		}
+		warnCertificateExpiry(c.log, filePath, fc.clientCert, c.ClientCertExpiryWarning)
+	}
+
+	if len(insecure) > 0 {
+		sort.Strings(insecure)
+		c.log.logf(levelWarn, "WARNING: TLS certificate verification is DISABLED (insecure_skip_verify) for: %s", strings.Join(insecure, ", "))
+	}
+
+	return nil
+}
+
+func (c *configFile) Patch(in *configFile) error {
+	if len(in.CommandShell) > 0 {
+		c.CommandShell = in.CommandShell
+	}
+	c.CommandEnvMode = in.CommandEnvMode
+	c.CommandEnvAllow = in.CommandEnvAllow
+	c.CommandWrapper = in.CommandWrapper
+
+	// The limiter is shared by all running downloads and only gets its
+	// rate changed so in-flight transfers pick up the new budget
+	c.MaxTotalRate = in.MaxTotalRate
+	if c.totalRate == nil {
+		c.totalRate = newRateLimiter(c.MaxTotalRate)
+	} else {
+		c.totalRate.SetRate(c.MaxTotalRate)
+	}
+
+	// Buffers are shared by all downloads of the Watcher, the ones of
+	// the old size are dropped when they are returned
+	c.CopyBufferSize = in.CopyBufferSize
+	c.buffers.SetSize(int(c.CopyBufferSize))
+
+	c.Proxy = in.Proxy
+	c.NoProxy = in.NoProxy
+	c.CAFile = in.CAFile
+	c.CADir = in.CADir
+	c.IPFamily = in.IPFamily
+	c.BindAddress = in.BindAddress
+	c.Resolve = in.Resolve
+	c.UserAgent = in.UserAgent
+	c.AllowedHosts = in.AllowedHosts
+	c.AllowedSchemes = in.AllowedSchemes
+	c.AllowedTargetRoots = in.AllowedTargetRoots
+	c.BlockPrivateAddresses = in.BlockPrivateAddresses
+	c.AllowPrivateHosts = in.AllowPrivateHosts
+	c.HistorySize = in.HistorySize
+	c.rootCAs = in.rootCAs
+	c.ClientCertExpiryWarning = in.ClientCertExpiryWarning
+	c.StartupDeadline = in.StartupDeadline
+	c.NotifyWebhook = in.NotifyWebhook
+	c.TriggerSecret = in.TriggerSecret
+	c.LogDiff = in.LogDiff
+	c.SkipInitialCommand = in.SkipInitialCommand
+	c.NotifyCommand = in.NotifyCommand
+	c.NotifyCommandTimeout = in.NotifyCommandTimeout
+	c.Notifications = in.Notifications
+	if c.emails == nil {
+		c.emails = &emailBatcher{config: c.emailConfig, host: c.hostname, queue: newNotifyQueue(c.log, "Email"), log: c.log}
+	}
+	if c.slack == nil {
+		c.slack = newNotifyQueue(c.log, "Slack")
+	}
+	if c.webhooks == nil {
+		c.webhooks = newNotifyQueue(c.log, "Webhook")
+	}
+	if c.notifiers == nil {
+		c.notifiers = newCommandNotifier(c.log)
+	}
+
+	c.MaxConcurrentDownloads = in.MaxConcurrentDownloads
+	if c.pool == nil {
+		c.pool = newDownloadPool(c.MaxConcurrentDownloads)
+	} else {
+		c.pool.SetSize(c.MaxConcurrentDownloads)
+	}
+
+	c.MaxPerHost = in.MaxPerHost
+	c.MaxPerHostOverrides = in.MaxPerHostOverrides
+	c.pool.SetHostLimits(c.MaxPerHost, c.MaxPerHostOverrides)
+
+	// The entries log through the Watcher, which masks the secrets of
+	// the new configuration from now on
+	if in.log != nil {
+		c.log.secrets.merge(in.log.secrets)
+	}
+	for _, fc := range in.Files {
+		fc.log, fc.buffers = c.log, c.buffers
+	}
+
+	for _, k := range excessKeys(c.Files, in.Files) {
+		c.Files[k].retire()
+		delete(c.Files, k)
+		c.checksums.Forget(k)
+	}
+
+	for _, k := range excessKeys(in.Files, c.Files) {
+		c.Files[k] = in.Files[k]
+	}
+
+	for k := range c.Files {
+		if !c.Files[k].Equals(in.Files[k]) {
+			if !c.Files[k].sameSource(in.Files[k]) {
+				c.Files[k].retire()
+			}
+			c.Files[k] = in.Files[k]
+			continue
+		}
+
+		// Certificates might have changed on disk without a change
+		// of the configuration
+		c.Files[k].rootCAs = in.Files[k].rootCAs
+		c.Files[k].clientCert = in.Files[k].clientCert
+	}
+
+	c.MQTT = in.MQTT
+	c.updateMQTT()
+
+	return nil
+}
+
+func excessKeys(a, b map[string]*configFileSource) (excess []string) {
+	for aKey := range a {
+		found := false
+		for bKey := range b {
+			if aKey == bKey {
+				found = true
+			}
+		}
+		if !found {
+			excess = append(excess, aKey)
+		}
+	}
+
+	return
+}
+
+// rootContext returns the context commands running in the background
+// derive from
+func (c *configFile) rootContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// WaitNextExecution sends on the returned channel whenever an entry is
+// due until ctx is done
+func (c *configFile) WaitNextExecution(ctx context.Context) <-chan time.Time {
+	res := make(chan time.Time)
+
+	go func() {
+		// Only changes of the wakeup are logged
+		var (
+			logged time.Time
+			idle   bool
+		)
+
+		c.schedule.markAllDirty()
+		for {
+			c.refreshSchedule()
+
+			at, ok := c.schedule.next()
+			if !ok {
+				if !idle {
+					c.log.debug("Nothing scheduled, waiting for changes...")
+				}
+				logged, idle = time.Time{}, true
+				select {
+				case <-c.wakeup:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			idle = false
+
+			sleep := time.Until(at)
+			if sleep < 0 {
+				sleep = 0
+			}
+			if sleep > maxScheduleSleep {
+				sleep = maxScheduleSleep
+			}
+
+			if !at.Equal(logged) {
+				c.log.debug("Sleeping for %s until next event (wakeup at %s)...", sleep, time.Now().Add(sleep))
+				logged = at
+			}
+			slept := c.clock().Now()
+			timer := time.NewTimer(sleep)
+			select {
+			case t := <-timer.C:
+				if jump := wallClockJump(c.clock(), slept); jump > clockJumpThreshold || jump < -clockJumpThreshold {
+					// Due times derived from wall clock readings moved
+					c.log.logf(levelWarn, "WARNING: Wall clock jumped by %s, rescheduling all files", jump)
+					c.schedule.markAllDirty()
+					continue
+				}
+				if t.Before(at) {
+					continue
+				}
+				select {
+				case res <- t:
+				case <-ctx.Done():
+					return
+				}
+			case <-c.wakeup:
+				timer.Stop()
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+
+	return res
+}
+
+func (c *configFile) ExecuteExpired(ctx context.Context) error {
+	// Handled entries get their next due time computed again
+	due := c.schedule.popDue(time.Now())
+	defer c.reschedule(due...)
+
+	c.RLock()
+	paused, pool := c.paused, c.pool
+	files := make(map[string]*configFileSource, len(due))
+	for _, filePath := range due {
+		if fc, ok := c.Files[filePath]; ok {
+			files[filePath] = fc
+		}
+	}
+	c.RUnlock()
+
+	queued := 0
+	for filePath, fc := range files {
+		fc.checkMaxAge(filePath)
+		fc.checkHang(filePath)
+
+		if paused || fc.isPaused() || fc.nextRun(filePath).After(time.Now()) || fc.IsLocked() || pool.IsPending(filePath) {
+			continue
+		}
+
+		headCheck := fc.wantsHeadCheck(filePath)
+		fc.clearTrigger()
+
+		filePath, fc := filePath, fc
+		pool.Enqueue(poolJob{
+			Key:  filePath,
+			Host: fc.host(),
+			Run:  func() { c.runFetch(ctx, filePath, fc, headCheck) },
+			Done: func() { c.reschedule(filePath) },
+		})
+		queued++
+	}
+
+	if queued > 0 {
+		waiting, active := pool.Stats()
+		c.log.debug("Queued %d downloads (%d waiting, %d running)", queued, waiting, active)
+	}
+
+	return nil
+}
+
+// runFetch executes the download of the entry and records its result,
+// the download is aborted when ctx is done. A panic of the fetch is
+// recovered and returned as error so the entry is unlocked again.
+func (c *configFile) runFetch(ctx context.Context, filePath string, fc *configFileSource, headCheck bool) (err error) {
+	if !c.isCurrent(filePath, fc) {
+		c.log.debug("Skipping fetch of '%s', the entry was changed by a reload", filePath)
+		return nil
+	}
+
+	if !fc.TryLock(ctx, headCheck) {
+		return ErrFetchInProgress
+	}
+	defer fc.Unlock()
+	defer fc.clearForce()
+	defer func() {
+		if r := recover(); r != nil {
+			c.log.logf(levelError, "ERROR: Recovered from panic in fetch of '%s': %v", filePath, r)
+			err = fmt.Errorf("Fetch panicked: %v", r)
+			fc.recordFailure(err)
+		}
+	}()
+	// Schedules the check for a hanging fetch
+	c.reschedule(filePath)
+
+	c.RLock()
+	historySize := c.HistorySize
+	c.RUnlock()
+
+	c.log.debug("Starting fetch of file '%s'", filePath)
+	c.emitEvent(streamEvent{Event: eventFetchStarted, Path: filePath, URL: fc.displayURL()})
+
+	rec := FetchRecord{Time: time.Now()}
+	err = fc.sanitizeError(c.executeDownload(filePath, fc, &rec))
+	rec.Duration = time.Since(rec.Time)
+
+	if err != nil && fc.isRetired() {
+		// The temp file is gone already, nothing to report for the old
+		// definition of the entry
+		fc.logf(levelInfo, "Discarded fetch of '%s': %s", filePath, errRetired)
+		return err
+	}
+
+	if err != nil && ctx.Err() != nil {
+		fc.logf(levelInfo, "Aborted fetch of '%s': %s", filePath, ctx.Err())
+		return err
+	}
+
+	if err != nil {
+		rec.Outcome = outcomeError
+		rec.Error = err.Error()
+		c.streamFetch(filePath, fc, &rec)
+		fc.addHistory(rec, historySize)
+		es := fc.recordFailure(err)
+		// log_level of the entry must not hide failed checksums, pins or
+		// other security checks
+		logFailure := fc.logf
+		if isSecurityError(err) {
+			logFailure = c.log.logf
+		}
+		if fc.crossedFailureThreshold(es.ConsecutiveFailures) {
+			logFailure(levelError, "ERROR: File '%s' failed %d times in a row: %s", filePath, es.ConsecutiveFailures, err)
+			c.alertFailure(filePath, fc, failureEventFailing, es)
+		} else {
+			logFailure(levelWarn, "Could not fetch file '%s': %s", filePath, err)
+		}
+		c.notifyCommand(notifyEvent{
+			Event:    notifyEventFailed,
+			Path:     filePath,
+			URL:      fc.sourceURL(),
+			Error:    err.Error(),
+			Failures: es.ConsecutiveFailures,
+		})
+		c.hooks.failed(ErrorEvent{
+			Path:                filePath,
+			URL:                 fc.sourceURL(),
+			Err:                 err,
+			ConsecutiveFailures: es.ConsecutiveFailures,
+			Time:                es.LastErrorAt,
+		})
+		fc.sendPing(filePath, true)
+		return err
+	}
+
+	c.streamFetch(filePath, fc, &rec)
+	fc.addHistory(rec, historySize)
+	if es := fc.recordSuccess(); es.ConsecutiveFailures > 0 {
+		fc.logf(levelInfo, "File '%s' recovered after %d failures", filePath, es.ConsecutiveFailures)
+		c.notifyCommand(notifyEvent{
+			Event:    notifyEventRecovered,
+			Path:     filePath,
+			URL:      fc.sourceURL(),
+			Failures: es.ConsecutiveFailures,
+		})
+		if fc.escalated(es.ConsecutiveFailures) {
+			c.alertFailure(filePath, fc, failureEventRecovered, es)
+		}
+	}
+	if rec.Outcome == outcomeChecked {
+		// Only a fetch confirms the file, not the HEAD pre-check
+		c.log.debug("File '%s' successfully checked", filePath)
+		return nil
+	}
+	c.log.debug("File '%s' successfully fetched", filePath)
+	fc.touchSuccessMarker()
+	fc.sendPing(filePath, false)
+	return nil
+}
+
+// Shutdown cancels all queued downloads and waits for the running ones
+func (c *configFile) Shutdown() {
+	c.Lock()
+	pool := c.pool
+	mqtt := c.mqtt
+	c.mqtt = nil
+	c.Unlock()
+
+	if mqtt != nil {
+		mqtt.Close()
+	}
+	if pool != nil {
+		pool.Close()
+	}
+
+	// No downloads are left to notify about
+	c.RLock()
+	defer c.RUnlock()
+	c.webhooks.Close()
+	c.slack.Close()
+	c.notifiers.Close()
+	if c.emails != nil {
+		c.emails.queue.Close()
+	}
+}
+
+// prepareRequest sets the headers shared by all requests for the source
+func (c *configFileSource) prepareRequest(req *http.Request, userAgent string) error {
+	req.Header.Set("User-Agent", userAgent)
+	if c.AcceptCompression {
+		// Setting the header disables the transparent decoding of the
+		// transport, the body is decoded below
+		req.Header.Set("Accept-Encoding", acceptCompression)
+	}
+	if c.Accept != "" {
+		req.Header.Set("Accept", c.Accept)
+	}
+
+	return c.setCredentials(req)
+}
+
+// lengthCheckable reports whether the Content-Length of the response
+// is the size of the written file. The length of compressed bodies says
+// nothing about the file size, truncated streams are detected by the
+// decompressor instead.
+func (c *configFileSource) lengthCheckable(res *http.Response) bool {
+	var encoding string
+	if c.AcceptCompression {
+		encoding = res.Header.Get("Content-Encoding")
+	}
+	return res.ContentLength > 0 && c.Decompress == "" && !isContentEncoded(encoding) &&
+		c.NormalizeLineEndings == "" && c.ConvertCharset == nil
+}
+
+// copyBody reads the response body through the rate limits, watchdogs
+// and content conversions of the source into out
+func (c *configFileSource) copyBody(ctx context.Context, cancel context.CancelFunc, timeout time.Duration,
+	targetPath string, totalRate *rateLimiter, res *http.Response, out io.Writer) (int64, error) {
+	var encoding string
+	if c.AcceptCompression {
+		encoding = res.Header.Get("Content-Encoding")
+	}
+
+	var limiters []*rateLimiter
+	if totalRate != nil {
+		limiters = append(limiters, totalRate)
+	}
+	if c.MaxRate > 0 {
+		limiters = append(limiters, newRateLimiter(c.MaxRate))
+	}
+	body := newRateLimitedReader(ctx, c.progress.track(res.Body, res.ContentLength), limiters...)
+
+	// Watchdogs cancelling the transfer and reporting why they did
+	var watchdogs []interface{ Err() error }
+
+	if c.MinThroughput > 0 {
+		monitor := newThroughputMonitor(body, cancel, c.MinThroughput,
+			c.MinThroughputWindow, c.MinThroughputGrace)
+		defer monitor.Stop()
+		body = monitor
+		watchdogs = append(watchdogs, monitor)
+	}
+
+	if c.IdleReadTimeout > 0 {
+		idle := newIdleTimeoutReader(body, cancel, c.IdleReadTimeout)
+		defer idle.Stop()
+		body = idle
+		watchdogs = append(watchdogs, idle)
+	}
+
+	body, err := newContentDecodingReader(encoding, body)
+	if err != nil {
+		return 0, err
+	}
+	if body, err = newDecompressingReader(c.Decompress, body); err != nil {
+		return 0, err
+	}
+	normalize := func(r io.Reader) io.Reader {
+		return newLineEndingReader(c.NormalizeLineEndings, r, func() {
+			c.logf(levelWarn, "WARNING: '%s' looks like a binary file, not normalizing its line endings", targetPath)
+		})
+	}
+	if body, err = newCharsetReader(c.ConvertCharset, res.Header.Get("Content-Type"), body, normalize); err != nil {
+		return 0, err
+	}
+
+	n, err := c.buffers.pooledCopy(out, body)
+	if err != nil {
+		for _, w := range watchdogs {
+			if werr := w.Err(); werr != nil {
+				return n, werr
+			}
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return n, fmt.Errorf("Overall timeout of %s exceeded (timeout) while reading body: %s", timeout, err)
+		}
+		return n, err
+	}
+
+	return n, nil
+}
+
+func (c *configFile) executeDownload(targetPath string, targetConfig *configFileSource, rec *FetchRecord) error {
+	c.RLock()
+	totalRate := c.totalRate
+	userAgent := c.userAgent(targetConfig)
+	logDiff := c.logDiff(targetConfig)
+	allow := c.allowlist()
+	roots := c.AllowedTargetRoots
+	client, err := c.newHTTPClient(targetConfig)
+	c.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer client.CloseIdleConnections()
+
+	// Directories might have been replaced by symlinks since the config
+	// was loaded
+	if err := targetConfig.checkTargetRoots(roots, targetPath); err != nil {
+		return err
+	}
+
+	// Skip validators and checksum to detect upstreams wrongly claiming
+	// the file did not change
+	forceFetch := targetConfig.MaxStaleness > 0 && time.Since(targetConfig.lastDownload) > targetConfig.MaxStaleness
+	if forceFetch {
+		targetConfig.logf(levelInfo, "Forcing full download of '%s': last full download is older than max_staleness of %s",
+			targetPath, targetConfig.MaxStaleness)
+	}
+	// A forced fetch, e.g. for a rollback, can't rely on the validators
+	// of the newer content
+	forceFetch = forceFetch || targetConfig.isForced()
+
+	if targetConfig.SHA256 != "" && targetConfig.checksumOfInstalled() && !forceFetch {
+		currentSHA, ok := c.checksums.Sum(targetPath)
+		if ok && currentSHA == targetConfig.SHA256 {
+			rec.Outcome = outcomeUnchanged
+			targetConfig.Finish(targetConfig.lastSeenETag, targetConfig.lastModified)
+			return nil
+		}
+	}
+
+	timeout := targetConfig.fetchTimeout()
+	ctx, cancel := context.WithTimeout(targetConfig.fetchContext(), timeout)
+	defer cancel()
+
+	if targetConfig.Mirror != nil {
+		return c.executeDirMirror(ctx, cancel, timeout, client, targetPath, targetConfig, userAgent, totalRate,
+			forceFetch, rec)
+	}
+
+	if len(targetConfig.URLs) > 0 {
+		return c.executeMultiDownload(ctx, cancel, timeout, client, targetPath, targetConfig, userAgent, totalRate,
+			forceFetch, logDiff, rec)
+	}
+
+	fetchURL, err := c.fetchURL(targetPath, targetConfig)
+	if err != nil {
+		return err
+	}
+
+	// The URL might come from a url_command or a date template
+	if err := allow.check(fetchURL); err != nil {
+		return err
+	}
+
+	fetcher, err := c.fetchers.fetcherFor(fetchURL)
+	if err != nil {
+		return err
+	}
+	_, builtin := fetcher.(httpFetcher)
+
+	if builtin && targetConfig.headCheck && !forceFetch && targetConfig.validatorsApply(0, fetchURL) {
+		changed, err := targetConfig.headChanged(ctx, client, targetPath, fetchURL, userAgent)
+		switch {
+		case err != nil && len(targetConfig.FallbackURLs) == 0:
+			return err
+		case err != nil:
+			// The fallback_urls are tried by the full request
+			c.log.debug("HEAD pre-check of '%s' failed, fetching the file: %s", targetPath, err)
+			changed = true
+		}
+		if !changed {
+			c.log.debug("HEAD of '%s' shows no change, skipping download", targetPath)
+			rec.Outcome = outcomeChecked
+			targetConfig.runMu.Lock()
+			targetConfig.lastCheck = time.Now()
+			targetConfig.runMu.Unlock()
+			return nil
+		}
+	}
+
+	src := Source{
+		Path:      targetPath,
+		URL:       fetchURL,
+		UserAgent: userAgent,
+		attempt: &httpAttempt{
+			entry:     targetConfig,
+			client:    client,
+			totalRate: totalRate,
+			timeout:   timeout,
+		},
+	}
+	prev := PrevState{
+		Conditional: !targetConfig.IgnoreETag && !forceFetch,
+		Version:     targetConfig.lastSeenETag,
+		Modified:    targetConfig.lastModified,
+	}
+
+	// Watch-only entries just hash the content
+	dest := newDownloadDest(targetPath, targetConfig.dirMode(), targetConfig.WatchOnly)
+	defer dest.remove()
+
+	copyStart := time.Now()
+	res, err := fetcher.Fetch(ctx, src, dest, prev)
+	rec.Bytes = dest.written
+	rec.Host, rec.StatusCode = res.host, res.statusCode
+	switch {
+	case targetConfig.isRetired():
+		return errRetired
+	case errors.Is(err, ErrMissing) && targetConfig.OnMissing != "" && targetConfig.OnMissing != onMissingKeep:
+		return c.handleMissing(targetPath, targetConfig, err, rec)
+	case err != nil:
+		return err
+	case res.NotModified:
+		rec.Outcome = outcomeNotModified
+		targetConfig.Finish(res.Version, res.Modified)
+		c.saveState()
+		return nil
+	}
+	rec.BytesPerSecond = int64(effectiveRate(dest.written, time.Since(copyStart)))
+	c.log.debug("Fetched %s of '%s' with %s/s", byteSize(dest.written), targetPath, byteSize(rec.BytesPerSecond))
+
+	result := downloadResult{SHA256: dest.sum(), FinalURL: res.FinalURL, Host: res.host}
+	val := responseValidators{
+		ETag:         res.Version,
+		LastModified: res.Modified,
+		Version:      res.version,
+		Length:       res.Size,
+		Host:         res.host,
+	}
+	if targetConfig.WatchOnly {
+		c.finishWatchOnly(targetPath, targetConfig, val, result, rec)
+		return nil
+	}
+
+	if err := dest.close(); err != nil {
+		return err
+	}
+
+	return c.installDownload(targetPath, targetConfig, dest.file.Name(), result, val, logDiff, rec)
+}
+
+// installDownload verifies and converts the downloaded temp file,
+// installs it and announces the change
+func (c *configFile) installDownload(targetPath string, targetConfig *configFileSource, tempPath string,
+	result downloadResult, val responseValidators, logDiff bool, rec *FetchRecord) error {
+	if targetConfig.isRetired() {
+		return errRetired
+	}
+	// Before extract_to is written
+	result.Event = targetConfig.fileEvent(targetPath)
+	result.Initial = targetConfig.lastCall.IsZero() && targetConfig.lastDownload.IsZero()
+
+	// With extract_member the member replaces the archive, also for the
+	// checksum verification
+	installPath := tempPath
+	if targetConfig.ExtractMember != "" {
+		member, sha, err := targetConfig.extractMember(tempPath)
+		if err != nil {
+			return fmt.Errorf("Could not extract member: %s", err)
+		}
+		defer os.Remove(member)
+		installPath, result.SHA256 = member, sha
+	}
+
+	if targetConfig.JSONPath != "" {
+		value, sha, err := targetConfig.extractJSONPath(installPath)
+		if err != nil {
+			return fmt.Errorf("Could not extract json_path: %s", err)
+		}
+		defer os.Remove(value)
+		installPath, result.SHA256 = value, sha
+	}
+
+	if targetConfig.SHA256 != "" && !targetConfig.checksumOfInstalled() && result.SHA256 != targetConfig.SHA256 {
+		return securityError{errors.New("Downloaded file does not have expected SHA256")}
+	}
+
+	// A stale upstream must not roll the file back, the validators of
+	// the installed content are kept
+	version := targetConfig.upstreamVersion(val, installPath)
+	if targetConfig.rejectOlder(targetPath, version, val) {
+		rec.Outcome = outcomeRejectedOlder
+		targetConfig.Finish(targetConfig.lastSeenETag, targetConfig.lastModified)
+		c.saveState()
+		return nil
+	}
+
+	if targetConfig.TransformCommand != "" {
+		out, sha, err := c.transformFile(targetPath, targetConfig, installPath)
+		if err != nil {
+			return fmt.Errorf("Could not transform file: %s", err)
+		}
+		defer os.Remove(out)
+		installPath, result.SHA256 = out, sha
+	}
+
+	if targetConfig.SHA256 != "" && targetConfig.checksumOfInstalled() && result.SHA256 != targetConfig.SHA256 {
+		return securityError{errors.New("Downloaded file does not have expected SHA256")}
+	}
+
+	if targetConfig.needsExtract(result.SHA256) {
+		if err := targetConfig.extractArchive(tempPath); err != nil {
+			return fmt.Errorf("Could not extract archive to '%s': %w", targetConfig.ExtractTo, err)
+		}
+		c.log.debug("Extracted '%s' to '%s'", targetPath, targetConfig.ExtractTo)
+	}
+
+	// The surrounding API response usually changes on every call, an
+	// unchanged value is neither installed again nor announced
+	if targetConfig.JSONPath != "" && result.SHA256 == targetConfig.lastSHA256 {
+		if sum, ok := c.checksums.Sum(targetPath); ok && sum == result.SHA256 {
+			rec.Outcome = outcomeUnchanged
+			targetConfig.runMu.Lock()
+			targetConfig.lastDownload = time.Now()
+			targetConfig.lastVersion = version
+			targetConfig.finishWith(val)
+			targetConfig.runMu.Unlock()
+			c.saveState()
+			return nil
+		}
+	}
+
+	var diff string
+	if logDiff && !targetConfig.appendMode() && result.SHA256 != targetConfig.lastSHA256 {
+		diff = changeDiff(targetPath, installPath, targetConfig.lastSHA256, result.SHA256)
+	}
+
+	var (
+		appended int64
+		err      error
+	)
+	switch {
+	case targetConfig.DiscardArchive:
+		// The temp file is removed by the deferred cleanup
+		os.Remove(targetPath)
+	case targetConfig.appendMode():
+		if appended, err = targetConfig.appendFile(installPath, targetPath); err != nil {
+			return fmt.Errorf("Could not append to file: %s", err)
+		}
+		if appended == 0 {
+			rec.Outcome = outcomeUnchanged
+			targetConfig.runMu.Lock()
+			targetConfig.lastDownload = time.Now()
+			targetConfig.finishWith(val)
+			targetConfig.runMu.Unlock()
+			c.saveState()
+			return nil
+		}
+	default:
+		// Only the verified and converted content gets its final mode
+		if err := targetConfig.applyFileMode(installPath); err != nil {
+			return err
+		}
+		if err := replaceFile(installPath, targetPath); err != nil {
+			return err
+		}
+	}
+
+	if targetConfig.appendMode() {
+		// The checksum of the download is only the one of the appended part
+		c.checksums.Forget(targetPath)
+	} else {
+		c.checksums.Store(targetPath, result.SHA256)
+	}
+
+	oldSHA256 := targetConfig.lastSHA256
+	rec.Outcome = outcomeChanged
+	if result.SHA256 == oldSHA256 && !targetConfig.appendMode() {
+		rec.Outcome = outcomeUnchanged
+	}
+
+	targetConfig.runMu.Lock()
+	targetConfig.lastSHA256 = result.SHA256
+	targetConfig.lastDownload = time.Now()
+	targetConfig.lastVersion = version
+	targetConfig.missing = false
+	targetConfig.finishWith(val)
+	targetConfig.runMu.Unlock()
+	c.saveState()
+
+	if rec.Outcome == outcomeChanged {
+		if diff != "" {
+			targetConfig.logf(levelInfo, "Content of '%s' changed:\n%s", targetPath, strings.TrimSuffix(diff, "\n"))
+			rec.diff = diff
+		}
+		if targetConfig.MirrorTo != "" {
+			go c.mirrorFile(targetPath, targetConfig)
+		}
+		c.notifyChange(targetPath, oldSHA256, result, rec)
+		c.notifyCommand(notifyEvent{
+			Event:     notifyEventChanged,
+			Path:      targetPath,
+			URL:       targetConfig.sourceURL(),
+			SHA256:    result.SHA256,
+			OldSHA256: oldSHA256,
+		})
+	}
+
+	rec.fileEvent = result.Event
+	c.streamFetch(targetPath, targetConfig, rec)
+	c.announceSuccess(targetPath, targetConfig, result)
+
+	return nil
+}
+
+// handleMissing applies the on_missing action once after the upstream
+// reported the file is gone
+func (c *configFile) handleMissing(targetPath string, targetConfig *configFileSource, reason error, rec *FetchRecord) error {
+	if targetConfig.missing {
+		rec.Outcome = outcomeUnchanged
+		targetConfig.Finish("", "")
+		return nil
+	}
+
+	// The fetch might have taken long enough for directories to be
+	// replaced by symlinks
+	c.RLock()
+	roots := c.AllowedTargetRoots
+	c.RUnlock()
+	if err := targetConfig.checkTargetRoots(roots, targetPath); err != nil {
+		return err
+	}
+
+	var (
+		event = targetConfig.fileEvent(targetPath)
+		err   error
+	)
+	switch targetConfig.OnMissing {
+	case onMissingDelete:
+		rec.Outcome = outcomeDeleted
+		event = fileEventDeleted
+		err = os.Remove(targetPath)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	case onMissingEmpty:
+		rec.Outcome = outcomeEmptied
+		err = targetConfig.installEmpty(targetPath)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not apply on_missing=%s (%s): %s", targetConfig.OnMissing, reason, err)
+	}
+
+	targetConfig.logf(levelWarn, "Upstream of '%s' is missing the file (%s), applied on_missing=%s", targetPath, reason, targetConfig.OnMissing)
+	c.checksums.Forget(targetPath)
+
+	oldSHA256 := targetConfig.lastSHA256
+	targetConfig.runMu.Lock()
+	targetConfig.missing = true
+	targetConfig.lastSHA256 = ""
+	targetConfig.finish("", "")
+	targetConfig.runMu.Unlock()
+	c.saveState()
+	c.notifyCommand(notifyEvent{
+		Event:     notifyEventRemoved,
+		Path:      targetPath,
+		URL:       targetConfig.sourceURL(),
+		OldSHA256: oldSHA256,
+	})
+
+	rec.fileEvent = event
+	c.streamFetch(targetPath, targetConfig, rec)
+	c.announceSuccess(targetPath, targetConfig, downloadResult{Event: event})
+
+	return nil
+}
+
+// installEmpty replaces the target with an empty file like a download
+// is installed: the 0600 temp file gets the file_mode and is renamed
+// over the target, a symlink at the target is replaced and not followed
+func (c *configFileSource) installEmpty(targetPath string) error {
+	dest := newDownloadDest(targetPath, c.dirMode(), false)
+	defer dest.remove()
+
+	if err := dest.close(); err != nil {
+		return err
+	}
+	if err := c.applyFileMode(dest.file.Name()); err != nil {
+		return err
+	}
+	return replaceFile(dest.file.Name(), targetPath)
+}
+
+func effectiveRate(n int64, d time.Duration) byteSize {
+	if d <= 0 {
+		return byteSize(n)
+	}
+	return byteSize(float64(n) / d.Seconds())
+}
+
+// downloadResult contains information about a finished download which
+// is passed to the success command
+type downloadResult struct {
+	SHA256   string
+	FinalURL string
+	Host     string
+	// Event is one of the fileEvent* kinds, empty for watch_only
+	Event string
+	// Initial is set for the first download of an entry without a
+	// success recorded in memory or the state file
+	Initial bool
+}
+
+// Kinds of changes of the target passed to the hooks as DW_EVENT
+const (
+	fileEventCreated = "created"
+	fileEventUpdated = "updated"
+	fileEventDeleted = "deleted"
+)
+
+// fileEvent tells whether writing the target creates or updates it. The
+// disk is checked as the restored state can't tell whether the file was
+// removed meanwhile.
+func (c *configFileSource) fileEvent(targetPath string) string {
+	if c.DiscardArchive {
+		targetPath = c.ExtractTo
+	}
+	if _, err := os.Lstat(targetPath); err == nil {
+		return fileEventUpdated
+	}
+	return fileEventCreated
+}
+
+// responseValidators are the headers of a download used to check for
+// changes in the next run
+type responseValidators struct {
+	ETag         string
+	LastModified string
+	// Version is the value of the version_header
+	Version string
+	Length  int64
+	Host    string
+}
+
+// skipInitialCommand returns whether the success_command is skipped for
+// the first download, the per-file option overrides the global one
+func (c *configFile) skipInitialCommand(src *configFileSource) bool {
+	if src.SkipInitialCommand != nil {
+		return *src.SkipInitialCommand
+	}
+	return c.SkipInitialCommand
+}
+
+func (c *configFile) executeSuccessCommand(targetPath string, targetConfig *configFileSource, result downloadResult) error {
+	if targetConfig.SuccessCommand == "" {
+		return nil
+	}
+
+	vars := []string{
+		"DW_PATH=" + targetPath,
+		"DW_URL=" + targetConfig.sourceURL(),
+		"DW_SHA256=" + result.SHA256,
+		"DW_FINAL_URL=" + result.FinalURL,
+		"DW_HOST=" + result.Host,
+	}
+	if result.Event != "" {
+		vars = append(vars, "DW_EVENT="+result.Event)
+	}
+
+	c.RLock()
+	skip := result.Initial && c.skipInitialCommand(targetConfig)
+	argv := c.commandLine(targetConfig, targetConfig.SuccessCommand)
+	env := c.commandEnv(targetConfig, vars...)
+	c.RUnlock()
+
+	if skip {
+		targetConfig.logf(levelInfo, "Not executing success-command for initial download of '%s' (skip_initial_command)", targetPath)
+		return nil
+	}
+
+	cmd := exec.CommandContext(c.rootContext(), argv[0], argv[1:]...)
+	cmd.Env = env
+
+	c.emitEvent(streamEvent{Event: eventCommandStarted, Path: targetPath, Command: "success_command"})
+	start := time.Now()
+	err := cmd.Run()
+	c.emitEvent(streamEvent{
+		Event:      eventCommandFinished,
+		Path:       targetPath,
+		Command:    "success_command",
+		ExitCode:   commandExitCode(cmd),
+		DurationMS: int64(time.Since(start) / time.Millisecond),
+		Error:      errString(err),
+	})
+	return err
+}
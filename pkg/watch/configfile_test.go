@@ -0,0 +1,46 @@
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestOnMissingEmptyInstallsLikeADownload(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	dir := t.TempDir()
+	target, linked := filepath.Join(dir, "file"), filepath.Join(dir, "linked")
+	if err := ioutil.WriteFile(linked, []byte("other content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(linked, target); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s\n    on_missing: empty\n    file_mode: \"0640\"\n", target, srv.URL))
+	if err := w.config.runFetch(context.Background(), target, w.lookup(target), false); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Lstat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() || info.Size() != 0 {
+		t.Errorf("target is %s with %d bytes, want an empty regular file", info.Mode(), info.Size())
+	}
+	if mode := info.Mode().Perm(); mode != 0640 {
+		t.Errorf("target has mode %o, want the file_mode 640", mode)
+	}
+	if content, _ := ioutil.ReadFile(linked); string(content) != "other content" {
+		t.Errorf("symlinked file was truncated to %q", content)
+	}
+}
@@ -0,0 +1,189 @@
+package watch
+
+import (
+	"errors"
+	"sort"
+)
+
+// Errors returned by the Watcher when triggering or pausing entries
+var (
+	ErrUnknownFile     = errors.New("No such file configured")
+	ErrFetchInProgress = errors.New("Fetch already in progress")
+	ErrFilePaused      = errors.New("File is paused")
+	ErrPaused          = errors.New("Scheduling is paused")
+)
+
+const poolStatePaused = "paused"
+
+func (c *configFileSource) trigger() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.triggered = true
+}
+
+func (c *configFileSource) clearTrigger() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.triggered = false
+}
+
+// force triggers the entry and overrides reject_older for its next
+// fetch
+func (c *configFileSource) force() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.triggered = true
+	c.forced = true
+}
+
+func (c *configFileSource) clearForce() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.forced = false
+}
+
+func (c *configFileSource) isForced() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.forced
+}
+
+func (c *configFileSource) isTriggered() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.triggered
+}
+
+func (c *configFileSource) setPaused(paused bool) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.paused = paused
+}
+
+func (c *configFileSource) isPaused() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.paused
+}
+
+// IsPaused reports whether the scheduling of all entries is paused
+func (c *configFile) IsPaused() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.paused
+}
+
+// wake interrupts the sleep of the scheduler to check for due entries
+func (c *configFile) wake() {
+	if c.wakeup == nil {
+		return
+	}
+
+	select {
+	case c.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// TriggerFetch marks the entry as due and wakes the scheduler, an empty
+// path triggers all entries which are not paused or already running. The
+// paths of the triggered entries are returned.
+func (c *configFile) TriggerFetch(filePath string) ([]string, error) {
+	return c.triggerFetch(filePath, false)
+}
+
+// ForceFetch triggers the fetch like TriggerFetch and installs the
+// content even if reject_older would refuse it, e.g. for a deliberate
+// rollback
+func (c *configFile) ForceFetch(filePath string) ([]string, error) {
+	return c.triggerFetch(filePath, true)
+}
+
+func (c *configFile) triggerFetch(filePath string, force bool) ([]string, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.paused {
+		return nil, ErrPaused
+	}
+
+	if filePath != "" {
+		fc, ok := c.Files[filePath]
+		switch {
+		case !ok:
+			return nil, ErrUnknownFile
+		case fc.isPaused():
+			return nil, ErrFilePaused
+		case fc.IsLocked() || c.pool.IsPending(filePath):
+			return nil, ErrFetchInProgress
+		}
+
+		if force {
+			fc.force()
+		} else {
+			fc.trigger()
+		}
+		c.reschedule(filePath)
+		return []string{filePath}, nil
+	}
+
+	triggered := []string{}
+	for filePath, fc := range c.Files {
+		if fc.isPaused() || fc.IsLocked() || c.pool.IsPending(filePath) {
+			continue
+		}
+		if force {
+			fc.force()
+		} else {
+			fc.trigger()
+		}
+		triggered = append(triggered, filePath)
+	}
+	sort.Strings(triggered)
+
+	c.reschedule(triggered...)
+	return triggered, nil
+}
+
+// SetPaused pauses or resumes the scheduling of the entry or of all
+// entries if the path is empty, running fetches are not interrupted.
+// Resuming only starts the fetches which are overdue.
+func (c *configFile) SetPaused(filePath string, paused bool) error {
+	if filePath == "" {
+		c.Lock()
+		changed := c.paused != paused
+		c.paused = paused
+		c.Unlock()
+
+		if changed {
+			if paused {
+				c.log.logf(levelInfo, "Scheduling paused")
+			} else {
+				c.log.logf(levelInfo, "Scheduling resumed")
+			}
+			c.rescheduleAll()
+		}
+		return nil
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	fc, ok := c.Files[filePath]
+	if !ok {
+		return ErrUnknownFile
+	}
+
+	fc.setPaused(paused)
+	c.reschedule(filePath)
+	return nil
+}
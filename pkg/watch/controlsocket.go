@@ -0,0 +1,202 @@
+package watch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const controlClientTimeout = 30 * time.Second
+
+type controlRequest struct {
+	Command string `json:"command"`
+	Path    string `json:"path,omitempty"`
+}
+
+type controlResponse struct {
+	OK       bool         `json:"ok"`
+	Error    string       `json:"error,omitempty"`
+	Message  string       `json:"message,omitempty"`
+	Paths    []string     `json:"paths,omitempty"`
+	Status   []FileStatus `json:"status,omitempty"`
+	Paused   bool         `json:"paused,omitempty"`
+	Starting bool         `json:"starting,omitempty"`
+	Stats    *DaemonStats `json:"stats,omitempty"`
+	LogLevel string       `json:"log_level,omitempty"`
+}
+
+// controlServer accepts commands for the running daemon on a unix socket
+type controlServer struct {
+	path     string
+	listener net.Listener
+	config   *configFile
+	reload   func() error
+}
+
+// listenControlSocket creates the socket with mode 0600. A leftover
+// socket of a crashed daemon is removed, a socket still in use is not.
+func listenControlSocket(socketPath string, config *configFile, reload func() error) (*controlServer, error) {
+	if _, err := os.Stat(socketPath); err == nil {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("Control socket %s is in use by another process", socketPath)
+		}
+		config.log.debug("Removing stale control socket %s", socketPath)
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := listenUnix(socketPath, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &controlServer{path: socketPath, listener: l, config: config, reload: reload}
+	go s.serve()
+
+	return s, nil
+}
+
+// Close stops accepting commands and removes the socket
+func (s *controlServer) Close() error {
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.config.log.logf(levelWarn, "Control socket stopped accepting connections: %s", err)
+			}
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlClientTimeout))
+
+	var req controlRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(controlResponse{Error: fmt.Sprintf("Invalid request: %s", err)})
+		return
+	}
+
+	s.config.log.debug("Control socket command %q (path %q)", req.Command, req.Path)
+
+	res := s.execute(req)
+	if err := json.NewEncoder(conn).Encode(res); err != nil {
+		s.config.log.debug("Could not answer control socket command: %s", err)
+	}
+}
+
+func (s *controlServer) execute(req controlRequest) controlResponse {
+	switch req.Command {
+	case "fetch", "force-fetch":
+		trigger := s.config.TriggerFetch
+		if req.Command == "force-fetch" {
+			trigger = s.config.ForceFetch
+		}
+		triggered, err := trigger(req.Path)
+		if err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+		return controlResponse{OK: true, Message: fmt.Sprintf("Triggered fetch of %d files", len(triggered)), Paths: triggered}
+
+	case "status":
+		stats := s.config.Stats()
+		return controlResponse{OK: true, Status: s.config.Status(), Paused: s.config.IsPaused(), Starting: s.config.IsStarting(),
+			Stats: &stats, LogLevel: s.config.log.level()}
+
+	case "pause", "resume":
+		if err := s.config.SetPaused(req.Path, req.Command == "pause"); err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+		if req.Path == "" {
+			return controlResponse{OK: true, Message: fmt.Sprintf("Scheduling %sd", req.Command)}
+		}
+		return controlResponse{OK: true, Message: fmt.Sprintf("File '%s' %sd", req.Path, req.Command)}
+
+	case "reload":
+		if err := s.reload(); err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+		return controlResponse{OK: true, Message: "Configuration reloaded"}
+
+	default:
+		return controlResponse{Error: fmt.Sprintf("Unknown command %q", req.Command)}
+	}
+}
+
+// RunControlClient sends the command given on the commandline to the
+// control socket of the running daemon and prints the result
+func RunControlClient(socketPath string, args []string) error {
+	if socketPath == "" {
+		return errors.New("--control-socket is required")
+	}
+	if len(args) == 0 {
+		return errors.New("Usage: download-watch ctl <fetch [path] | force-fetch [path] | status | pause [path] | resume [path] | reload>")
+	}
+
+	req := controlRequest{Command: args[0]}
+	if len(args) > 1 {
+		req.Path = args[1]
+	}
+
+	res, err := callControlSocket(socketPath, req)
+	if err != nil {
+		return err
+	}
+
+	if req.Command == "status" {
+		if res.Paused {
+			fmt.Fprintln(os.Stderr, "Scheduling is paused")
+		}
+		fmt.Fprintf(os.Stderr, "Log level: %s\n", res.LogLevel)
+		out, err := json.MarshalIndent(res.Status, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	fmt.Println(res.Message)
+	return nil
+}
+
+// callControlSocket sends the request to the daemon and returns its
+// response, responses not being OK are returned as error
+func callControlSocket(socketPath string, req controlRequest) (controlResponse, error) {
+	var res controlResponse
+
+	conn, err := net.DialTimeout("unix", socketPath, controlClientTimeout)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlClientTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return res, err
+	}
+
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return res, err
+	}
+
+	if !res.OK {
+		return res, errors.New(res.Error)
+	}
+	return res, nil
+}
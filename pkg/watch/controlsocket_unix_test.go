@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestControlSocketCreatedWithMode0600(t *testing.T) {
+	// A permissive umask would leave the socket open to everyone
+	defer syscall.Umask(syscall.Umask(0))
+
+	// Checked right after the bind, before anything could chmod it
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+	l, err := listenUnix(socketPath, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("socket created with mode %o, want 600", mode)
+	}
+}
@@ -0,0 +1,58 @@
+package watch
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultCopyBufferSize = 256 * 1024
+	minCopyBufferSize     = 4 * 1024
+)
+
+// bufferPool recycles the byte buffers the downloads of a configuration
+// copy their body with, all of one size. Changing the size lets buffers
+// of the old size be garbage collected when they are returned.
+type bufferPool struct {
+	size int64
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{size: int64(size)}
+}
+
+// SetSize changes the size of the buffers handed out from now on, zero
+// restores the default
+func (b *bufferPool) SetSize(size int) {
+	if size <= 0 {
+		size = defaultCopyBufferSize
+	}
+	atomic.StoreInt64(&b.size, int64(size))
+}
+
+func (b *bufferPool) Get() *[]byte {
+	size := int(atomic.LoadInt64(&b.size))
+	if buf, ok := b.pool.Get().(*[]byte); ok && len(*buf) == size {
+		return buf
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+func (b *bufferPool) Put(buf *[]byte) {
+	if len(*buf) == int(atomic.LoadInt64(&b.size)) {
+		b.pool.Put(buf)
+	}
+}
+
+// pooledCopy copies src to dst with a buffer of the pool. dst is wrapped
+// so an io.ReaderFrom like *os.File does not bypass the buffer with its
+// own allocation.
+func (b *bufferPool) pooledCopy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := b.Get()
+	defer b.Put(buf)
+
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, *buf)
+}
@@ -0,0 +1,61 @@
+package watch
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// BenchmarkDownload fetches a file from a local server with the 32K
+// buffer of io.Copy used before copy_buffer_size and the default size
+func BenchmarkDownload(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	for _, size := range []string{"32K", "256K"} {
+		b.Run(size, func(b *testing.B) {
+			target := filepath.Join(b.TempDir(), "file")
+			cfg, err := parseConfig([]byte(fmt.Sprintf("copy_buffer_size: %s\nfiles:\n  %s:\n    url: %s\n", size, target, srv.URL)), Options{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			w, err := New(Config{file: cfg})
+			if err != nil {
+				b.Fatal(err)
+			}
+			fc := w.lookup(target)
+
+			b.SetBytes(int64(len(content)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := w.config.runFetch(context.Background(), target, fc, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyBufferSizePerWatcher(t *testing.T) {
+	small := newTestWatcher(t, "copy_buffer_size: 32K\nfiles: {}\n")
+	large := newTestWatcher(t, "copy_buffer_size: 1M\nfiles: {}\n")
+
+	for _, tc := range []struct {
+		w    *Watcher
+		want int
+	}{{small, 32 * 1024}, {large, 1024 * 1024}} {
+		buf := tc.w.config.buffers.Get()
+		if len(*buf) != tc.want {
+			t.Errorf("got buffer of %d bytes, want %d", len(*buf), tc.want)
+		}
+		tc.w.config.buffers.Put(buf)
+	}
+}
@@ -0,0 +1,123 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// dateTemplated reports whether the url contains date placeholders
+// which are evaluated at every fetch
+func (c *configFileSource) dateTemplated() bool {
+	return strings.Contains(c.URL, "{{")
+}
+
+func (c *configFileSource) validateDateTemplate() error {
+	if !c.dateTemplated() {
+		if c.DateOffset != 0 || c.DateTimezone != "" || c.DateFallbackDays != 0 {
+			return errors.New("date_offset, date_timezone and date_fallback_days need a date template in url")
+		}
+		return nil
+	}
+
+	switch {
+	case c.URLCommand != "" || c.Mirror != nil:
+		return errors.New("Date templates in url can't be used with url_command or mirror")
+	case c.DateFallbackDays < 0:
+		return errors.New("date_fallback_days must not be negative")
+	}
+
+	if _, err := c.dateLocation(); err != nil {
+		return fmt.Errorf("Invalid date_timezone %q: %s", c.DateTimezone, err)
+	}
+
+	resolved, err := c.resolveURL(time.Now(), 0)
+	if err != nil {
+		return err
+	}
+	if u, err := url.Parse(resolved); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Date template in url does not resolve to a http(s) URL: %s", resolved)
+	}
+	return nil
+}
+
+func (c *configFileSource) dateLocation() (*time.Location, error) {
+	if c.DateTimezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.DateTimezone)
+}
+
+// resolveURL evaluates the date placeholders of the url for the given
+// time shifted by date_offset and the given number of days back
+func (c *configFileSource) resolveURL(at time.Time, daysBack int) (string, error) {
+	if !c.dateTemplated() {
+		return c.URL, nil
+	}
+
+	loc, err := c.dateLocation()
+	if err != nil {
+		return "", err
+	}
+	t := at.Add(c.DateOffset).In(loc).AddDate(0, 0, -daysBack)
+
+	tmpl, err := template.New("url").Funcs(template.FuncMap{
+		"date": func(layout string) string { return t.Format(layout) },
+	}).Parse(c.URL)
+	if err != nil {
+		return "", fmt.Errorf("Invalid date template in url: %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, nil); err != nil {
+		return "", fmt.Errorf("Invalid date template in url: %s", err)
+	}
+	return buf.String(), nil
+}
+
+func (c *configFileSource) setResolvedURL(targetPath, resolved string) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.resolvedURL != resolved {
+		c.logf(levelInfo, "Resolved url of '%s' to %s", targetPath, sanitizeURL(resolved))
+	}
+	c.resolvedURL = resolved
+}
+
+func (c *configFileSource) getResolvedURL() string {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.resolvedURL
+}
+
+// walkBackDates requests the url resolved for up to date_fallback_days
+// previous days after the current one answered 404. The last response
+// is returned open together with the URL it was requested from.
+func (c *configFileSource) walkBackDates(ctx context.Context, client *http.Client, targetPath, userAgent string,
+	prev PrevState, res *http.Response, fetchURL string) (*http.Response, string, error) {
+	now := time.Now()
+	for day := 1; day <= c.DateFallbackDays && res.StatusCode == http.StatusNotFound; day++ {
+		res.Body.Close()
+
+		dayURL, err := c.resolveURL(now, day)
+		if err != nil {
+			return nil, "", err
+		}
+		c.log.debug("Got status 404 for '%s', trying %s", targetPath, sanitizeURL(dayURL))
+
+		if res, err = c.requestFile(ctx, client, dayURL, userAgent, prev.Conditional, prev); err != nil {
+			return nil, "", err
+		}
+		fetchURL = dayURL
+	}
+	return res, fetchURL, nil
+}
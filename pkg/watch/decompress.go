@@ -0,0 +1,91 @@
+package watch
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+const (
+	decompressGzip  = "gzip"
+	decompressBzip2 = "bzip2"
+
+	acceptCompression = "gzip, deflate"
+
+	decompressErrorPrefix = "Could not decompress body"
+)
+
+func validateDecompress(format string) error {
+	switch format {
+	case "", decompressGzip, decompressBzip2:
+		return nil
+	default:
+		return fmt.Errorf("Invalid decompress %q, use gzip or bzip2", format)
+	}
+}
+
+// newDecompressingReader returns a reader yielding the decompressed
+// body, corrupt streams surface as read errors
+func newDecompressingReader(format string, r io.Reader) (io.Reader, error) {
+	switch format {
+	case decompressGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("Could not decompress body: %s", err)
+		}
+		return annotatedReader{gz, decompressErrorPrefix}, nil
+	case decompressBzip2:
+		return annotatedReader{bzip2.NewReader(r), decompressErrorPrefix}, nil
+	default:
+		return r, nil
+	}
+}
+
+// isContentEncoded reports whether the body has a Content-Encoding
+// which needs decoding
+func isContentEncoded(encoding string) bool {
+	return encoding != "" && encoding != "identity"
+}
+
+// newContentDecodingReader decodes a body requested with
+// accept_compression according to its Content-Encoding
+func newContentDecodingReader(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "", "identity":
+		return r, nil
+	case "gzip", "x-gzip":
+		return newDecompressingReader(decompressGzip, r)
+	case "deflate":
+		// Servers send deflate with and without the zlib wrapper
+		br := bufio.NewReader(r)
+		if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("Could not decompress body: %s", err)
+			}
+			return annotatedReader{zr, decompressErrorPrefix}, nil
+		}
+		return annotatedReader{flate.NewReader(br), decompressErrorPrefix}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported Content-Encoding %q", encoding)
+	}
+}
+
+// annotatedReader prefixes read errors so e.g. a corrupt stream is
+// distinguishable from a network error in the log
+type annotatedReader struct {
+	r      io.Reader
+	prefix string
+}
+
+func (a annotatedReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%s: %s", a.prefix, err)
+	}
+	return n, err
+}
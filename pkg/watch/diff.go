@@ -0,0 +1,233 @@
+package watch
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// logDiffMaxSize is the maximum size of the old and the new version
+	// to compute a diff for
+	logDiffMaxSize = 256 * 1024
+	// logDiffMaxEdits stops the diff for files which changed entirely
+	logDiffMaxEdits = 1000
+	logDiffContext  = 3
+)
+
+// diffLine is one line of an edit script: ' ' kept, '-' removed or '+'
+// added. a and b are the positions in the old and new version.
+type diffLine struct {
+	op   byte
+	text string
+	a, b int
+}
+
+// diffLines computes the shortest edit script from a to b with the
+// Myers algorithm, false is returned when more than maxEdits edits are
+// needed
+func diffLines(a, b []string, maxEdits int) ([]diffLine, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] keeps v[-d..d] as it was before step d
+	var trace [][]int
+	found := false
+	for d := 0; d <= n+m && !found; d++ {
+		if d > maxEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	at := func(d, k int) int {
+		if k < -d || k > d {
+			return 0
+		}
+		return trace[d][k+d]
+	}
+
+	var rev []diffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(d, k-1) < at(d, k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(d, prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, diffLine{op: ' ', text: a[x], a: x, b: y})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			rev = append(rev, diffLine{op: '+', text: b[y], a: x, b: y})
+		} else {
+			x--
+			rev = append(rev, diffLine{op: '-', text: a[x], a: x, b: y})
+		}
+	}
+
+	script := make([]diffLine, len(rev))
+	for i := range rev {
+		script[i] = rev[len(rev)-1-i]
+	}
+	return script, true
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// unifiedDiff formats the difference of the two versions in unified
+// format, false is returned if they differ too much
+func unifiedDiff(name string, oldContent, newContent []byte) (string, bool) {
+	a := strings.SplitAfter(string(oldContent), "\n")
+	b := strings.SplitAfter(string(newContent), "\n")
+	if a[len(a)-1] == "" {
+		a = a[:len(a)-1]
+	}
+	if b[len(b)-1] == "" {
+		b = b[:len(b)-1]
+	}
+
+	script, ok := diffLines(a, b, logDiffMaxEdits)
+	if !ok {
+		return "", false
+	}
+
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "--- %s (old)\n+++ %s (new)\n", name, name)
+
+	for i := 0; i < len(script); {
+		for i < len(script) && script[i].op == ' ' {
+			i++
+		}
+		if i == len(script) {
+			break
+		}
+
+		// Extend the hunk as long as the changes are close enough to
+		// share their context
+		last := i
+		for j := i; j < len(script) && j-last <= 2*logDiffContext; j++ {
+			if script[j].op != ' ' {
+				last = j
+			}
+		}
+
+		start := i - logDiffContext
+		if start < 0 {
+			start = 0
+		}
+		end := last + logDiffContext + 1
+		if end > len(script) {
+			end = len(script)
+		}
+
+		var aCount, bCount int
+		for _, l := range script[start:end] {
+			if l.op != '+' {
+				aCount++
+			}
+			if l.op != '-' {
+				bCount++
+			}
+		}
+
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(script[start].a, aCount), hunkRange(script[start].b, bCount))
+		for _, l := range script[start:end] {
+			out.WriteByte(l.op)
+			out.WriteString(strings.TrimSuffix(l.text, "\n"))
+			if !strings.HasSuffix(l.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file")
+			}
+			out.WriteByte('\n')
+		}
+
+		i = end
+	}
+
+	return out.String(), true
+}
+
+// readDiffable returns the content of the file if it is small enough
+// and looks like text
+func readDiffable(filePath string) ([]byte, bool) {
+	fi, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return nil, true
+	}
+	if err != nil || fi.Size() > logDiffMaxSize {
+		return nil, false
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil || bytes.IndexByte(content, 0) >= 0 {
+		return nil, false
+	}
+	return content, true
+}
+
+// logDiff returns whether diffs are logged for the entry, the per-file
+// option overrides the global one
+func (c *configFile) logDiff(src *configFileSource) bool {
+	if src.LogDiff != nil {
+		return *src.LogDiff
+	}
+	return c.LogDiff
+}
+
+// changeDiff describes the change from the installed file to the new
+// version, a diff for small text files and sizes and hashes otherwise
+func changeDiff(targetPath, newPath, oldSHA, newSHA string) string {
+	oldContent, oldOK := readDiffable(targetPath)
+	newContent, newOK := readDiffable(newPath)
+	if oldOK && newOK {
+		if diff, ok := unifiedDiff(targetPath, oldContent, newContent); ok {
+			return diff
+		}
+	}
+
+	var oldSize, newSize int64
+	if fi, err := os.Stat(targetPath); err == nil {
+		oldSize = fi.Size()
+	}
+	if fi, err := os.Stat(newPath); err == nil {
+		newSize = fi.Size()
+	}
+	return fmt.Sprintf("binary or large file changed: %d -> %d bytes, sha256 %s -> %s", oldSize, newSize, oldSHA, newSHA)
+}
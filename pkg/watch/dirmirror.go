@@ -0,0 +1,628 @@
+package watch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	dirMirrorIndexAutoindex = "autoindex"
+	dirMirrorIndexJSON      = "json"
+	dirMirrorIndexPlain     = "plain"
+
+	defaultDirMirrorMaxDepth     = 5
+	defaultDirMirrorMaxFiles     = 1000
+	defaultDirMirrorMaxTotalSize = byteSize(1 << 30)
+
+	dirMirrorMaxIndexSize = 10 << 20
+	dirMirrorTempPrefix   = ".dw-sync-"
+)
+
+var autoindexHref = regexp.MustCompile(`(?i)href\s*=\s*["']([^"'#?]+)["']`)
+
+// dirMirror configures an entry keeping a local directory in sync with
+// an HTTP directory listing or a manifest of files
+type dirMirror struct {
+	Index        string     `yaml:"index"`
+	Include      stringList `yaml:"include"`
+	Exclude      stringList `yaml:"exclude"`
+	Delete       bool       `yaml:"delete"`
+	MaxDepth     int        `yaml:"max_depth"`
+	MaxFiles     int        `yaml:"max_files"`
+	MaxTotalSize byteSize   `yaml:"max_total_size"`
+}
+
+// dirMirrorFile are the validators of one synced file
+type dirMirrorFile struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// SyncStatus summarizes the last successful sync of a mirror entry
+type SyncStatus struct {
+	Time       time.Time `json:"time"`
+	Files      int       `json:"files"`
+	Downloaded int       `json:"downloaded"`
+	Unchanged  int       `json:"unchanged"`
+	Deleted    int       `json:"deleted"`
+	Bytes      int64     `json:"bytes"`
+	TotalSize  int64     `json:"total_size"`
+}
+
+func dirMirrorEqual(a, b *dirMirror) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Index == b.Index &&
+		a.Include.Equals(b.Include) &&
+		a.Exclude.Equals(b.Exclude) &&
+		a.Delete == b.Delete &&
+		a.MaxDepth == b.MaxDepth &&
+		a.MaxFiles == b.MaxFiles &&
+		a.MaxTotalSize == b.MaxTotalSize
+}
+
+func (c *configFileSource) validateDirMirror() error {
+	if c.Mirror == nil {
+		return nil
+	}
+	m := c.Mirror
+
+	switch m.Index {
+	case "", dirMirrorIndexAutoindex, dirMirrorIndexJSON, dirMirrorIndexPlain:
+	default:
+		return fmt.Errorf("Invalid mirror index %q, use autoindex, json or plain", m.Index)
+	}
+
+	for _, pattern := range append(append([]string{}, m.Include...), m.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid mirror pattern %q: %s", pattern, err)
+		}
+	}
+
+	if m.MaxDepth < 0 || m.MaxFiles < 0 || m.MaxTotalSize < 0 {
+		return errors.New("mirror max_depth, max_files and max_total_size must not be negative")
+	}
+
+	switch {
+	case c.URL == "":
+		return errors.New("mirror needs the url of the listing")
+	case len(c.URLs) > 0 || c.URLCommand != "" || c.method() != http.MethodGet:
+		return errors.New("mirror can't be used with urls, url_command or method")
+	case c.SHA256 != "" || c.Extract != "" || c.JSONPath != "" || c.TransformCommand != "":
+		return errors.New("mirror can't be used with sha256, extract, json_path or transform_command")
+	case c.WatchOnly || c.appendMode() || c.CheckInterval > 0 || c.MirrorTo != "":
+		return errors.New("mirror can't be used with watch_only, mode append, check_interval or mirror_to")
+	case c.OnMissing != "" && c.OnMissing != onMissingKeep:
+		return errors.New("mirror can't be used with on_missing")
+	}
+	return nil
+}
+
+func (m *dirMirror) maxDepth() int {
+	if m.MaxDepth > 0 {
+		return m.MaxDepth
+	}
+	return defaultDirMirrorMaxDepth
+}
+
+func (m *dirMirror) maxFiles() int {
+	if m.MaxFiles > 0 {
+		return m.MaxFiles
+	}
+	return defaultDirMirrorMaxFiles
+}
+
+// budgetWriter fails instead of writing more than the remaining bytes
+type budgetWriter struct {
+	w         io.Writer
+	remaining int64
+	err       error
+	exceeded  bool
+}
+
+func (b *budgetWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining {
+		b.exceeded = true
+		return 0, b.err
+	}
+	b.remaining -= int64(len(p))
+	return b.w.Write(p)
+}
+
+func (m *dirMirror) maxTotalSize() int64 {
+	if m.MaxTotalSize > 0 {
+		return int64(m.MaxTotalSize)
+	}
+	return int64(defaultDirMirrorMaxTotalSize)
+}
+
+// errTooLarge is returned once the synced files exceed max_total_size
+func (m *dirMirror) errTooLarge() error {
+	return fmt.Errorf("Mirror exceeds max_total_size of %s", byteSize(m.maxTotalSize()))
+}
+
+// matches reports whether the relative path is selected by the include
+// and exclude patterns. Patterns containing a slash are matched against
+// the full path, others against the file name only.
+func (m *dirMirror) matches(rel string) bool {
+	match := func(patterns stringList) bool {
+		for _, pattern := range patterns {
+			name := rel
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(rel)
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return (len(m.Include) == 0 || match(m.Include)) && !match(m.Exclude)
+}
+
+func (c *configFileSource) setSyncStatus(s SyncStatus) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.sync = &s
+}
+
+func (c *configFileSource) getSyncStatus() *SyncStatus {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.sync == nil {
+		return nil
+	}
+	s := *c.sync
+	return &s
+}
+
+// cleanMirrorPath normalizes a path from the listing and rejects paths
+// leaving the mirror directory
+func cleanMirrorPath(rel string) (string, bool) {
+	rel = path.Clean("/" + strings.Replace(rel, `\`, "/", -1))[1:]
+	if rel == "" || strings.HasPrefix(path.Base(rel), dirMirrorTempPrefix) {
+		return "", false
+	}
+	return rel, true
+}
+
+// listDirMirror returns the sorted relative paths of all files of the
+// listing and the base URL they are relative to
+func (c *configFileSource) listDirMirror(ctx context.Context, client *http.Client, userAgent string) (*url.URL, []string, error) {
+	base, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []string
+	switch c.Mirror.Index {
+	case dirMirrorIndexJSON, dirMirrorIndexPlain:
+		files, err = c.listManifest(ctx, client, userAgent, base)
+		base = base.ResolveReference(&url.URL{Path: "./"})
+	default:
+		if !strings.HasSuffix(base.Path, "/") {
+			base.Path += "/"
+		}
+		files, err = c.listAutoindex(ctx, client, userAgent, base)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var selected []string
+	for _, rel := range files {
+		if c.Mirror.matches(rel) {
+			selected = append(selected, rel)
+		}
+	}
+	sort.Strings(selected)
+
+	if len(selected) > c.Mirror.maxFiles() {
+		return nil, nil, fmt.Errorf("Listing contains %d files, more than max_files of %d", len(selected), c.Mirror.maxFiles())
+	}
+	return base, selected, nil
+}
+
+func (c *configFileSource) getIndex(ctx context.Context, client *http.Client, userAgent string, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.prepareRequest(req, userAgent); err != nil {
+		return nil, err
+	}
+	// The index is parsed here, let the transport decode it
+	req.Header.Del("Accept-Encoding")
+
+	res, err := ctxhttp.Do(ctx, client, req)
+	if err != nil {
+		return nil, wrapTransportError(ctx, client, req, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Got status code %d for index %s", res.StatusCode, u.Redacted())
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(res.Body, dirMirrorMaxIndexSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > dirMirrorMaxIndexSize {
+		return nil, fmt.Errorf("Index %s is larger than %s", u.Redacted(), byteSize(dirMirrorMaxIndexSize))
+	}
+	return raw, nil
+}
+
+// listManifest reads a JSON array of paths (or objects with a path) or a
+// plain list with one path per line
+func (c *configFileSource) listManifest(ctx context.Context, client *http.Client, userAgent string, u *url.URL) ([]string, error) {
+	raw, err := c.getIndex(ctx, client, userAgent, u)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	if c.Mirror.Index == dirMirrorIndexJSON {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("Invalid JSON manifest: %s", err)
+		}
+		for _, item := range items {
+			var entry struct {
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(item, &entry.Path); err != nil {
+				if err := json.Unmarshal(item, &entry); err != nil {
+					return nil, fmt.Errorf("Invalid JSON manifest entry %s", item)
+				}
+			}
+			entries = append(entries, entry.Path)
+		}
+	} else {
+		scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				entries = append(entries, line)
+			}
+		}
+	}
+
+	var files []string
+	for _, entry := range entries {
+		rel, ok := cleanMirrorPath(entry)
+		if !ok || strings.Count(rel, "/") > c.Mirror.maxDepth() {
+			c.log.debug("Skipping manifest entry %q of '%s'", entry, c.displayURL())
+			continue
+		}
+		files = append(files, rel)
+	}
+	return files, nil
+}
+
+// listAutoindex follows the links of HTML directory listings to direct
+// children, subdirectories are descended up to max_depth
+func (c *configFileSource) listAutoindex(ctx context.Context, client *http.Client, userAgent string, base *url.URL) ([]string, error) {
+	type dir struct {
+		rel   string
+		depth int
+	}
+
+	var (
+		files []string
+		seen  = map[string]bool{}
+		queue = []dir{{}}
+	)
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+
+		dirURL := base.ResolveReference(&url.URL{Path: d.rel})
+		raw, err := c.getIndex(ctx, client, userAgent, dirURL)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range autoindexHref.FindAllStringSubmatch(string(raw), -1) {
+			ref, err := url.Parse(m[1])
+			if err != nil {
+				continue
+			}
+			u := dirURL.ResolveReference(ref)
+			if u.Scheme != dirURL.Scheme || u.Host != dirURL.Host || !strings.HasPrefix(u.Path, dirURL.Path) {
+				continue
+			}
+
+			name := strings.TrimPrefix(u.Path, dirURL.Path)
+			isDir := strings.HasSuffix(name, "/")
+			name = strings.TrimSuffix(name, "/")
+			if name == "" || strings.Contains(name, "/") {
+				// Only direct children, skips sorting links and parents
+				continue
+			}
+
+			rel, ok := cleanMirrorPath(d.rel + name)
+			if !ok || seen[rel] {
+				continue
+			}
+			seen[rel] = true
+
+			switch {
+			case isDir && d.depth < c.Mirror.maxDepth():
+				queue = append(queue, dir{rel: rel + "/", depth: d.depth + 1})
+			case !isDir:
+				files = append(files, rel)
+			}
+
+			if len(files) > c.Mirror.maxFiles() && len(c.Mirror.Include) == 0 {
+				return nil, fmt.Errorf("Listing contains more than max_files of %d files", c.Mirror.maxFiles())
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// syncMirrorFile downloads one file of the listing if it changed and
+// reports whether it was written
+func (c *configFileSource) syncMirrorFile(ctx context.Context, cancel context.CancelFunc, timeout time.Duration,
+	client *http.Client, userAgent string, totalRate *rateLimiter, fileURL *url.URL, local string,
+	prev dirMirrorFile, conditional bool, budget int64) (bool, dirMirrorFile, int64, error) {
+	req, err := http.NewRequest(http.MethodGet, fileURL.String(), nil)
+	if err != nil {
+		return false, prev, 0, err
+	}
+	if err := c.prepareRequest(req, userAgent); err != nil {
+		return false, prev, 0, err
+	}
+	if conditional && prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if conditional && prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+
+	res, err := ctxhttp.Do(ctx, client, req)
+	if err != nil {
+		return false, prev, 0, wrapTransportError(ctx, client, req, err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && conditional:
+		return false, prev, 0, nil
+	case res.StatusCode != http.StatusOK:
+		return false, prev, 0, fmt.Errorf("Got status code %d", res.StatusCode)
+	case c.lengthCheckable(res) && res.ContentLength > budget:
+		return false, prev, 0, c.Mirror.errTooLarge()
+	}
+
+	if err := mkdirAll(filepath.Dir(local), c.dirMode()); err != nil {
+		return false, prev, 0, err
+	}
+	t, err := ioutil.TempFile(filepath.Dir(local), dirMirrorTempPrefix)
+	if err != nil {
+		return false, prev, 0, err
+	}
+	defer os.Remove(t.Name())
+	defer t.Close()
+
+	// The file is not installed if it exceeds the remaining budget
+	out := &budgetWriter{w: t, remaining: budget, err: c.Mirror.errTooLarge()}
+	n, err := c.copyBody(ctx, cancel, timeout, local, totalRate, res, out)
+	if out.exceeded {
+		return false, prev, n, out.err
+	}
+	if err != nil {
+		return false, prev, n, err
+	}
+	if c.lengthCheckable(res) && n != res.ContentLength {
+		return false, prev, n, fmt.Errorf("Download truncated: got %d of %d bytes", n, res.ContentLength)
+	}
+	if err := t.Close(); err != nil {
+		return false, prev, n, err
+	}
+	if err := c.applyFileMode(t.Name()); err != nil {
+		return false, prev, n, err
+	}
+	if err := replaceFile(t.Name(), local); err != nil {
+		return false, prev, n, err
+	}
+
+	if mtime, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(local, mtime, mtime)
+	}
+
+	return true, dirMirrorFile{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}, n, nil
+}
+
+// deleteVanished removes local files matching the patterns which are
+// not part of the listing anymore and the directories they leave empty
+func (c *configFileSource) deleteVanished(targetPath string, listed map[string]dirMirrorFile) (int, error) {
+	var vanished []string
+	err := filepath.Walk(targetPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(targetPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := listed[rel]; !ok && c.Mirror.matches(rel) && !strings.HasPrefix(info.Name(), dirMirrorTempPrefix) {
+			vanished = append(vanished, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, p := range vanished {
+		if err := os.Remove(p); err != nil {
+			return i, err
+		}
+		for dir := filepath.Dir(p); isWithin(targetPath, dir) && dir != filepath.Clean(targetPath); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return len(vanished), nil
+}
+
+// executeDirMirror syncs the target directory with the listing of the
+// mirror entry and records one summary for the run
+func (c *configFile) executeDirMirror(ctx context.Context, cancel context.CancelFunc, timeout time.Duration,
+	client *http.Client, targetPath string, targetConfig *configFileSource, userAgent string, totalRate *rateLimiter,
+	forceFetch bool, rec *FetchRecord) error {
+	base, files, err := targetConfig.listDirMirror(ctx, client, userAgent)
+	if err != nil {
+		return err
+	}
+
+	event := targetConfig.fileEvent(targetPath)
+	initial := targetConfig.lastCall.IsZero() && targetConfig.lastDownload.IsZero()
+	if err := mkdirAll(targetPath, targetConfig.dirMode()); err != nil {
+		return err
+	}
+
+	status := SyncStatus{Time: time.Now(), Files: len(files)}
+	listed := make(map[string]dirMirrorFile, len(files))
+
+	if err := c.syncDirMirror(ctx, cancel, timeout, client, targetPath, targetConfig, userAgent, totalRate,
+		forceFetch, base, files, listed, &status, rec); err != nil {
+		// Keep the validators of the files synced so far
+		for rel, f := range targetConfig.syncFiles {
+			if _, ok := listed[rel]; !ok {
+				listed[rel] = f
+			}
+		}
+		targetConfig.runMu.Lock()
+		targetConfig.syncFiles = listed
+		targetConfig.runMu.Unlock()
+		return err
+	}
+	status.Bytes = rec.Bytes
+
+	if targetConfig.isRetired() {
+		return errRetired
+	}
+
+	if targetConfig.Mirror.Delete {
+		if status.Deleted, err = targetConfig.deleteVanished(targetPath, listed); err != nil {
+			targetConfig.runMu.Lock()
+			targetConfig.syncFiles = listed
+			targetConfig.runMu.Unlock()
+			return fmt.Errorf("Could not delete vanished files: %s", err)
+		}
+	}
+
+	targetConfig.logf(levelInfo, "Synced mirror '%s': %d files, %d downloaded (%s), %d unchanged, %d deleted",
+		targetPath, status.Files, status.Downloaded, byteSize(status.Bytes), status.Unchanged, status.Deleted)
+	targetConfig.setSyncStatus(status)
+
+	rec.Outcome = outcomeUnchanged
+	if status.Downloaded > 0 || status.Deleted > 0 {
+		rec.Outcome = outcomeChanged
+	}
+
+	// Files gone upstream are forgotten also when they are kept locally
+	targetConfig.runMu.Lock()
+	targetConfig.syncFiles = listed
+	targetConfig.lastDownload = time.Now()
+	targetConfig.missing = false
+	targetConfig.finish("", "")
+	targetConfig.runMu.Unlock()
+	c.saveState()
+
+	if rec.Outcome != outcomeChanged {
+		return nil
+	}
+
+	c.notifyCommand(notifyEvent{
+		Event: notifyEventChanged,
+		Path:  targetPath,
+		URL:   targetConfig.URL,
+	})
+
+	rec.fileEvent = event
+	c.streamFetch(targetPath, targetConfig, rec)
+	c.announceSuccess(targetPath, targetConfig, downloadResult{FinalURL: targetConfig.URL, Event: event, Initial: initial})
+
+	return nil
+}
+
+// syncDirMirror downloads the changed files of the listing and records
+// their validators in listed
+func (c *configFile) syncDirMirror(ctx context.Context, cancel context.CancelFunc, timeout time.Duration,
+	client *http.Client, targetPath string, targetConfig *configFileSource, userAgent string, totalRate *rateLimiter,
+	forceFetch bool, base *url.URL, files []string, listed map[string]dirMirrorFile, status *SyncStatus,
+	rec *FetchRecord) error {
+	c.RLock()
+	roots := c.AllowedTargetRoots
+	c.RUnlock()
+
+	known := targetConfig.syncFiles
+	for _, rel := range files {
+		local, err := archiveEntryPath(targetPath, rel, 0)
+		if err != nil || local == "" {
+			continue
+		}
+		// Subdirectories of the mirror might be symlinks
+		if err := checkTargetRoot(roots, local); err != nil {
+			return err
+		}
+
+		fileURL := base.ResolveReference(&url.URL{Path: rel})
+		prev, ok := known[rel]
+		_, statErr := os.Stat(local)
+		conditional := ok && statErr == nil && !forceFetch && !targetConfig.IgnoreETag
+
+		budget := targetConfig.Mirror.maxTotalSize() - status.TotalSize
+		written, f, n, err := targetConfig.syncMirrorFile(ctx, cancel, timeout, client, userAgent, totalRate,
+			fileURL, local, prev, conditional, budget)
+		rec.Bytes += n
+		if err != nil {
+			return fmt.Errorf("Could not sync '%s': %s", rel, err)
+		}
+		listed[rel] = f
+
+		if written {
+			status.Downloaded++
+		} else {
+			status.Unchanged++
+		}
+		if fi, err := os.Stat(local); err == nil {
+			status.TotalSize += fi.Size()
+		}
+		if status.TotalSize > targetConfig.Mirror.maxTotalSize() {
+			// An unchanged file kept locally
+			return targetConfig.Mirror.errTooLarge()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestDirMirrorEnforcesMaxTotalSize(t *testing.T) {
+	for name, announce := range map[string]bool{"content length": true, "streamed": false} {
+		t.Run(name, func(t *testing.T) {
+			content := strings.Repeat("x", 600)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/index" {
+					fmt.Fprint(w, "a\nb\n")
+					return
+				}
+				if announce {
+					w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+				}
+				// Flushing before the body stops the server from
+				// adding the length itself
+				w.(http.Flusher).Flush()
+				fmt.Fprint(w, content)
+			}))
+			defer srv.Close()
+
+			target := filepath.Join(t.TempDir(), "mirror")
+			w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s/index\n    mirror:\n      index: plain\n      max_total_size: 1000\n",
+				target, srv.URL))
+
+			err := w.config.runFetch(context.Background(), target, w.lookup(target), false)
+			if err == nil || !strings.Contains(err.Error(), "max_total_size") {
+				t.Fatalf("sync exceeding max_total_size returned %v", err)
+			}
+
+			entries, err := ioutil.ReadDir(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			if len(names) != 1 || names[0] != "a" {
+				t.Errorf("mirror contains %v, want only [a]", names)
+			}
+			if _, err := os.Stat(filepath.Join(target, "b")); !os.IsNotExist(err) {
+				t.Errorf("file exceeding max_total_size was installed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package watch
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultEmailBatchWindow = 5 * time.Minute
+	defaultEmailSubject     = "[download-watch] {{.Host}}: {{.Failing}} failing, {{.Recovered}} recovered"
+	defaultEmailBody        = "{{range .Events}}{{.Text}}\n{{end}}"
+
+	emailTLSStartTLS = "starttls"
+	emailTLSImplicit = "implicit"
+	emailTLSNone     = "none"
+
+	emailTimeout = 30 * time.Second
+)
+
+// emailConfig describes the SMTP server and the mails to send
+type emailConfig struct {
+	Host        string        `yaml:"host"`
+	Port        int           `yaml:"port"`
+	TLS         string        `yaml:"tls"`
+	Username    string        `yaml:"username"`
+	Password    string        `yaml:"password"`
+	From        string        `yaml:"from"`
+	To          []string      `yaml:"to"`
+	BatchWindow time.Duration `yaml:"batch_window"`
+	Subject     string        `yaml:"subject"`
+	Body        string        `yaml:"body"`
+}
+
+func (e *emailConfig) validate() error {
+	if e.Host == "" || e.From == "" || len(e.To) == 0 {
+		return errors.New("Email notifications need host, from and to")
+	}
+
+	switch e.TLS {
+	case "", emailTLSStartTLS, emailTLSImplicit, emailTLSNone:
+	default:
+		return fmt.Errorf("Invalid email tls %q, use starttls, implicit or none", e.TLS)
+	}
+
+	if _, _, err := e.templates(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (e *emailConfig) templates() (subject, body *template.Template, err error) {
+	s, b := e.Subject, e.Body
+	if s == "" {
+		s = defaultEmailSubject
+	}
+	if b == "" {
+		b = defaultEmailBody
+	}
+
+	if subject, err = template.New("subject").Parse(s); err != nil {
+		return nil, nil, fmt.Errorf("Invalid email subject template: %s", err)
+	}
+	if body, err = template.New("body").Parse(b); err != nil {
+		return nil, nil, fmt.Errorf("Invalid email body template: %s", err)
+	}
+	return subject, body, nil
+}
+
+// emailEvent is a failure event as passed to the templates
+type emailEvent struct {
+	failureEvent
+	Text string
+}
+
+// emailData is passed to the subject and body templates
+type emailData struct {
+	Host      string
+	Events    []emailEvent
+	Failing   int
+	Recovered int
+}
+
+// emailBatcher collects events for the batch window and sends them as
+// one mail so an outage of many files does not create a mail storm
+type emailBatcher struct {
+	mu      sync.Mutex
+	pending []failureEvent
+	config  func() *emailConfig
+	host    string
+	queue   *notifyQueue
+	log     *logger
+}
+
+// Add queues the event, the first event of a batch starts its window
+func (b *emailBatcher) Add(ev failureEvent, window time.Duration) {
+	if window <= 0 {
+		window = defaultEmailBatchWindow
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.pending) == 0 {
+		time.AfterFunc(window, b.flush)
+	}
+	b.pending = append(b.pending, ev)
+}
+
+func (b *emailBatcher) flush() {
+	b.mu.Lock()
+	events := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	cfg := b.config()
+	if cfg == nil || len(events) == 0 {
+		return
+	}
+
+	b.queue.Enqueue(func() {
+		if err := cfg.send(b.host, events); err != nil {
+			b.log.logf(levelWarn, "Could not send notification email for %d events: %s", len(events), err)
+		}
+	}, "mail with %d events", len(events))
+}
+
+func (e *emailConfig) send(host string, events []failureEvent) error {
+	subjectTpl, bodyTpl, err := e.templates()
+	if err != nil {
+		return err
+	}
+
+	data := emailData{}
+	data.Host = host
+	for _, ev := range events {
+		data.Events = append(data.Events, emailEvent{failureEvent: ev, Text: notificationText(ev)})
+		if ev.Event == failureEventRecovered {
+			data.Recovered++
+		} else {
+			data.Failing++
+		}
+	}
+
+	subject, body := new(bytes.Buffer), new(bytes.Buffer)
+	if err := subjectTpl.Execute(subject, data); err != nil {
+		return err
+	}
+	if err := bodyTpl.Execute(body, data); err != nil {
+		return err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", strings.TrimSpace(subject.String()))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body.String(), "\n", "\r\n", -1))
+
+	return e.deliver(msg.Bytes())
+}
+
+func (e *emailConfig) deliver(msg []byte) error {
+	port := e.Port
+	if port == 0 {
+		port = 587
+		if e.TLS == emailTLSImplicit {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: e.Host}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: emailTimeout}
+	if e.TLS == emailTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(emailTimeout))
+
+	client, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if e.TLS == "" || e.TLS == emailTLSStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %s", err)
+		}
+	}
+
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,184 @@
+package watch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const encryptedTag = "!encrypted"
+
+var (
+	// encryptedValue matches a value tagged with !encrypted, the key of
+	// a mapping or the dash of a list item may precede it
+	encryptedValue = regexp.MustCompile(`^(\s*(?:-\s+)?(?:(?:"[^"]*"|'[^']*'|[^\s#'"][^#]*?):\s+)?)` + encryptedTag + `\s+(.*)$`)
+	encryptedKey   = regexp.MustCompile(`^\s*(?:-\s+)?("[^"]*"|'[^']*'|[^\s#'"][^#]*?):`)
+
+	// sopsMarker identifies values encrypted by sops
+	sopsMarker = regexp.MustCompile(`(?m)^sops:\s*$|ENC\[AES256_GCM,`)
+)
+
+// secretSet holds the decrypted values of the configurations loaded for
+// a Watcher and the secrets looked up in the keyring, they are masked in
+// logs, errors and the status
+type secretSet struct {
+	sync.RWMutex
+	values map[string]bool
+}
+
+// minSecretLength keeps very short values from masking unrelated text
+const minSecretLength = 4
+
+func newSecretSet() *secretSet {
+	return &secretSet{values: map[string]bool{}}
+}
+
+func (s *secretSet) add(v string) {
+	if len(v) < minSecretLength {
+		return
+	}
+	s.Lock()
+	s.values[v] = true
+	s.Unlock()
+}
+
+// merge adds the secrets of in, the values of previous configurations
+// are kept as they might still show up in the history
+func (s *secretSet) merge(in *secretSet) {
+	if in == nil || in == s {
+		return
+	}
+	in.RLock()
+	defer in.RUnlock()
+	for v := range in.values {
+		s.add(v)
+	}
+}
+
+func (s *secretSet) mask(str string) string {
+	if s == nil {
+		return str
+	}
+	s.RLock()
+	defer s.RUnlock()
+	for v := range s.values {
+		str = strings.Replace(str, v, redacted, -1)
+	}
+	return str
+}
+
+// decryptConfig replaces the values tagged with !encrypted by their
+// plaintext. A value is either a single line with the base64 encoded
+// age file or a block scalar holding the armored age file. The line
+// numbers are kept so YAML errors still point to the right line. The
+// plaintexts are added to the secrets.
+func decryptConfig(raw []byte, identityPath string, secrets *secretSet) ([]byte, error) {
+	if sopsMarker.Match(raw) {
+		return nil, errors.New("Config is encrypted with sops, decrypt it (e.g. sops exec-file) or use !encrypted values")
+	}
+	if !bytes.Contains(raw, []byte(encryptedTag)) {
+		return raw, nil
+	}
+
+	var identities []age.Identity
+	lines := strings.Split(string(raw), "\n")
+	for i := 0; i < len(lines); i++ {
+		m := encryptedValue.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+
+		name := fmt.Sprintf("line %d", i+1)
+		if k := encryptedKey.FindStringSubmatch(m[1]); k != nil {
+			name = fmt.Sprintf("%s (line %d)", strings.Trim(k[1], `"'`), i+1)
+		}
+
+		tag, value := i, strings.TrimSpace(m[2])
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			indent := len(lines[i]) - len(strings.TrimLeft(lines[i], " "))
+			var block []string
+			for i+1 < len(lines) {
+				next := lines[i+1]
+				if strings.TrimSpace(next) != "" && len(next)-len(strings.TrimLeft(next, " ")) <= indent {
+					break
+				}
+				block = append(block, strings.TrimSpace(next))
+				lines[i+1] = ""
+				i++
+			}
+			value = strings.TrimSpace(strings.Join(block, "\n"))
+		} else if f := strings.Fields(value); len(f) > 0 {
+			value = f[0]
+		}
+
+		if identities == nil {
+			var err error
+			if identities, err = loadAgeIdentities(identityPath); err != nil {
+				return nil, err
+			}
+		}
+
+		plain, err := decryptValue(value, identities)
+		if err != nil {
+			return nil, fmt.Errorf("Could not decrypt value of %s: %s", name, err)
+		}
+		secrets.add(plain)
+
+		quoted, _ := json.Marshal(plain)
+		lines[tag] = m[1] + string(quoted)
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func decryptValue(value string, identities []age.Identity) (string, error) {
+	var src io.Reader
+	if strings.HasPrefix(value, armor.Header) {
+		src = armor.NewReader(strings.NewReader(value))
+	} else {
+		bin, err := base64.StdEncoding.DecodeString(strings.Trim(value, `"'`))
+		if err != nil {
+			return "", errors.New("value is neither an armored nor a base64 encoded age file")
+		}
+		src = bytes.NewReader(bin)
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return "", err
+	}
+	plain, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func loadAgeIdentities(path string) ([]age.Identity, error) {
+	if path == "" {
+		return nil, errors.New("Config contains !encrypted values but no age identity was given (--age-identity or SOPS_AGE_KEY_FILE)")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read age identity: %s", err)
+	}
+	defer f.Close()
+
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse age identity %s: %s", path, err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,139 @@
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	eventFetchStarted    = "fetch_started"
+	eventFetchUnchanged  = "fetch_unchanged"
+	eventFetchChanged    = "fetch_changed"
+	eventFetchFailed     = "fetch_failed"
+	eventCommandStarted  = "command_started"
+	eventCommandFinished = "command_finished"
+	eventConfigReloaded  = "config_reloaded"
+
+	eventFormatJSON = "json"
+)
+
+// streamEvent is one line of the event stream, the field names are
+// part of the interface and must not be changed
+type streamEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Path       string    `json:"path,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Outcome    string    `json:"outcome,omitempty"`
+	SHA256     string    `json:"sha256,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	Files      int       `json:"files,omitempty"`
+	Diff       string    `json:"diff,omitempty"`
+	FileEvent  string    `json:"file_event,omitempty"`
+}
+
+// eventStream writes the events as JSON lines, events are written
+// synchronously by the goroutine they happened in so the order per file
+// matches the actual order. Without out the stream is disabled.
+type eventStream struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// enable starts writing events in the given format to out
+func (s *eventStream) enable(format string, out io.Writer) error {
+	switch format {
+	case "":
+		return nil
+	case eventFormatJSON:
+		s.mu.Lock()
+		s.out = out
+		s.mu.Unlock()
+		return nil
+	default:
+		return fmt.Errorf("Unsupported event format %q, only json is supported", format)
+	}
+}
+
+// emitEvent writes the event to the stream if it is enabled
+func (c *configFile) emitEvent(ev streamEvent) {
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+
+	if c.events.out == nil {
+		return
+	}
+
+	ev.Time = time.Now()
+	line, err := json.Marshal(ev)
+	if err != nil {
+		c.log.logf(levelWarn, "Could not encode %s event: %s", ev.Event, err)
+		return
+	}
+	c.events.out.Write(append(line, '\n'))
+}
+
+// fetchEvent returns the event for a finished fetch of the entry
+func fetchEvent(filePath string, fc *configFileSource, rec FetchRecord) streamEvent {
+	ev := streamEvent{
+		Event:      eventFetchChanged,
+		Path:       filePath,
+		URL:        fc.displayURL(),
+		Outcome:    rec.Outcome,
+		SHA256:     fc.snapshot().lastSHA256,
+		Bytes:      rec.Bytes,
+		DurationMS: int64(rec.Duration / time.Millisecond),
+		Error:      rec.Error,
+		Diff:       rec.diff,
+		FileEvent:  rec.fileEvent,
+	}
+
+	switch rec.Outcome {
+	case outcomeError:
+		ev.Event = eventFetchFailed
+		ev.SHA256 = ""
+	case outcomeUnchanged, outcomeNotModified, outcomeRejectedOlder, outcomeChecked:
+		ev.Event = eventFetchUnchanged
+	}
+	return ev
+}
+
+// streamFetch writes the finished fetch to the event stream once, the
+// success command is started afterwards so its events follow the fetch
+func (c *configFile) streamFetch(filePath string, fc *configFileSource, rec *FetchRecord) {
+	if rec.streamed {
+		return
+	}
+	rec.streamed = true
+
+	r := *rec
+	if r.Duration == 0 {
+		r.Duration = time.Since(r.Time)
+	}
+	c.emitEvent(fetchEvent(filePath, fc, r))
+}
+
+// commandExitCode returns the exit code of a finished command or -1 if
+// it could not be started or was killed
+func commandExitCode(cmd *exec.Cmd) *int {
+	code := -1
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+	return &code
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
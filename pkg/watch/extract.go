@@ -0,0 +1,344 @@
+package watch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	extractTarGz = "tar.gz"
+	extractTgz   = "tgz"
+	extractTar   = "tar"
+	extractZip   = "zip"
+)
+
+func (c *configFileSource) validateExtract() error {
+	switch c.Extract {
+	case "":
+		return nil
+	case extractTarGz, extractTgz, extractTar, extractZip:
+	default:
+		return fmt.Errorf("Invalid extract %q, use tar.gz, tar or zip", c.Extract)
+	}
+
+	if c.ExtractTo == "" && c.ExtractMember == "" {
+		return errors.New("extract needs extract_to or extract_member")
+	}
+	if c.StripComponents < 0 {
+		return errors.New("strip_components must not be negative")
+	}
+	if c.DiscardArchive && c.ExtractMember != "" {
+		return errors.New("discard_archive can't be used with extract_member")
+	}
+	if c.DiscardArchive && c.MirrorTo != "" {
+		return errors.New("mirror_to needs the archive, discard_archive can't be used")
+	}
+	return nil
+}
+
+// needsExtract reports whether the downloaded archive has to be
+// unpacked: it changed or the extracted tree is gone
+func (c *configFileSource) needsExtract(sha string) bool {
+	if c.Extract == "" || c.ExtractMember != "" {
+		return false
+	}
+	if _, err := os.Stat(c.ExtractTo); err != nil {
+		return true
+	}
+	return sha != c.lastSHA256
+}
+
+// extractArchive unpacks the archive into a temporary directory next to
+// extract_to and swaps it in once everything was extracted
+func (c *configFileSource) extractArchive(archive string) error {
+	dest := filepath.Clean(c.ExtractTo)
+	if err := mkdirAll(filepath.Dir(dest), c.dirMode()); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(filepath.Dir(dest), "."+filepath.Base(dest)+".extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	switch c.Extract {
+	case extractZip:
+		err = extractZipArchive(archive, tmp, c.StripComponents)
+	default:
+		err = extractTarArchive(c.log, archive, c.Extract != extractTar, tmp, c.StripComponents)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp, c.dirMode()); err != nil {
+		return err
+	}
+	return swapDirectory(tmp, dest)
+}
+
+// swapDirectory replaces dst by src with two renames, the old tree is
+// only removed after the new one is in place
+func swapDirectory(src, dst string) error {
+	var old string
+	if _, err := os.Lstat(dst); err == nil {
+		old = fmt.Sprintf("%s.old-%d", dst, time.Now().UnixNano())
+		if err := os.Rename(dst, old); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		if old != "" {
+			os.Rename(old, dst)
+		}
+		return err
+	}
+
+	if old != "" {
+		return os.RemoveAll(old)
+	}
+	return nil
+}
+
+// archiveEntryPath returns the path to extract the entry to after
+// stripping the leading components, entries consisting only of stripped
+// components return an empty path. Entries leaving the root are
+// rejected.
+func archiveEntryPath(root, name string, strip int) (string, error) {
+	var parts []string
+	for _, p := range strings.Split(strings.Replace(name, `\`, "/", -1), "/") {
+		switch p {
+		case "", ".":
+		case "..":
+			return "", securityError{fmt.Errorf("Archive entry %q leaves the target directory", name)}
+		default:
+			parts = append(parts, p)
+		}
+	}
+
+	if len(parts) <= strip {
+		return "", nil
+	}
+	return filepath.Join(root, filepath.FromSlash(path.Join(parts[strip:]...))), nil
+}
+
+func isWithin(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkResolvedParent resolves the symlinks of the existing parent
+// directories of the path, which earlier entries of the archive may have
+// created, and checks the result stays within the root. It returns the
+// resolved parent.
+func checkResolvedParent(root, p string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	dir, rest := filepath.Dir(p), ""
+	for {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			real = filepath.Join(real, rest)
+			if !isWithin(realRoot, real) {
+				return "", securityError{fmt.Errorf("Archive entry %q leaves the target directory through a symlink", p)}
+			}
+			return real, nil
+		}
+		if !os.IsNotExist(err) || !isWithin(root, dir) || dir == root {
+			return "", err
+		}
+		dir, rest = filepath.Dir(dir), filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// extractSymlink creates the link after checking its target stays
+// within the root, also after resolving the links created before
+func extractSymlink(root, target, linkname string) error {
+	if filepath.IsAbs(linkname) || !isWithin(root, filepath.Join(filepath.Dir(target), linkname)) {
+		return securityError{fmt.Errorf("Archive symlink %q -> %q leaves the target directory", target, linkname)}
+	}
+	parent, err := checkResolvedParent(root, target)
+	if err != nil {
+		return err
+	}
+	if realRoot, err := filepath.EvalSymlinks(root); err != nil || !isWithin(realRoot, filepath.Join(parent, linkname)) {
+		return securityError{fmt.Errorf("Archive symlink %q -> %q leaves the target directory", target, linkname)}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(linkname, target)
+}
+
+func extractRegularFile(root, target string, r io.Reader, mode os.FileMode) error {
+	if _, err := checkResolvedParent(root, target); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Never write through a symlink of an earlier entry
+	if stat, err := os.Lstat(target); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// extractHardlink links the target to an earlier entry, both paths must
+// stay within the root after resolving symlinks
+func extractHardlink(root, src, target string) error {
+	if _, err := checkResolvedParent(root, src); err != nil {
+		return err
+	}
+	if _, err := checkResolvedParent(root, target); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Link(src, target)
+}
+
+func extractTarArchive(l *logger, archive string, gzipped bool, root string, strip int) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := archiveEntryPath(root, hdr.Name, strip)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if _, err = checkResolvedParent(root, target); err == nil {
+				err = os.MkdirAll(target, hdr.FileInfo().Mode().Perm()|0700)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractRegularFile(root, target, tr, hdr.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = extractSymlink(root, target, hdr.Linkname)
+		case tar.TypeLink:
+			var src string
+			if src, err = archiveEntryPath(root, hdr.Linkname, strip); err == nil {
+				if src == "" {
+					err = fmt.Errorf("Archive hardlink %q points to a stripped entry", hdr.Name)
+				} else {
+					err = extractHardlink(root, src, target)
+				}
+			}
+		default:
+			l.debug("Skipping archive entry %q of type %c", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZipArchive(archive string, root string, strip int) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		target, err := archiveEntryPath(root, zf.Name, strip)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+
+		mode := zf.Mode()
+		if mode.IsDir() {
+			if _, err := checkResolvedParent(root, target); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(target, mode.Perm()|0700); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := extractZipEntry(zf, root, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipEntry(zf *zip.File, root, target string) error {
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if zf.Mode()&os.ModeSymlink != 0 {
+		linkname, err := ioutil.ReadAll(io.LimitReader(r, 4096))
+		if err != nil {
+			return err
+		}
+		return extractSymlink(root, target, string(linkname))
+	}
+
+	return extractRegularFile(root, target, r, zf.Mode())
+}
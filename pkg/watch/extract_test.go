@@ -0,0 +1,116 @@
+package watch
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+func writeTar(t *testing.T, path string, entries []tarEntry) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.body))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTarRefusesChainedSymlinkEscape(t *testing.T) {
+	for name, entries := range map[string][]tarEntry{
+		"file": {
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "a/b", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "b/pwned", typeflag: tar.TypeReg, body: "pwned"},
+		},
+		"hardlink": {
+			{name: "f", typeflag: tar.TypeReg, body: "data"},
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "a/b", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "b/pwned", typeflag: tar.TypeLink, linkname: "f"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			root := filepath.Join(dir, "root")
+			if err := os.Mkdir(root, 0755); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(dir, "archive.tar")
+			writeTar(t, archive, entries)
+
+			err := extractTarArchive(nil, archive, false, root, 0)
+			var sec securityError
+			if !errors.As(err, &sec) {
+				t.Fatalf("expected a security error, got %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "pwned")); !os.IsNotExist(err) {
+				t.Fatalf("pwned was written outside of the root: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractTarKeepsSymlinksWithinRoot(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive.tar")
+	writeTar(t, archive, []tarEntry{
+		{name: "sub/", typeflag: tar.TypeDir},
+		{name: "sub/f", typeflag: tar.TypeReg, body: "data"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "sub"},
+		{name: "link/g", typeflag: tar.TypeReg, body: "more"},
+		{name: "hard", typeflag: tar.TypeLink, linkname: "sub/f"},
+	})
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractTarArchive(nil, archive, false, root, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]string{"sub/g": "more", "hard": "data"} {
+		got, err := os.ReadFile(filepath.Join(root, p))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v), want %q", p, got, err, want)
+		}
+	}
+}
+
+func TestExtractTarRefusesLexicalEscape(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive.tar")
+	writeTar(t, archive, []tarEntry{{name: "../pwned", typeflag: tar.TypeReg, body: "pwned"}})
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractTarArchive(nil, archive, false, root, 0); err == nil {
+		t.Fatal("expected an error")
+	}
+}
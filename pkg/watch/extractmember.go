@@ -0,0 +1,169 @@
+package watch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+func (c *configFileSource) validateExtractMember() error {
+	if c.ExtractMember == "" {
+		return nil
+	}
+
+	if c.Extract == "" {
+		return errors.New("extract_member needs extract to specify the archive format")
+	}
+	if c.ExtractTo != "" {
+		return errors.New("extract_member and extract_to can't be used together")
+	}
+	if _, err := path.Match(c.ExtractMember, ""); err != nil {
+		return fmt.Errorf("Invalid extract_member %q: %s", c.ExtractMember, err)
+	}
+	return nil
+}
+
+// matchesMember reports whether the archive entry is selected by the
+// extract_member pattern: patterns containing a slash are matched
+// against the full entry name, others against the file name only
+func (c *configFileSource) matchesMember(name string) bool {
+	name = strings.Trim(strings.Replace(name, `\`, "/", -1), "/")
+	if !strings.Contains(c.ExtractMember, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(c.ExtractMember, name)
+	return ok
+}
+
+// memberExtractor writes the single matching member to a temp file
+// and collects the names of all matches to report ambiguous patterns
+type memberExtractor struct {
+	dir     string
+	file    string
+	sha256  string
+	matches []string
+}
+
+func (m *memberExtractor) add(name string, r io.Reader) error {
+	m.matches = append(m.matches, name)
+	if len(m.matches) > 1 {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(m.dir, ".member-")
+	if err != nil {
+		return err
+	}
+	m.file = f.Name()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		f.Close()
+		return err
+	}
+	m.sha256 = fmt.Sprintf("%x", hash.Sum(nil))
+	return f.Close()
+}
+
+func (m *memberExtractor) result(pattern string) (string, string, error) {
+	switch len(m.matches) {
+	case 1:
+		return m.file, m.sha256, nil
+	case 0:
+		return "", "", fmt.Errorf("No archive entry matches %q", pattern)
+	default:
+		os.Remove(m.file)
+		return "", "", fmt.Errorf("%d archive entries match %q: %s", len(m.matches), pattern, strings.Join(m.matches, ", "))
+	}
+}
+
+// extractMember writes the archive member matching extract_member to a
+// temp file next to the archive and returns its path and SHA256
+func (c *configFileSource) extractMember(archive string) (string, string, error) {
+	m := &memberExtractor{dir: filepath.Dir(archive)}
+
+	var err error
+	switch c.Extract {
+	case extractZip:
+		err = c.extractZipMember(archive, m)
+	default:
+		err = c.extractTarMember(archive, c.Extract != extractTar, m)
+	}
+	if err != nil {
+		if m.file != "" {
+			os.Remove(m.file)
+		}
+		return "", "", err
+	}
+
+	return m.result(c.ExtractMember)
+}
+
+func (c *configFileSource) extractTarMember(archive string, gzipped bool, m *memberExtractor) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if (hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA) && c.matchesMember(hdr.Name) {
+			if err := m.add(hdr.Name, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *configFileSource) extractZipMember(archive string, m *memberExtractor) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() || !c.matchesMember(zf.Name) {
+			continue
+		}
+
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = m.add(zf.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package watch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TotalFailure reports whether every entry which is not paused is
+// failing with at least minFailures consecutive failures and for at
+// least minDuration, thresholds of zero are not checked. A single entry
+// which succeeded or was not attempted yet keeps it from reporting. The
+// summary lists the entries with their last error.
+func (w *Watcher) TotalFailure(minFailures int, minDuration time.Duration) (string, bool) {
+	if (minFailures <= 0 && minDuration <= 0) || w.config.IsPaused() || w.config.IsStarting() {
+		return "", false
+	}
+
+	var (
+		now     = time.Now()
+		failing []string
+	)
+	for _, fs := range w.config.Status() {
+		if fs.State == poolStatePaused {
+			continue
+		}
+
+		switch {
+		case fs.ConsecutiveFailures == 0:
+			return "", false
+		case minFailures > 0 && fs.ConsecutiveFailures < minFailures:
+			return "", false
+		case minDuration > 0 && now.Sub(fs.FailingSince) < minDuration:
+			return "", false
+		}
+		failing = append(failing, fmt.Sprintf("'%s' failed %d times since %s: %s",
+			fs.Path, fs.ConsecutiveFailures, fs.FailingSince.Format(time.RFC3339), fs.LastError))
+	}
+
+	if len(failing) == 0 {
+		return "", false
+	}
+	sort.Strings(failing)
+	return fmt.Sprintf("%d files failing: %s", len(failing), strings.Join(failing, "; ")), true
+}
@@ -0,0 +1,97 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+func (c *configFileSource) validateFallbackURLs() error {
+	if len(c.FallbackURLs) == 0 {
+		return nil
+	}
+
+	switch {
+	case len(c.URLs) > 0 || c.Mirror != nil:
+		return errors.New("fallback_urls can't be used with urls or mirror")
+	case c.method() != http.MethodGet:
+		return errors.New("fallback_urls only support GET requests")
+	}
+
+	seen := map[string]bool{c.URL: true}
+	for _, raw := range c.FallbackURLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Fallback URL %q is no http(s) URL", raw)
+		}
+		if seen[raw] {
+			return fmt.Errorf("URL %q is listed twice in url and fallback_urls", raw)
+		}
+		seen[raw] = true
+	}
+	return nil
+}
+
+// fetchCandidates returns the URL of this attempt followed by the
+// fallback_urls in the order they are tried
+func (c *configFileSource) fetchCandidates(fetchURL string) []string {
+	return append([]string{fetchURL}, c.FallbackURLs...)
+}
+
+// validatorsApply reports whether the validators of the last success
+// were issued by the host of the candidate. Without a recorded host they
+// stem from the primary URL.
+func (c *configFileSource) validatorsApply(candidate int, candidateURL string) bool {
+	if c.lastHost == "" {
+		return candidate == 0
+	}
+	return urlHost(candidateURL) == c.lastHost
+}
+
+func urlHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// retryableFailure reports whether the next fallback URL should be tried
+// after the response or error of a request
+func retryableFailure(res *http.Response, err error) bool {
+	return err != nil || res.StatusCode >= 500
+}
+
+// requestFile sends the request for the file to one URL and returns the
+// open response, the validators of prev are sent if conditional is set
+func (c *configFileSource) requestFile(ctx context.Context, client *http.Client, fetchURL, userAgent string,
+	conditional bool, prev PrevState) (*http.Response, error) {
+	req, err := c.newFetchRequest(fetchURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.prepareRequest(req, userAgent); err != nil {
+		return nil, err
+	}
+
+	if conditional && prev.Version != "" {
+		req.Header.Set("If-None-Match", prev.Version)
+	}
+
+	if conditional && prev.Modified != "" {
+		req.Header.Set("If-Modified-Since", prev.Modified)
+	}
+
+	res, err := ctxhttp.Do(ctx, client, req)
+	if err != nil {
+		if c.URLCommand != "" {
+			err = sanitizeMintedURLError(err)
+		}
+		return nil, wrapTransportError(ctx, client, req, err)
+	}
+	return res, nil
+}
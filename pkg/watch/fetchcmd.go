@@ -0,0 +1,247 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// fetchPollInterval is how often Fetch asks the daemon for the status of
+// the triggered fetches while waiting for them
+const fetchPollInterval = 500 * time.Millisecond
+
+// FetchOptions select the entries fetched by Fetch and how the running
+// daemon is reached
+type FetchOptions struct {
+	ConfigPath string
+	StateFile  string
+	// Load are the options the configuration is loaded with when the
+	// entries are fetched in this process
+	Load Options
+
+	// SocketPath or else AdminAddr with AdminToken reach the daemon
+	SocketPath string
+	AdminAddr  string
+	AdminToken string
+
+	Paths []string
+	All   bool
+
+	// Wait blocks until the fetches triggered in the daemon finished,
+	// at most for Timeout if set
+	Wait    bool
+	Timeout time.Duration
+
+	// RunningPID is the PID of a daemon holding the instance lock, the
+	// entries are not fetched in this process while it runs
+	RunningPID int
+}
+
+// daemonCall sends a control request to the running daemon
+type daemonCall func(controlRequest) (controlResponse, error)
+
+// Fetch asks the running daemon to fetch the entries now. Without a
+// reachable daemon the entries are fetched in this process instead,
+// which always waits for the result. The mode used is printed to stderr,
+// one status line per finished fetch to stdout. It returns whether all
+// fetches succeeded.
+func Fetch(ctx context.Context, opts FetchOptions) (bool, error) {
+	if opts.All == (len(opts.Paths) > 0) {
+		return false, errors.New("Give the paths to fetch or --all")
+	}
+
+	if call, via := daemonCaller(opts.SocketPath, opts.AdminAddr, opts.AdminToken); call != nil {
+		res, err := call(controlRequest{Command: "status"})
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Fetching in the running daemon (%s)\n", via)
+			return fetchInDaemon(ctx, call, res.Status, opts)
+		}
+		newLogger(opts.Load.Debug, opts.Load.Quiet).debug("Daemon unreachable via %s: %s", via, err)
+	}
+
+	if opts.RunningPID > 0 {
+		return false, fmt.Errorf("The daemon (PID %d) is running but can't be reached, use --control-socket or --listen-admin", opts.RunningPID)
+	}
+
+	fmt.Fprintln(os.Stderr, "No daemon reachable, fetching in this process")
+	return fetchInProcess(ctx, opts)
+}
+
+// daemonCaller returns the call to the daemon through the control socket
+// or else the admin API and a description of the way used. The call is
+// nil if neither is given.
+func daemonCaller(socketPath, adminAddr, adminToken string) (daemonCall, string) {
+	switch {
+	case socketPath != "":
+		return func(req controlRequest) (controlResponse, error) {
+			return callControlSocket(socketPath, req)
+		}, "control socket " + socketPath
+	case adminAddr != "":
+		return func(req controlRequest) (controlResponse, error) {
+			return callAdmin(adminAddr, adminToken, req)
+		}, "admin API " + adminAddr
+	}
+	return nil, ""
+}
+
+// fetchInDaemon triggers the fetches and, with Wait, polls the status
+// until every triggered entry has a fetch record newer than the last
+// one before the trigger
+func fetchInDaemon(ctx context.Context, call daemonCall, status []FileStatus, opts FetchOptions) (bool, error) {
+	before := map[string]time.Time{}
+	for _, fs := range status {
+		before[fs.Path] = lastRecordTime(fs.History)
+	}
+
+	paths := opts.Paths
+	if opts.All {
+		paths = []string{""}
+	}
+
+	pending := map[string]bool{}
+	for _, p := range paths {
+		res, err := call(controlRequest{Command: "fetch", Path: p})
+		if err != nil {
+			if p == "" {
+				return false, fmt.Errorf("Could not trigger fetch: %s", err)
+			}
+			return false, fmt.Errorf("Could not trigger fetch of '%s': %s", p, err)
+		}
+		for _, t := range res.Paths {
+			pending[t] = true
+		}
+	}
+
+	if !opts.Wait {
+		fmt.Printf("Triggered fetch of %d files\n", len(pending))
+		return true, nil
+	}
+
+	var timeout <-chan time.Time
+	if opts.Timeout > 0 {
+		timeout = time.After(opts.Timeout)
+	}
+	ticker := time.NewTicker(fetchPollInterval)
+	defer ticker.Stop()
+
+	ok := true
+	for len(pending) > 0 {
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			return false, fmt.Errorf("%d fetches did not finish within %s", len(pending), opts.Timeout)
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+
+		res, err := call(controlRequest{Command: "status"})
+		if err != nil {
+			return false, fmt.Errorf("Could not get status from the daemon: %s", err)
+		}
+
+		seen := map[string]bool{}
+		for _, fs := range res.Status {
+			seen[fs.Path] = true
+			if !pending[fs.Path] || !lastRecordTime(fs.History).After(before[fs.Path]) {
+				continue
+			}
+			delete(pending, fs.Path)
+			ok = printFetchRecord(fs.Path, fs.History[len(fs.History)-1]) && ok
+		}
+
+		for p := range pending {
+			if !seen[p] {
+				fmt.Printf("%s: removed from the configuration while fetching\n", p)
+				delete(pending, p)
+				ok = false
+			}
+		}
+	}
+	return ok, nil
+}
+
+// fetchInProcess fetches the entries one after another with the
+// configuration and state file like the daemon would
+func fetchInProcess(ctx context.Context, opts FetchOptions) (bool, error) {
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Load)
+	if err != nil {
+		return false, fmt.Errorf("Could not load config: %s", err)
+	}
+	cfg.StateFile = opts.StateFile
+
+	w, err := New(cfg)
+	if err != nil {
+		return false, err
+	}
+	c := w.config
+	defer func() {
+		c.stop()
+		c.Shutdown()
+	}()
+
+	c.RLock()
+	paths := opts.Paths
+	if opts.All {
+		for p := range c.Files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+	}
+	entries := make([]*configFileSource, len(paths))
+	for i, p := range paths {
+		entries[i] = c.Files[p]
+	}
+	c.RUnlock()
+
+	for i, fc := range entries {
+		if fc == nil {
+			return false, fmt.Errorf("File '%s': %s", paths[i], ErrUnknownFile)
+		}
+	}
+
+	ok := true
+	for i, fc := range entries {
+		before := lastRecordTime(fc.getHistory())
+		err := c.runFetch(ctx, paths[i], fc, false)
+
+		history := fc.getHistory()
+		if !lastRecordTime(history).After(before) {
+			// Aborted before a result was recorded
+			fmt.Printf("%s: failed: %s\n", paths[i], err)
+			ok = false
+			continue
+		}
+		ok = printFetchRecord(paths[i], history[len(history)-1]) && ok
+	}
+	return ok, ctx.Err()
+}
+
+func lastRecordTime(history []FetchRecord) time.Time {
+	if len(history) == 0 {
+		return time.Time{}
+	}
+	return history[len(history)-1].Time
+}
+
+// printFetchRecord prints the status line of the fetch and reports
+// whether it succeeded
+func printFetchRecord(filePath string, rec FetchRecord) bool {
+	if rec.Outcome == outcomeError {
+		fmt.Printf("%s: failed after %s: %s\n", filePath, rec.Duration.Round(time.Millisecond), rec.Error)
+		return false
+	}
+
+	outcome := rec.Outcome
+	switch outcome {
+	case outcomeNotModified:
+		outcome = "unchanged (304)"
+	case outcomeChecked:
+		outcome = "unchanged (HEAD)"
+	}
+	fmt.Printf("%s: %s (%d bytes in %s)\n", filePath, outcome, rec.Bytes, rec.Duration.Round(time.Millisecond))
+	return true
+}
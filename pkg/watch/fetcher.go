@@ -0,0 +1,202 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// ErrMissing is returned (possibly wrapped) by a Fetcher when the source
+// reports the file does not exist, which applies the on_missing action
+var ErrMissing = errors.New("File is missing upstream")
+
+// Source is the file a Fetcher is asked to fetch
+type Source struct {
+	// Path is the target path of the file
+	Path string
+	// URL is the URL of this attempt, its scheme selected the Fetcher
+	URL string
+	// UserAgent identifies the daemon if the protocol supports it
+	UserAgent string
+
+	// attempt carries the settings of the built-in HTTP fetcher
+	attempt *httpAttempt
+}
+
+// PrevState describes the content of the last successful fetch so a
+// Fetcher can skip content which did not change
+type PrevState struct {
+	// Conditional is false when the content has to be fetched regardless
+	// of the values below, e.g. with ignore_etag or after max_staleness
+	Conditional bool
+	// Version identifies the content like an HTTP ETag, it is empty if
+	// unknown
+	Version string
+	// Modified is an opaque modification stamp like the HTTP
+	// Last-Modified header, it is empty if unknown
+	Modified string
+}
+
+// Result describes a finished fetch
+type Result struct {
+	// NotModified reports the content did not change since PrevState,
+	// nothing was written to the destination
+	NotModified bool
+	// Version and Modified are passed as PrevState to the next fetch
+	Version  string
+	Modified string
+	// Size is the announced size of the content or -1 if unknown
+	Size int64
+	// FinalURL is the URL the content was served from, e.g. after
+	// redirects
+	FinalURL string
+
+	host       string
+	statusCode int
+	// version is the value of the version_header of the response
+	version string
+}
+
+// Fetcher fetches the content of a Source into dest. Content written to
+// dest is only installed if Fetch returns without error. A panic of Fetch
+// is recovered and counted as failed fetch.
+type Fetcher interface {
+	Fetch(ctx context.Context, src Source, dest io.Writer, prev PrevState) (Result, error)
+}
+
+// fetcherRegistry maps the URL schemes to their Fetcher
+type fetcherRegistry struct {
+	mu       sync.RWMutex
+	fetchers map[string]Fetcher
+}
+
+func newFetcherRegistry() *fetcherRegistry {
+	return &fetcherRegistry{fetchers: map[string]Fetcher{
+		"http":  httpFetcher{},
+		"https": httpFetcher{},
+	}}
+}
+
+func (r *fetcherRegistry) register(scheme string, f Fetcher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fetchers[strings.ToLower(scheme)] = f
+}
+
+func (r *fetcherRegistry) fetcherFor(rawURL string) (Fetcher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.fetchers[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("No fetcher registered for scheme %q", u.Scheme)
+	}
+	return f, nil
+}
+
+// downloadDest is the destination of a fetch: a temp file next to the
+// target created on the first write, or nothing for watch_only entries.
+// The content is hashed while it is written.
+type downloadDest struct {
+	targetPath string
+	dirMode    os.FileMode
+	discard    bool
+
+	file    *os.File
+	hash    hash.Hash
+	written int64
+}
+
+func newDownloadDest(targetPath string, dirMode os.FileMode, discard bool) *downloadDest {
+	return &downloadDest{targetPath: targetPath, dirMode: dirMode, discard: discard, hash: sha256.New()}
+}
+
+func (d *downloadDest) open() error {
+	if d.file != nil || d.discard {
+		return nil
+	}
+
+	if err := mkdirAll(path.Dir(d.targetPath), d.dirMode); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(path.Dir(d.targetPath), path.Base(d.targetPath))
+	if err != nil {
+		return err
+	}
+	d.file = f
+	return nil
+}
+
+func (d *downloadDest) Write(p []byte) (int, error) {
+	if err := d.open(); err != nil {
+		return 0, err
+	}
+
+	if !d.discard {
+		if n, err := d.file.Write(p); err != nil {
+			d.hash.Write(p[:n])
+			d.written += int64(n)
+			return n, err
+		}
+	}
+	d.hash.Write(p)
+	d.written += int64(len(p))
+	return len(p), nil
+}
+
+// preallocate reserves the disk space for the announced size
+func (d *downloadDest) preallocate(size int64) error {
+	if d.discard {
+		return nil
+	}
+	if err := d.open(); err != nil {
+		return err
+	}
+	return preallocateFile(d.file, size)
+}
+
+// truncate drops the preallocated space which was not filled
+func (d *downloadDest) truncate() error {
+	if d.file == nil {
+		return nil
+	}
+	return d.file.Truncate(d.written)
+}
+
+func (d *downloadDest) sum() string {
+	return fmt.Sprintf("%x", d.hash.Sum(nil))
+}
+
+// close closes the temp file, creating it for empty content
+func (d *downloadDest) close() error {
+	if err := d.open(); err != nil || d.file == nil {
+		return err
+	}
+	return d.file.Close()
+}
+
+// remove drops the temp file, it was renamed already if installed
+func (d *downloadDest) remove() {
+	if d.file == nil {
+		return
+	}
+	d.file.Close()
+	os.Remove(d.file.Name())
+}
@@ -0,0 +1,79 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const defaultDirMode os.FileMode = 0755
+
+// fileMode is a permission given as octal number like "0644", zero
+// means it is not set
+type fileMode os.FileMode
+
+func (m *fileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	v, err := strconv.ParseUint(raw, 8, 32)
+	if err != nil || v == 0 || v > 0777 {
+		return fmt.Errorf("Invalid mode %q, use an octal permission like 0644", raw)
+	}
+
+	*m = fileMode(v)
+	return nil
+}
+
+func (c *configFileSource) validateFileModes() error {
+	if c.DirMode != 0 && c.DirMode&0700 != 0700 {
+		return fmt.Errorf("dir_mode %04o needs to give the owner full access", c.DirMode)
+	}
+	if c.FileMode != 0 && c.FileMode&0600 != 0600 {
+		return fmt.Errorf("file_mode %04o needs to allow the owner to read and write", c.FileMode)
+	}
+	return nil
+}
+
+// dirMode returns the permission of the directories created for the
+// target
+func (c *configFileSource) dirMode() os.FileMode {
+	if c.DirMode == 0 {
+		return defaultDirMode
+	}
+	return os.FileMode(c.DirMode)
+}
+
+// applyFileMode sets the file_mode on the verified file right before it
+// is moved to the target, until then it keeps the 0600 of its creation
+func (c *configFileSource) applyFileMode(p string) error {
+	if c.FileMode == 0 {
+		return nil
+	}
+	return os.Chmod(p, os.FileMode(c.FileMode))
+}
+
+// mkdirAll creates the directory and its missing parents with the mode,
+// unlike os.MkdirAll independent of the umask
+func mkdirAll(dir string, mode os.FileMode) error {
+	var missing []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	for _, d := range missing {
+		if err := os.Chmod(d, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package watch
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// checkEnabled reports whether the entry is checked with a HEAD request
+// between its full fetches
+func (c *configFileSource) checkEnabled() bool {
+	return c.CheckInterval > 0 && c.CheckInterval < c.FetchInterval && !c.snapshot().headUnsupported
+}
+
+// wantsHeadCheck reports whether the next run only needs the HEAD
+// pre-check as the fetch_interval did not elapse yet
+func (c *configFileSource) wantsHeadCheck(targetPath string) bool {
+	return c.checkEnabled() && !c.isTriggered() && !c.isBootstrapping(targetPath) &&
+		time.Now().Before(c.snapshot().lastCall.Add(c.FetchInterval))
+}
+
+// nextCheck returns when the next HEAD pre-check is due
+func (c *configFileSource) nextCheck() time.Time {
+	run := c.snapshot()
+	last := run.lastCall
+	if run.lastCheck.After(last) {
+		last = run.lastCheck
+	}
+	return last.Add(c.CheckInterval)
+}
+
+// headChanged sends a HEAD request and compares its validators with the
+// ones of the last full download. Servers without HEAD support or
+// without any validators disable the pre-check for the entry.
+func (c *configFileSource) headChanged(ctx context.Context, client *http.Client, targetPath, fetchURL, userAgent string) (bool, error) {
+	req, err := http.NewRequest("HEAD", fetchURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := c.prepareRequest(req, userAgent); err != nil {
+		return false, err
+	}
+
+	res, err := ctxhttp.Do(ctx, client, req)
+	if err != nil {
+		if c.URLCommand != "" {
+			err = sanitizeMintedURLError(err)
+		}
+		return false, wrapTransportError(ctx, client, req, err)
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented:
+		c.logf(levelInfo, "Upstream of '%s' does not support HEAD (status %d), disabling check_interval", targetPath, res.StatusCode)
+		c.runMu.Lock()
+		c.headUnsupported = true
+		c.runMu.Unlock()
+		return true, nil
+	case res.StatusCode != http.StatusOK:
+		// Let the full fetch handle the status
+		return true, nil
+	}
+
+	var compared bool
+	if etag := res.Header.Get("ETag"); etag != "" && c.lastSeenETag != "" {
+		if etag != c.lastSeenETag {
+			return true, nil
+		}
+		compared = true
+	}
+	if lm := res.Header.Get("Last-Modified"); lm != "" && c.lastModified != "" {
+		if lm != c.lastModified {
+			return true, nil
+		}
+		compared = true
+	}
+	if res.ContentLength >= 0 && c.lastLength > 0 {
+		if res.ContentLength != c.lastLength {
+			return true, nil
+		}
+		compared = true
+	}
+
+	if !compared {
+		c.logf(levelInfo, "Upstream of '%s' sends no ETag, Last-Modified or Content-Length, disabling check_interval", targetPath)
+		c.runMu.Lock()
+		c.headUnsupported = true
+		c.runMu.Unlock()
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,74 @@
+package watch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestHeadCheckWithoutChangeSkipsPingAndMarker(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = make(map[string]int)
+	)
+	count := func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[key]
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	target, marker := filepath.Join(dir, "file"), filepath.Join(dir, "marker")
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s/file\n    fetch_interval: 1h\n    check_interval: 1m\n    ping_url: %s/ping\n    success_marker: %s\n",
+		target, srv.URL, srv.URL, marker))
+	fc := w.lookup(target)
+
+	if err := w.config.runFetch(context.Background(), target, fc, false); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); count("GET /ping") == 0 && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := os.Remove(marker); err != nil {
+		t.Fatalf("success marker of the download: %s", err)
+	}
+
+	if err := w.config.runFetch(context.Background(), target, fc, true); err != nil {
+		t.Fatal(err)
+	}
+	// The ping would be sent in the background
+	time.Sleep(200 * time.Millisecond)
+
+	if n := count("HEAD /file"); n != 1 {
+		t.Errorf("%d HEAD requests, want 1", n)
+	}
+	if n := count("GET /file"); n != 1 {
+		t.Errorf("%d downloads, want only the first one", n)
+	}
+	if n := count("GET /ping"); n != 1 {
+		t.Errorf("%d pings, want only the one of the download", n)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("HEAD pre-check touched the success marker: %v", err)
+	}
+
+	history := fc.getHistory()
+	if len(history) != 2 || history[1].Outcome != outcomeChecked {
+		t.Errorf("history is %+v, want the download and a %s record", history, outcomeChecked)
+	}
+}
@@ -0,0 +1,72 @@
+package watch
+
+import (
+	"os"
+	"time"
+)
+
+// staleAt returns when the entry exceeds its max_age. The reference is
+// the last successful fetch or the mtime of the file if it was not yet
+// fetched by this process. The second return value is false if max_age
+// is not configured.
+func (c *configFileSource) staleAt(targetPath string) (time.Time, bool) {
+	if c.MaxAge <= 0 {
+		return time.Time{}, false
+	}
+
+	ref := c.snapshot().lastCall
+	if ref.IsZero() {
+		if stat, err := os.Stat(targetPath); err == nil {
+			ref = stat.ModTime()
+		}
+	}
+
+	if ref.IsZero() {
+		// Neither fetched nor present, nothing fresh to serve
+		return time.Time{}, true
+	}
+
+	return ref.Add(c.MaxAge), true
+}
+
+// isStale reports whether the entry exceeds its max_age
+func (c *configFileSource) isStale(targetPath string) bool {
+	at, ok := c.staleAt(targetPath)
+	return ok && !at.After(time.Now())
+}
+
+// checkMaxAge logs a warning once when the entry exceeds its max_age
+// and re-arms the warning when it got fresh again
+func (c *configFileSource) checkMaxAge(targetPath string) {
+	if c.MaxAge <= 0 {
+		return
+	}
+
+	stale := c.isStale(targetPath)
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	switch {
+	case stale && !c.maxAgeWarned:
+		c.logf(levelWarn, "WARNING: File '%s' is older than its max_age of %s", targetPath, c.MaxAge)
+		c.maxAgeWarned = true
+	case !stale && c.maxAgeWarned:
+		c.logf(levelInfo, "File '%s' is within its max_age again", targetPath)
+		c.maxAgeWarned = false
+	}
+}
+
+// nextMaxAgeCheck returns when the entry needs to be checked for its
+// max_age and false if there is no pending check
+func (c *configFileSource) nextMaxAgeCheck(targetPath string) (time.Time, bool) {
+	c.stateMu.Lock()
+	warned := c.maxAgeWarned
+	c.stateMu.Unlock()
+
+	if warned {
+		return time.Time{}, false
+	}
+
+	return c.staleAt(targetPath)
+}
@@ -0,0 +1,152 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStale is the allowed age of the last successful fetch, either
+// as multiple of the fetch_interval ("2x") or as fixed duration
+type maxStale struct {
+	factor   float64
+	duration time.Duration
+}
+
+func parseMaxStale(s string) (maxStale, error) {
+	if strings.HasSuffix(s, "x") {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+		if err != nil || f <= 0 {
+			return maxStale{}, fmt.Errorf("Invalid max-stale %q", s)
+		}
+		return maxStale{factor: f}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return maxStale{}, fmt.Errorf("Invalid max-stale %q, use a multiple of the fetch_interval (2x) or a duration (1h)", s)
+	}
+	return maxStale{duration: d}, nil
+}
+
+func (m maxStale) limit(fetchInterval time.Duration) time.Duration {
+	if m.duration > 0 {
+		return m.duration
+	}
+	return time.Duration(m.factor * float64(fetchInterval))
+}
+
+// Healthcheck checks all entries are fresh using the status of the
+// running daemon or, when it can't be reached, the files on disk. It
+// prints a one-line summary and returns whether everything is healthy.
+// Paused files are not checked but listed as PAUSED.
+func Healthcheck(configPath string, opts Options, socketPath, staleSpec string) bool {
+	stale, err := parseMaxStale(staleSpec)
+	if err != nil {
+		fmt.Println("UNHEALTHY: " + err.Error())
+		return false
+	}
+
+	config, err := loadConfigFile(configPath, opts)
+	if err != nil {
+		fmt.Printf("UNHEALTHY: Could not load config: %s\n", err)
+		return false
+	}
+
+	var (
+		problems []string
+		paused   []string
+		checked  int
+		mode     = "daemon"
+	)
+
+	var res controlResponse
+	err = errors.New("No --control-socket given")
+	if socketPath != "" {
+		res, err = callControlSocket(socketPath, controlRequest{Command: "status"})
+	}
+
+	if err == nil && res.Starting {
+		fmt.Printf("STARTING: first fetches of %d files held off (%s)\n", len(res.Status), mode)
+		return true
+	}
+
+	if err == nil && res.Paused {
+		fmt.Printf("PAUSED: scheduling of all %d files is paused, nothing checked (%s)\n", len(res.Status), mode)
+		return true
+	}
+
+	if err == nil {
+		for _, fs := range res.Status {
+			if fs.State == poolStatePaused {
+				paused = append(paused, fs.Path)
+				continue
+			}
+			checked++
+
+			if p := fs.problem(config.Files[fs.Path], stale); p != "" {
+				problems = append(problems, p)
+			}
+		}
+	} else {
+		mode = fmt.Sprintf("files on disk, daemon unreachable: %s", err)
+		for filePath, fc := range config.Files {
+			checked++
+			if p := config.checkFileOnDisk(filePath, fc); p != "" {
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	sort.Strings(problems)
+	sort.Strings(paused)
+	var pausedSummary string
+	if len(paused) > 0 {
+		pausedSummary = fmt.Sprintf(", PAUSED: %d files not checked: %s", len(paused), strings.Join(paused, ", "))
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("UNHEALTHY: %d of %d files not fresh (%s): %s%s\n", len(problems), checked, mode, strings.Join(problems, ", "), pausedSummary)
+		return false
+	}
+
+	fmt.Printf("OK: %d files fresh (%s)%s\n", checked, mode, pausedSummary)
+	return true
+}
+
+// problem describes why the entry is not fresh or returns an empty
+// string if it is
+func (fs FileStatus) problem(fc *configFileSource, stale maxStale) string {
+	switch {
+	case fs.LastSuccess.IsZero():
+		return fmt.Sprintf("%s never fetched", fs.Path)
+	case !fs.Healthy:
+		return fmt.Sprintf("%s exceeds max_age", fs.Path)
+	case fc != nil && time.Since(fs.LastSuccess) > stale.limit(fc.FetchInterval):
+		return fmt.Sprintf("%s last fetched %s ago", fs.Path, time.Since(fs.LastSuccess).Round(time.Second))
+	}
+	return ""
+}
+
+// checkFileOnDisk verifies the file exists and matches the configured
+// checksum
+func (c *configFile) checkFileOnDisk(filePath string, fc *configFileSource) string {
+	if fc.WatchOnly {
+		return ""
+	}
+
+	if _, err := os.Stat(filePath); err != nil {
+		return fmt.Sprintf("%s missing", filePath)
+	}
+
+	if fc.SHA256 != "" && fc.checksumOfInstalled() {
+		if sum, ok := c.checksums.Sum(filePath); !ok || !strings.EqualFold(sum, fc.SHA256) {
+			return fmt.Sprintf("%s has wrong sha256", filePath)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// captureStdout returns what fn printed to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestHealthcheckReportsPaused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	active, paused := filepath.Join(dir, "active"), filepath.Join(dir, "paused")
+	configPath := filepath.Join(dir, "config.yaml")
+	raw := fmt.Sprintf("files:\n  %s:\n    url: %s\n    fetch_interval: 1h\n  %s:\n    url: %s\n    fetch_interval: 1h\n", active, srv.URL, paused, srv.URL)
+	if err := ioutil.WriteFile(configPath, []byte(raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newTestWatcher(t, raw)
+	for _, target := range []string{active, paused} {
+		if err := w.config.runFetch(context.Background(), target, w.lookup(target), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	socketPath := filepath.Join(dir, "control.sock")
+	ctl, err := w.ListenControlSocket(socketPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctl.Close()
+
+	if err := w.SetPaused(paused, true); err != nil {
+		t.Fatal(err)
+	}
+	var healthy bool
+	out := captureStdout(t, func() { healthy = Healthcheck(configPath, Options{}, socketPath, "2x") })
+	if want := fmt.Sprintf("OK: 1 files fresh (daemon), PAUSED: 1 files not checked: %s\n", paused); !healthy || out != want {
+		t.Errorf("got %v %q, want healthy %q", healthy, out, want)
+	}
+
+	if err := w.SetPaused("", true); err != nil {
+		t.Fatal(err)
+	}
+	out = captureStdout(t, func() { healthy = Healthcheck(configPath, Options{}, socketPath, "2x") })
+	if !healthy || !strings.HasPrefix(out, "PAUSED: scheduling of all 2 files is paused") {
+		t.Errorf("got %v %q for the paused daemon", healthy, out)
+	}
+}
@@ -0,0 +1,65 @@
+package watch
+
+import (
+	"time"
+)
+
+const (
+	defaultHistorySize = 20
+
+	outcomeChanged     = "changed"
+	outcomeUnchanged   = "unchanged"
+	outcomeNotModified = "304"
+	outcomeError       = "error"
+	outcomeDeleted     = "deleted"
+	outcomeEmptied     = "emptied"
+	// outcomeRejectedOlder is a download refused by reject_older
+	outcomeRejectedOlder = "rejected_older"
+	// outcomeChecked is a HEAD pre-check of check_interval which showed
+	// no change, nothing was downloaded
+	outcomeChecked = "head_unchanged"
+)
+
+// FetchRecord describes one fetch attempt of an entry
+type FetchRecord struct {
+	Time       time.Time     `json:"time"`
+	Outcome    string        `json:"outcome"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Host       string        `json:"host,omitempty"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"duration"`
+	// BytesPerSecond is the effective rate of the transfer of a download,
+	// including max_rate and max_total_rate
+	BytesPerSecond int64  `json:"bytes_per_second,omitempty"`
+	Error          string `json:"error,omitempty"`
+
+	// streamed is set once the record was written to the event stream
+	streamed bool
+	// diff is the log_diff of the change for the event stream
+	diff string
+	// fileEvent is the kind of change of the target for the event stream
+	fileEvent string
+}
+
+// addHistory appends the record to the history of the entry keeping at
+// most size records
+func (c *configFileSource) addHistory(rec FetchRecord, size int) {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.history = append(c.history, rec)
+	if len(c.history) > size {
+		c.history = append([]FetchRecord(nil), c.history[len(c.history)-size:]...)
+	}
+}
+
+func (c *configFileSource) getHistory() []FetchRecord {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return append([]FetchRecord(nil), c.history...)
+}
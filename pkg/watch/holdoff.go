@@ -0,0 +1,81 @@
+package watch
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const dnsProbeInterval = 2 * time.Second
+
+// HoldOff delays the first fetches after the start: it waits for the
+// delay and then until dnsHost resolves, but at most dnsTimeout. The
+// listeners keep serving meanwhile and the status reports the daemon as
+// starting. Triggered fetches run once Run is called. Only the error of
+// ctx is returned, a name which does not resolve in time is logged.
+func (w *Watcher) HoldOff(ctx context.Context, delay time.Duration, dnsHost string, dnsTimeout time.Duration) error {
+	if delay <= 0 && dnsHost == "" {
+		return nil
+	}
+
+	w.config.setStarting(true)
+	defer w.config.setStarting(false)
+
+	if delay > 0 {
+		w.config.log.logf(levelInfo, "Holding off the first fetches for %s", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if dnsHost != "" {
+		return waitForDNS(ctx, w.config.log, dnsHost, dnsTimeout)
+	}
+	return nil
+}
+
+// waitForDNS probes the name until it resolves or the timeout passed
+func waitForDNS(ctx context.Context, l *logger, host string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, dnsProbeInterval)
+		_, err := net.DefaultResolver.LookupHost(probeCtx, host)
+		cancel()
+		if err == nil {
+			l.debug("%s resolves, starting the fetches", host)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			l.logf(levelWarn, "WARNING: %s did not resolve within %s, starting the fetches anyway: %s", host, timeout, err)
+			return nil
+		}
+		l.debug("Waiting for %s to resolve: %s", host, err)
+
+		select {
+		case <-time.After(dnsProbeInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *configFile) setStarting(starting bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.starting = starting
+}
+
+// IsStarting reports whether the first fetches are still held off
+func (c *configFile) IsStarting() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.starting
+}
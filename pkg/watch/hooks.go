@@ -0,0 +1,174 @@
+package watch
+
+import (
+	"sync"
+	"time"
+)
+
+const hookQueueSize = 100
+
+// ChangeEvent is passed to the OnChange callbacks after a file was
+// written successfully. It carries the same information the environment
+// of the success_command does.
+type ChangeEvent struct {
+	// Path is the target path of the file (DW_PATH)
+	Path string
+	// URL is the configured url of the file, for url_command entries the
+	// redacted URL printed by the command (DW_URL)
+	URL string
+	// FinalURL is the URL the file was served from after redirects
+	// (DW_FINAL_URL)
+	FinalURL string
+	// Host is the host of url or the fallback_urls which served the file
+	// (DW_HOST)
+	Host string
+	// SHA256 is the checksum of the new content (DW_SHA256), it is empty
+	// when on_missing removed the file
+	SHA256 string
+	// Event is created, updated or deleted (on_missing) depending on
+	// whether the file existed before (DW_EVENT), empty for watch_only
+	Event string
+	// Time is when the file was written
+	Time time.Time
+}
+
+// ErrorEvent is passed to the OnError callbacks after a failed fetch
+// attempt
+type ErrorEvent struct {
+	// Path is the target path of the file
+	Path string
+	// URL is the configured url of the file, for url_command entries the
+	// redacted URL printed by the command
+	URL string
+	// Err is the reason of the failure
+	Err error
+	// ConsecutiveFailures counts the failed attempts since the last
+	// success including this one
+	ConsecutiveFailures int
+	// Time is when the attempt failed
+	Time time.Time
+}
+
+// hookQueue delivers the events to the registered callbacks on its own
+// goroutine, one after another in the order they were queued. The
+// goroutine is started with the first event and ends with stop.
+type hookQueue struct {
+	mu       sync.Mutex
+	onChange []func(ChangeEvent)
+	onError  []func(ErrorEvent)
+	queue    chan func()
+	log      *logger
+}
+
+func newHookQueue(l *logger) *hookQueue {
+	return &hookQueue{log: l}
+}
+
+func deliverHooks(queue chan func()) {
+	for deliver := range queue {
+		deliver()
+	}
+}
+
+// stop ends the goroutine once the queued events are delivered, a later
+// event starts a new one
+func (h *hookQueue) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.queue != nil {
+		close(h.queue)
+		h.queue = nil
+	}
+}
+
+func (h *hookQueue) addChange(fn func(ChangeEvent)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onChange = append(h.onChange, fn)
+}
+
+func (h *hookQueue) addError(fn func(ErrorEvent)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onError = append(h.onError, fn)
+}
+
+func (h *hookQueue) changed(ev ChangeEvent) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	callbacks := h.onChange
+	h.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn := fn
+		h.enqueue(ev.Path, "change", func() { fn(ev) })
+	}
+}
+
+func (h *hookQueue) failed(ev ErrorEvent) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	callbacks := h.onError
+	h.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn := fn
+		h.enqueue(ev.Path, "error", func() { fn(ev) })
+	}
+}
+
+// enqueue drops the event if the callbacks can't keep up instead of
+// blocking the fetch, a panic of the callback is logged
+func (h *hookQueue) enqueue(filePath, kind string, fn func()) {
+	deliver := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				h.log.logf(levelWarn, "Recovered from panic in %s callback for '%s': %v", kind, filePath, r)
+			}
+		}()
+		fn()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.queue == nil {
+		h.queue = make(chan func(), hookQueueSize)
+		go deliverHooks(h.queue)
+	}
+
+	select {
+	case h.queue <- deliver:
+	default:
+		h.log.logf(levelWarn, "Callback queue is full, dropping %s event for '%s'", kind, filePath)
+	}
+}
+
+// announceSuccess hands the written file to the callbacks and runs the
+// success_command in the background
+func (c *configFile) announceSuccess(targetPath string, targetConfig *configFileSource, result downloadResult) {
+	c.hooks.changed(ChangeEvent{
+		Path:     targetPath,
+		URL:      targetConfig.sourceURL(),
+		FinalURL: result.FinalURL,
+		Host:     result.Host,
+		SHA256:   result.SHA256,
+		Event:    result.Event,
+		Time:     time.Now(),
+	})
+
+	go func() {
+		if err := c.executeSuccessCommand(targetPath, targetConfig, result); err != nil {
+			targetConfig.logf(levelWarn, "Could not execute success-command for '%s': %s", targetPath, err)
+		}
+	}()
+}
@@ -0,0 +1,157 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// httpAttempt are the settings of the entry the built-in fetcher needs
+// besides the public fields of the Source
+type httpAttempt struct {
+	entry     *configFileSource
+	client    *http.Client
+	totalRate *rateLimiter
+	timeout   time.Duration
+}
+
+// statusError is returned for error status codes, 404 and 410 are
+// reported as ErrMissing
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("Got error status code %d", e.code)
+}
+
+func (e statusError) Is(target error) bool {
+	return target == ErrMissing && (e.code == http.StatusNotFound || e.code == http.StatusGone)
+}
+
+// httpFetcher is the built-in Fetcher for http and https URLs
+type httpFetcher struct{}
+
+func (httpFetcher) Fetch(ctx context.Context, src Source, dest io.Writer, prev PrevState) (Result, error) {
+	a := src.attempt
+	if a == nil {
+		return Result{}, errors.New("Source was not prepared for the HTTP fetcher")
+	}
+	fc := a.entry
+
+	ctx, cancel := context.WithCancel(ctx)
+	// A fallback after a timeout gets a fresh context
+	defer func() { cancel() }()
+
+	if !fc.conditionalRequests() {
+		prev.Conditional = false
+	}
+
+	// Validators are host-specific and only sent to the host which
+	// issued them
+	var (
+		res        *http.Response
+		err        error
+		fetchURL   = src.URL
+		candidates = fc.fetchCandidates(fetchURL)
+		served     int
+	)
+	for served = range candidates {
+		res, err = fc.requestFile(ctx, a.client, candidates[served], src.UserAgent,
+			prev.Conditional && fc.validatorsApply(served, candidates[served]), prev)
+		if served == len(candidates)-1 || !retryableFailure(res, err) {
+			break
+		}
+
+		if err == nil {
+			res.Body.Close()
+			err = statusError{res.StatusCode}
+		}
+		fc.logf(levelInfo, "Request for '%s' failed, falling back to %s: %s", src.Path,
+			sanitizeURL(candidates[served+1]), fc.sanitizeError(err))
+
+		if ctx.Err() != nil {
+			cancel()
+			ctx, cancel = context.WithTimeout(fc.fetchContext(), a.timeout)
+		}
+	}
+	if err == nil && served == 0 && res.StatusCode == http.StatusNotFound && fc.DateFallbackDays > 0 {
+		res, fetchURL, err = fc.walkBackDates(ctx, a.client, src.Path, src.UserAgent, prev, res, fetchURL)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if fc.dateTemplated() {
+		fc.setResolvedURL(src.Path, fetchURL)
+	}
+	requested := fetchURL
+	if served > 0 {
+		requested = candidates[served]
+	}
+	result := Result{Size: -1, host: urlHost(requested)}
+	if served > 0 {
+		fc.logf(levelInfo, "Fetching '%s' from fallback host %s", src.Path, result.host)
+	}
+
+	if res.StatusCode == http.StatusAccepted && fc.AsyncPoll != nil {
+		if res, err = fc.pollAsync(ctx, a.client, res, src.UserAgent); err != nil {
+			return result, err
+		}
+	}
+	defer res.Body.Close()
+
+	result.statusCode = res.StatusCode
+	result.FinalURL = res.Request.URL.String()
+	if fc.URLCommand != "" {
+		result.FinalURL = sanitizeMintedURL(result.FinalURL)
+	} else if result.FinalURL != requested {
+		fc.log.debug("Request for '%s' was redirected to %s", src.Path, sanitizeURL(result.FinalURL))
+	}
+
+	switch {
+	case res.StatusCode >= 400:
+		return result, statusError{res.StatusCode}
+	case res.StatusCode == 304:
+		result.NotModified = true
+		result.Version, result.Modified = prev.Version, prev.Modified
+		return result, nil
+	case res.StatusCode == 200:
+		// Exclude from default, handle later
+	default:
+		return result, fmt.Errorf("Got unexpected status code %d", res.StatusCode)
+	}
+
+	checkLength := fc.lengthCheckable(res)
+	d, sized := dest.(*downloadDest)
+	if checkLength && sized {
+		if err := d.preallocate(res.ContentLength); err != nil {
+			return result, fmt.Errorf("Could not allocate %s for download: %s", byteSize(res.ContentLength), err)
+		}
+	}
+
+	n, err := fc.copyBody(ctx, cancel, a.timeout, src.Path, a.totalRate, res, dest)
+	if err != nil {
+		return result, err
+	}
+
+	if checkLength && n != res.ContentLength {
+		if sized {
+			if err := d.truncate(); err != nil {
+				return result, err
+			}
+		}
+		return result, fmt.Errorf("Download truncated: got %d of %d bytes", n, res.ContentLength)
+	}
+
+	result.Version = res.Header.Get("ETag")
+	result.Modified = res.Header.Get("Last-Modified")
+	if fc.VersionHeader != "" {
+		result.version = res.Header.Get(fc.VersionHeader)
+	}
+	result.Size = res.ContentLength
+	return result, nil
+}
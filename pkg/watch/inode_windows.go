@@ -0,0 +1,8 @@
+package watch
+
+import "os"
+
+// fileInode is not available on Windows, size and mtime are used alone
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,139 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseJSONPath splits a path like "$.data.items[0]['key.with.dots']"
+// into object keys (string) and array indexes (int)
+func parseJSONPath(p string) ([]interface{}, error) {
+	var segs []interface{}
+
+	rest := strings.TrimPrefix(p, "$")
+	for len(rest) > 0 {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("Invalid json_path %q: missing ]", p)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				segs = append(segs, inner[1:len(inner)-1])
+				continue
+			}
+
+			n, err := strconv.Atoi(inner)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid json_path %q: bad index %q", p, inner)
+			}
+			segs = append(segs, n)
+
+		case rest[0] == '.' || len(segs) == 0:
+			rest = strings.TrimPrefix(rest, ".")
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("Invalid json_path %q: empty key", p)
+			}
+			segs = append(segs, rest[:end])
+			rest = rest[end:]
+
+		default:
+			return nil, fmt.Errorf("Invalid json_path %q: unexpected %q", p, rest)
+		}
+	}
+
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("Invalid json_path %q: empty path", p)
+	}
+	return segs, nil
+}
+
+// lookupJSONPath returns the value addressed by the path
+func lookupJSONPath(v interface{}, segs []interface{}) (interface{}, error) {
+	for _, seg := range segs {
+		switch s := seg.(type) {
+		case string:
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Can't look up key %q in a non-object", s)
+			}
+			if v, ok = obj[s]; !ok {
+				return nil, fmt.Errorf("Key %q not found", s)
+			}
+		case int:
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Can't look up index %d in a non-array", s)
+			}
+			if s >= len(arr) {
+				return nil, fmt.Errorf("Index %d out of range (%d elements)", s, len(arr))
+			}
+			v = arr[s]
+		}
+	}
+	return v, nil
+}
+
+// extractJSONPath writes the value addressed by json_path to a temp
+// file next to src and returns its path and SHA256. Strings are written
+// verbatim, other values as JSON.
+func (c *configFileSource) extractJSONPath(src string) (string, string, error) {
+	segs, err := parseJSONPath(c.JSONPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	var doc interface{}
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return "", "", fmt.Errorf("Response is no valid JSON: %s", err)
+	}
+
+	v, err := lookupJSONPath(doc, segs)
+	if err != nil {
+		return "", "", err
+	}
+
+	var content []byte
+	if s, ok := v.(string); ok {
+		content = []byte(s)
+	} else if content, err = json.Marshal(v); err != nil {
+		return "", "", err
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(src), ".json-path-")
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = out.Write(content); err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", "", err
+	}
+
+	return out.Name(), fmt.Sprintf("%x", sha256.Sum256(content)), nil
+}
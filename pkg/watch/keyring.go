@@ -0,0 +1,100 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	keyringPrefix  = "keyring:"
+	keyringTimeout = 10 * time.Second
+)
+
+var (
+	// errNoKeyring is wrapped by the errors of lookups on systems
+	// without a usable keyring, e.g. headless ones without a daemon
+	errNoKeyring = errors.New("No keyring available")
+	// errKeyringMissing is wrapped when the keyring has no such entry
+	errKeyringMissing = errors.New("Keyring entry not found")
+)
+
+// keyringRef splits "keyring:<service>[/<user>]" into its parts, ok is
+// false if the value is no keyring reference
+func keyringRef(value string) (service, user string, ok bool) {
+	if !strings.HasPrefix(value, keyringPrefix) {
+		return "", "", false
+	}
+	ref := strings.TrimPrefix(value, keyringPrefix)
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		return ref[:i], ref[i+1:], true
+	}
+	return ref, "", true
+}
+
+func (c *configFileSource) validateCredentials() error {
+	if c.BasicAuth != "" && c.BearerToken != "" {
+		return errors.New("basic_auth and bearer_token can't be combined")
+	}
+
+	if service, user, ok := keyringRef(c.BasicAuth); ok {
+		if service == "" || user == "" {
+			return errors.New("basic_auth needs the format keyring:<service>/<user>")
+		}
+	} else if c.BasicAuth != "" && !strings.Contains(c.BasicAuth, ":") {
+		return errors.New("Invalid auth configuration, needs format user:pass")
+	}
+
+	if service, _, ok := keyringRef(c.BearerToken); ok && service == "" {
+		return errors.New("bearer_token needs the format keyring:<service>")
+	}
+	return nil
+}
+
+// keyringSecret looks up the secret in the keyring of the platform. The
+// lookup happens for every request so changes in the keyring apply
+// without a reload. The secret is masked in the messages of the logger.
+func keyringSecret(l *logger, service, user string) (string, error) {
+	secret, err := lookupKeyring(service, user)
+	if err != nil {
+		name := service
+		if user != "" {
+			name += "/" + user
+		}
+		return "", fmt.Errorf("Keyring lookup of %s failed: %w", name, err)
+	}
+	l.addSecret(secret)
+	return secret, nil
+}
+
+// setCredentials sets the Authorization header from basic_auth or
+// bearer_token, resolving keyring references
+func (c *configFileSource) setCredentials(req *http.Request) error {
+	if service, user, ok := keyringRef(c.BasicAuth); ok {
+		pass, err := keyringSecret(c.log, service, user)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(user, pass)
+	} else if c.BasicAuth != "" {
+		ba := strings.SplitN(c.BasicAuth, ":", 2)
+		if len(ba) != 2 {
+			return errors.New("Invalid auth configuration, needs format user:pass")
+		}
+		req.SetBasicAuth(ba[0], ba[1])
+	}
+
+	token := c.BearerToken
+	if service, user, ok := keyringRef(token); ok {
+		var err error
+		if token, err = keyringSecret(c.log, service, user); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+//go:build darwin
+// +build darwin
+
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// errSecItemNotFound is the exit code of security for a missing item
+const errSecItemNotFound = 44
+
+// lookupKeyring queries the login Keychain for a generic password using
+// the security tool
+func lookupKeyring(service, user string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+	defer cancel()
+
+	args := []string{"find-generic-password", "-s", service, "-w"}
+	if user != "" {
+		args = append(args, "-a", user)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "security", args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == errSecItemNotFound {
+			return "", errKeyringMissing
+		}
+		return "", fmt.Errorf("%w: %s", errNoKeyring, strings.TrimSpace(stderr.String()+" "+err.Error()))
+	}
+
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
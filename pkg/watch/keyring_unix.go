@@ -0,0 +1,43 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package watch
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// lookupKeyring queries the Secret Service using secret-tool with the
+// service and username attributes
+func lookupKeyring(service, user string) (string, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return "", fmt.Errorf("%w: secret-tool not found", errNoKeyring)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+	defer cancel()
+
+	args := []string{"lookup", "service", service}
+	if user != "" {
+		args = append(args, "username", user)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "secret-tool", args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			// Without a running daemon secret-tool fails with a message,
+			// a missing entry only sets the exit code
+			return "", fmt.Errorf("%w: %s", errNoKeyring, msg)
+		}
+		return "", errKeyringMissing
+	}
+
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
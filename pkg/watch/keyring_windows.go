@@ -0,0 +1,93 @@
+//go:build windows
+// +build windows
+
+package watch
+
+import (
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+const (
+	credTypeGeneric = 1
+
+	errorNotFound syscall.Errno = 1168
+)
+
+var (
+	advapi32     = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead = advapi32.NewProc("CredReadW")
+	procCredFree = advapi32.NewProc("CredFree")
+)
+
+// credential is the CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// lookupKeyring reads a generic credential of the Credential Manager,
+// its target is "<service>:<user>" or the service alone
+func lookupKeyring(service, user string) (string, error) {
+	if err := procCredRead.Find(); err != nil {
+		return "", fmt.Errorf("%w: %s", errNoKeyring, err)
+	}
+
+	target := service
+	if user != "" {
+		target += ":" + user
+	}
+	name, err := syscall.UTF16PtrFromString(target)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	ret, _, err := procCredRead.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		if err == errorNotFound {
+			return "", errKeyringMissing
+		}
+		return "", fmt.Errorf("%w: %s", errNoKeyring, err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	return decodeCredentialBlob(blob), nil
+}
+
+// decodeCredentialBlob returns the secret of the blob, which holds UTF-8
+// when written by tools and UTF-16 when entered in the control panel
+func decodeCredentialBlob(blob []byte) string {
+	if utf8.Valid(blob) && !containsZero(blob) {
+		return string(blob)
+	}
+
+	u := make([]uint16, len(blob)/2)
+	for i := range u {
+		u[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+	}
+	return string(utf16.Decode(u))
+}
+
+func containsZero(b []byte) bool {
+	for _, c := range b {
+		if c == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package watch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+const (
+	lineEndingsLF   = "lf"
+	lineEndingsCRLF = "crlf"
+
+	// lineEndingsSniffSize is the amount of data checked for NUL bytes
+	// to detect binary files, the same heuristic git uses
+	lineEndingsSniffSize = 8000
+	lineEndingsChunkSize = 32 * 1024
+)
+
+func validateLineEndings(mode string) error {
+	switch mode {
+	case "", lineEndingsLF, lineEndingsCRLF:
+		return nil
+	default:
+		return fmt.Errorf("Invalid normalize_line_endings %q, use lf or crlf", mode)
+	}
+}
+
+// lineEndingReader converts the line endings of the stream. Binary
+// content detected in the first bytes is passed through unchanged.
+type lineEndingReader struct {
+	r        io.Reader
+	mode     string
+	onBinary func()
+
+	sniff   []byte
+	decided bool
+	binary  bool
+
+	out []byte
+	err error
+
+	// pendingCR holds back a CR at the end of a chunk until it is known
+	// whether a LF follows, prevCR tracks the last byte for CRLF mode
+	pendingCR bool
+	prevCR    bool
+}
+
+func newLineEndingReader(mode string, r io.Reader, onBinary func()) io.Reader {
+	if mode == "" {
+		return r
+	}
+	return &lineEndingReader{r: r, mode: mode, onBinary: onBinary}
+}
+
+func (l *lineEndingReader) Read(p []byte) (int, error) {
+	for len(l.out) == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		l.fill()
+	}
+
+	n := copy(p, l.out)
+	l.out = l.out[n:]
+	return n, nil
+}
+
+func (l *lineEndingReader) fill() {
+	chunk := make([]byte, lineEndingsChunkSize)
+	n, err := l.r.Read(chunk)
+	chunk = chunk[:n]
+
+	if !l.decided {
+		l.sniff = append(l.sniff, chunk...)
+		if len(l.sniff) < lineEndingsSniffSize && err == nil {
+			return
+		}
+
+		l.decided = true
+		if l.binary = bytes.IndexByte(l.sniff, 0) >= 0; l.binary && l.onBinary != nil {
+			l.onBinary()
+		}
+		chunk, l.sniff = l.sniff, nil
+	}
+
+	if l.binary {
+		l.out = append(l.out, chunk...)
+	} else {
+		l.convert(chunk)
+	}
+
+	if err != nil {
+		if l.pendingCR {
+			l.out = append(l.out, '\r')
+			l.pendingCR = false
+		}
+		l.err = err
+	}
+}
+
+func (l *lineEndingReader) convert(chunk []byte) {
+	for _, b := range chunk {
+		switch l.mode {
+		case lineEndingsLF:
+			if l.pendingCR {
+				l.pendingCR = false
+				if b != '\n' {
+					l.out = append(l.out, '\r')
+				}
+			}
+			if b == '\r' {
+				l.pendingCR = true
+				continue
+			}
+
+		case lineEndingsCRLF:
+			if b == '\n' && !l.prevCR {
+				l.out = append(l.out, '\r')
+			}
+			l.prevCR = b == '\r'
+		}
+
+		l.out = append(l.out, b)
+	}
+}
@@ -0,0 +1,184 @@
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Sources of the runtime columns of List
+const (
+	listSourceDaemon = "daemon"
+	listSourceState  = "state file"
+	listSourceConfig = "config"
+)
+
+// listEntry is a row of List
+type listEntry struct {
+	Path                string    `json:"path"`
+	Host                string    `json:"host"`
+	FetchInterval       string    `json:"fetch_interval"`
+	LastSuccess         time.Time `json:"last_success"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	NextRun             time.Time `json:"next_run"`
+	// State is the state of the entry in the daemon, e.g. running
+	State  string `json:"state,omitempty"`
+	Exists bool   `json:"exists"`
+	// SHA256Match is only set for entries with a sha256 of the installed
+	// file
+	SHA256Match *bool  `json:"sha256_match,omitempty"`
+	Source      string `json:"source"`
+}
+
+// List prints the configured entries with their last success and next
+// run, taken from the running daemon or else from the state file, as
+// table or as JSON. Without both only the static configuration and the
+// files on disk are listed.
+func List(configPath string, opts Options, socketPath, statePath, output string) error {
+	if output != "table" && output != "json" {
+		return fmt.Errorf("Unknown output %q, use table or json", output)
+	}
+
+	config, err := loadConfigFile(configPath, opts)
+	if err != nil {
+		return fmt.Errorf("Could not load config: %s", err)
+	}
+
+	source, status := listSource(config, socketPath, statePath)
+
+	entries := []listEntry{}
+	for filePath, fc := range config.Files {
+		e := listEntry{
+			Path:          filePath,
+			Host:          fc.host(),
+			FetchInterval: shortDuration(fc.FetchInterval),
+			Source:        source,
+		}
+
+		switch source {
+		case listSourceDaemon:
+			if fs, ok := status[filePath]; ok {
+				e.LastSuccess, e.ConsecutiveFailures, e.NextRun = fs.LastSuccess, fs.ConsecutiveFailures, fs.NextRun
+				e.State = fs.State
+			}
+		case listSourceState:
+			e.LastSuccess, e.NextRun = fc.snapshot().lastCall, fc.nextRun(filePath)
+		}
+
+		if _, err := os.Stat(filePath); err == nil {
+			e.Exists = true
+		}
+		if fc.SHA256 != "" && fc.checksumOfInstalled() && e.Exists {
+			sum, ok := config.checksums.Sum(filePath)
+			match := ok && strings.EqualFold(sum, fc.SHA256)
+			e.SHA256Match = &match
+		}
+
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	if output == "json" {
+		out, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	printListTable(entries, source)
+	return nil
+}
+
+// listSource returns where the runtime columns come from: the status of
+// the daemon, the state file restored into the config or nothing
+func listSource(config *configFile, socketPath, statePath string) (string, map[string]FileStatus) {
+	if socketPath != "" {
+		res, err := callControlSocket(socketPath, controlRequest{Command: "status"})
+		if err == nil {
+			status := map[string]FileStatus{}
+			for _, fs := range res.Status {
+				status[fs.Path] = fs
+			}
+			return listSourceDaemon, status
+		}
+		config.log.debug("Daemon unreachable, not listing its status: %s", err)
+	}
+
+	if statePath != "" {
+		if _, err := os.Stat(statePath); err == nil {
+			config.state = newStateFile(statePath)
+			if err := config.RestoreState(); err != nil {
+				config.log.debug("Could not read state file, not listing it: %s", err)
+			} else {
+				return listSourceState, nil
+			}
+		}
+	}
+
+	return listSourceConfig, nil
+}
+
+func printListTable(entries []listEntry, source string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tHOST\tINTERVAL\tLAST SUCCESS\tFAILURES\tNEXT RUN\tEXISTS\tSHA256")
+
+	now := time.Now()
+	for _, e := range entries {
+		failures := "-"
+		if source == listSourceDaemon {
+			failures = fmt.Sprint(e.ConsecutiveFailures)
+		}
+
+		last := "-"
+		if !e.LastSuccess.IsZero() {
+			last = now.Sub(e.LastSuccess).Round(time.Second).String() + " ago"
+		} else if source != listSourceConfig {
+			last = "never"
+		}
+
+		next := "-"
+		switch {
+		case source == listSourceConfig:
+		case e.State != "" && e.State != "idle":
+			next = e.State
+		case e.NextRun.After(now):
+			next = "in " + e.NextRun.Sub(now).Round(time.Second).String()
+		default:
+			next = "due"
+		}
+
+		sha := "-"
+		if e.SHA256Match != nil {
+			sha = "mismatch"
+			if *e.SHA256Match {
+				sha = "ok"
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Path, orDash(e.Host), e.FetchInterval, last, failures, next,
+			yesNo(e.Exists), sha)
+	}
+	w.Flush()
+
+	fmt.Fprintf(os.Stderr, "%d files, runtime columns from %s\n", len(entries), source)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
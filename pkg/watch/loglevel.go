@@ -0,0 +1,170 @@
+package watch
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+const (
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+	logLevelOff   = "off"
+)
+
+// Severities of log messages, a message is logged if its level is at
+// least the minimum level of the package or the entry
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+	levelOff
+)
+
+// fileLogLevels are the names allowed as log_level of an entry
+var fileLogLevels = map[string]int{
+	logLevelInfo:  levelInfo,
+	logLevelWarn:  levelWarn,
+	logLevelError: levelError,
+	logLevelOff:   levelOff,
+}
+
+// logger writes the messages of a Watcher and of the configurations
+// loaded for it. The level is read by every message and toggled at
+// runtime, so it is accessed atomically. Decrypted values and keyring
+// secrets are masked in all messages. A nil logger logs at info level.
+type logger struct {
+	debugFlag int32
+	// quietFlag suppresses all messages below warn
+	quietFlag int32
+
+	secrets *secretSet
+}
+
+func newLogger(debug, quiet bool) *logger {
+	return &logger{debugFlag: boolFlag(debug), quietFlag: boolFlag(quiet), secrets: newSecretSet()}
+}
+
+func (l *logger) debug(format string, args ...interface{}) {
+	if l.debugEnabled() {
+		l.print(format, args...)
+	}
+}
+
+// logf logs the message if its level is not below the level of the
+// logger
+func (l *logger) logf(level int, format string, args ...interface{}) {
+	if level >= l.minLevel() {
+		l.print(format, args...)
+	}
+}
+
+func (l *logger) print(format string, args ...interface{}) {
+	if l == nil {
+		log.Printf(format, args...)
+		return
+	}
+	log.Print(l.secrets.mask(fmt.Sprintf(format, args...)))
+}
+
+func (l *logger) addSecret(v string) {
+	if l != nil {
+		l.secrets.add(v)
+	}
+}
+
+// mask replaces the secrets known to the logger in s
+func (l *logger) mask(s string) string {
+	if l == nil {
+		return s
+	}
+	return l.secrets.mask(s)
+}
+
+// logf logs a message about the entry, its log_level may raise the
+// minimum level. Debug logging shows all messages.
+func (c *configFileSource) logf(level int, format string, args ...interface{}) {
+	min := c.log.minLevel()
+	if l, ok := fileLogLevels[c.LogLevel]; ok && l > min && min > levelDebug {
+		min = l
+	}
+	if level >= min {
+		c.log.print(format, args...)
+	}
+}
+
+// securityError marks failures of checks protecting the target, like
+// checksum or pin mismatches, which are always logged
+type securityError struct {
+	err error
+}
+
+func (e securityError) Error() string { return e.err.Error() }
+func (e securityError) Unwrap() error { return e.err }
+
+func isSecurityError(err error) bool {
+	var (
+		sec      securityError
+		unknown  x509.UnknownAuthorityError
+		invalid  x509.CertificateInvalidError
+		hostname x509.HostnameError
+	)
+	return errors.As(err, &sec) || errors.Is(err, errNotAllowed) ||
+		errors.As(err, &unknown) || errors.As(err, &invalid) || errors.As(err, &hostname)
+}
+
+func (l *logger) minLevel() int {
+	switch {
+	case l.debugEnabled():
+		return levelDebug
+	case l.quietEnabled():
+		return levelWarn
+	}
+	return levelInfo
+}
+
+func validateFileLogLevel(level string) error {
+	if _, ok := fileLogLevels[level]; level != "" && !ok {
+		return fmt.Errorf("Unknown log_level %q, use info, warn, error or off", level)
+	}
+	return nil
+}
+
+func (l *logger) setDebug(enabled bool) {
+	atomic.StoreInt32(&l.debugFlag, boolFlag(enabled))
+}
+
+func (l *logger) debugEnabled() bool {
+	return l != nil && atomic.LoadInt32(&l.debugFlag) == 1
+}
+
+func (l *logger) setQuiet(enabled bool) {
+	atomic.StoreInt32(&l.quietFlag, boolFlag(enabled))
+}
+
+func (l *logger) quietEnabled() bool {
+	return l != nil && atomic.LoadInt32(&l.quietFlag) == 1
+}
+
+func boolFlag(enabled bool) int32 {
+	if enabled {
+		return 1
+	}
+	return 0
+}
+
+// level returns the name of the current log level: debug, info or warn
+func (l *logger) level() string {
+	switch l.minLevel() {
+	case levelDebug:
+		return logLevelDebug
+	case levelWarn:
+		return logLevelWarn
+	}
+	return logLevelInfo
+}
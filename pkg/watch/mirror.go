@@ -0,0 +1,281 @@
+package watch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMirrorTimeout = 5 * time.Minute
+	mirrorAttempts       = 3
+	mirrorRetryDelay     = 5 * time.Second
+
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// MirrorStatus is the result of the last upload to the mirror_to
+// destination
+type MirrorStatus struct {
+	Destination string    `json:"destination"`
+	Time        time.Time `json:"time"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func validateMirrorTo(dest string) error {
+	if dest == "" {
+		return nil
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil {
+		return fmt.Errorf("Invalid mirror_to: %s", err)
+	}
+
+	switch u.Scheme {
+	case "s3", "http", "https":
+	default:
+		return fmt.Errorf("Invalid mirror_to scheme %q, use s3:// or https://", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return errors.New("Invalid mirror_to: missing host or bucket")
+	}
+	return nil
+}
+
+func (c *configFileSource) setMirrorStatus(s MirrorStatus) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.mirror = &s
+}
+
+func (c *configFileSource) getMirrorStatus() *MirrorStatus {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.mirror == nil {
+		return nil
+	}
+	s := *c.mirror
+	return &s
+}
+
+// mirrorFile uploads the just written file to the mirror_to destination
+// of the entry. Failures are recorded and alerted but never touch the
+// local file.
+func (c *configFile) mirrorFile(targetPath string, fc *configFileSource) {
+	dest, _ := url.Parse(fc.MirrorTo)
+	start := time.Now()
+	status := MirrorStatus{Destination: dest.Redacted()}
+
+	delay := mirrorRetryDelay
+	var err error
+	for status.Attempts = 1; ; status.Attempts++ {
+		status.SHA256, err = fc.uploadMirror(targetPath, dest)
+		if err = fc.sanitizeError(err); err == nil {
+			break
+		}
+		if status.Attempts == mirrorAttempts {
+			break
+		}
+
+		c.log.debug("Mirror of '%s' to %s failed (attempt %d), retrying in %s: %s", targetPath, status.Destination, status.Attempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	status.Time = time.Now()
+	if err == nil {
+		c.log.debug("Mirrored '%s' to %s", targetPath, status.Destination)
+		fc.setMirrorStatus(status)
+		return
+	}
+
+	status.Error = err.Error()
+	fc.setMirrorStatus(status)
+	fc.logf(levelError, "ERROR: Could not mirror '%s' to %s: %s", targetPath, status.Destination, err)
+
+	c.alertFailure(targetPath, fc, failureEventMirrorFailed, fileErrorState{
+		LastError:           err.Error(),
+		LastErrorAt:         status.Time,
+		FirstErrorAt:        start,
+		ConsecutiveFailures: status.Attempts,
+	})
+	c.notifyCommand(notifyEvent{
+		Event:    notifyEventMirrorFailed,
+		Path:     targetPath,
+		URL:      fc.sourceURL(),
+		SHA256:   status.SHA256,
+		Error:    err.Error(),
+		Failures: status.Attempts,
+	})
+}
+
+// uploadMirror streams the local file to the destination with a PUT
+// request, S3 destinations are signed with the AWS credentials from the
+// environment. The SHA256 of the uploaded content is returned, it is
+// hashed right before the upload as the file might have been changed in
+// place, e.g. by mode: append.
+func (c *configFileSource) uploadMirror(targetPath string, dest *url.URL) (string, error) {
+	f, err := os.Open(targetPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// The upload is limited to the hashed bytes in case the file grows
+	hash := sha256.New()
+	if _, err := c.buffers.pooledCopy(hash, io.NewSectionReader(f, 0, info.Size())); err != nil {
+		return "", err
+	}
+	sha := hex.EncodeToString(hash.Sum(nil))
+
+	target := dest
+	if dest.Scheme == "s3" {
+		if target, err = s3ObjectURL(dest, targetPath); err != nil {
+			return sha, err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPut, target.String(), io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		return sha, err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("User-Agent", "download-watch/"+Version)
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	if dest.Scheme == "s3" {
+		if err = signS3Request(req, sha, time.Now()); err != nil {
+			return sha, err
+		}
+	}
+
+	timeout := c.MirrorTimeout
+	if timeout <= 0 {
+		timeout = defaultMirrorTimeout
+	}
+
+	res, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return sha, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return sha, fmt.Errorf("Got status code %d", res.StatusCode)
+	}
+	return sha, nil
+}
+
+// s3ObjectURL resolves s3://bucket/key to the HTTPS URL of the object,
+// a key ending in a slash gets the file name appended.
+// AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL) selects an S3 compatible
+// endpoint which is addressed path-style.
+func s3ObjectURL(dest *url.URL, targetPath string) (*url.URL, error) {
+	key := strings.TrimPrefix(dest.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += path.Base(strings.Replace(targetPath, `\`, "/", -1))
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint != "" {
+		return url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + dest.Host + "/" + key)
+	}
+
+	return url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", dest.Host, s3Region(), key))
+}
+
+func s3Region() string {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if r := os.Getenv(env); r != "" {
+			return r
+		}
+	}
+	return "us-east-1"
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signS3Request adds an AWS signature version 4 to the request using
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+func signS3Request(req *http.Request, payloadSHA string, now time.Time) error {
+	keyID, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if keyID == "" || secret == "" {
+		return errors.New("S3 upload needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	if payloadSHA == "" {
+		payloadSHA = s3UnsignedPayload
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	region := s3Region()
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadSHA)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			headers[lk] = strings.TrimSpace(v[0])
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	canonical := new(strings.Builder)
+	fmt.Fprintf(canonical, "%s\n%s\n%s\n", req.Method, req.URL.EscapedPath(), req.URL.RawQuery)
+	for _, k := range names {
+		fmt.Fprintf(canonical, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+	fmt.Fprintf(canonical, "\n%s\n%s", signedHeaders, payloadSHA)
+
+	canonicalHash := sha256.Sum256([]byte(canonical.String()))
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		keyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+	return nil
+}
@@ -0,0 +1,55 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestMirrorSignsUploadedContent(t *testing.T) {
+	type upload struct{ body, sha string }
+	uploads := make(chan upload, 1)
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		uploads <- upload{string(body), r.Header.Get("X-Amz-Content-Sha256")}
+	}))
+	defer s3.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", s3.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "appended\n")
+	}))
+	defer srv.Close()
+
+	target := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(target, []byte("existing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s\n    mode: append\n    mirror_to: s3://bucket/\n", target, srv.URL))
+	if err := w.config.runFetch(context.Background(), target, w.lookup(target), false); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case u := <-uploads:
+		if u.body != "existing\nappended\n" {
+			t.Errorf("uploaded %q, want the whole appended file", u.body)
+		}
+		sum := sha256.Sum256([]byte(u.body))
+		if want := hex.EncodeToString(sum[:]); u.sha != want {
+			t.Errorf("upload is signed with payload hash %s, want %s", u.sha, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("file was not mirrored")
+	}
+}
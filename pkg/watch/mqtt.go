@@ -0,0 +1,509 @@
+package watch
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Minimal MQTT 3.1.1 client which only subscribes to topics with QoS 1
+// and reacts to incoming messages
+
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+	mqttMaxBackoff  = time.Minute
+	mqttDialTimeout = 30 * time.Second
+	// mqttMaxBody is the part of a packet kept in memory, it holds the
+	// longest topic and the packet id. The rest, the payload which is
+	// not used, is discarded.
+	mqttMaxBody = 1 << 17
+
+	defaultMQTTKeepAlive = 30 * time.Second
+)
+
+// mqttConfig describes the broker to receive fetch triggers from
+type mqttConfig struct {
+	Broker             string        `yaml:"broker"`
+	Username           string        `yaml:"username"`
+	Password           string        `yaml:"password"`
+	ClientID           string        `yaml:"client_id"`
+	KeepAlive          time.Duration `yaml:"keep_alive"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+}
+
+func (m *mqttConfig) validate() error {
+	u, err := url.Parse(m.Broker)
+	if err != nil {
+		return fmt.Errorf("Invalid MQTT broker %q: %s", m.Broker, err)
+	}
+
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts":
+	default:
+		return fmt.Errorf("Invalid MQTT broker scheme %q, use tcp:// or ssl://", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("Invalid MQTT broker %q: missing host", m.Broker)
+	}
+	return nil
+}
+
+func (m *mqttConfig) clientID(host string) string {
+	if m.ClientID != "" {
+		return m.ClientID
+	}
+	return fmt.Sprintf("download-watch-%s-%d", host, os.Getpid())
+}
+
+func (m *mqttConfig) keepAlive() time.Duration {
+	if m.KeepAlive <= 0 {
+		return defaultMQTTKeepAlive
+	}
+	return m.KeepAlive
+}
+
+// mqttTopicMatch reports whether the topic matches the subscription
+// filter including the + and # wildcards
+func mqttTopicMatch(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+
+	for i, part := range f {
+		switch {
+		case part == "#":
+			return true
+		case i >= len(t):
+			return false
+		case part != "+" && part != t[i]:
+			return false
+		}
+	}
+
+	return len(f) == len(t)
+}
+
+// mqttClient keeps a connection to the broker, resubscribes after every
+// reconnect and triggers the fetch of entries whose topic got a message
+type mqttClient struct {
+	cfg     mqttConfig
+	rootCAs *x509.CertPool
+	log     *logger
+	host    string
+	topics  []string
+	trigger func(topic string, retained bool)
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+	// ctx aborts a running dial once the client is closed or the
+	// Watcher stops
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newMQTTClient(ctx context.Context, l *logger, host string, cfg mqttConfig, rootCAs *x509.CertPool, topics []string, trigger func(string, bool)) *mqttClient {
+	c := &mqttClient{
+		cfg:     cfg,
+		rootCAs: rootCAs,
+		log:     l,
+		host:    host,
+		topics:  topics,
+		trigger: trigger,
+		done:    make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	go c.run()
+	return c
+}
+
+// Close disconnects from the broker and stops reconnecting
+func (c *mqttClient) Close() {
+	c.mu.Lock()
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+	c.cancel()
+
+	if conn != nil {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		writeMQTTPacket(conn, mqttDisconnect<<4, nil)
+		conn.Close()
+	}
+	<-c.done
+}
+
+func (c *mqttClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *mqttClient) run() {
+	defer close(c.done)
+
+	backoff := time.Second
+	first := true
+	for !c.isClosed() {
+		start := time.Now()
+		err := c.session(c.ctx, first)
+		if c.isClosed() {
+			return
+		}
+		first = false
+
+		if time.Since(start) > mqttMaxBackoff {
+			backoff = time.Second
+		}
+		c.log.logf(levelWarn, "MQTT connection to %s lost, reconnecting in %s: %s", sanitizeURL(c.cfg.Broker), backoff,
+			sanitizeText(err.Error()))
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > mqttMaxBackoff {
+			backoff = mqttMaxBackoff
+		}
+	}
+}
+
+func (c *mqttClient) dial(ctx context.Context) (net.Conn, error) {
+	u, err := url.Parse(c.cfg.Broker)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: mqttDialTimeout}
+	switch u.Scheme {
+	case "ssl", "tls", "mqtts":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "8883")
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{
+			ServerName:         u.Hostname(),
+			RootCAs:            c.rootCAs,
+			InsecureSkipVerify: c.cfg.InsecureSkipVerify,
+		}}
+		return tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "1883")
+		}
+		return dialer.DialContext(ctx, "tcp", host)
+	}
+}
+
+// session connects, subscribes and processes messages until the
+// connection fails. Retained messages delivered for the subscription
+// are ignored on the first connect as everything is fetched on start.
+func (c *mqttClient) session(ctx context.Context, first bool) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.conn = conn
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+	}()
+
+	keepAlive := c.cfg.keepAlive()
+	r := bufio.NewReader(conn)
+
+	conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+	if err := writeMQTTPacket(conn, mqttConnect<<4, c.connectPayload(keepAlive)); err != nil {
+		return err
+	}
+
+	typ, body, err := readMQTTPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ>>4 != mqttConnack || len(body) != 2 {
+		return errors.New("Unexpected answer to CONNECT")
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("Connection refused by broker (code %d)", body[1])
+	}
+
+	if len(c.topics) > 0 {
+		if err := writeMQTTPacket(conn, mqttSubscribe<<4|0x2, c.subscribePayload()); err != nil {
+			return err
+		}
+	}
+	c.log.debug("Connected to MQTT broker %s, subscribed to %d topics", sanitizeURL(c.cfg.Broker), len(c.topics))
+
+	var writeMu sync.Mutex
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go func() {
+		t := time.NewTicker(keepAlive)
+		defer t.Stop()
+		for {
+			select {
+			case <-stopPing:
+				return
+			case <-t.C:
+				writeMu.Lock()
+				conn.SetWriteDeadline(time.Now().Add(keepAlive))
+				err := writeMQTTPacket(conn, mqttPingreq<<4, nil)
+				writeMu.Unlock()
+				if err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		typ, body, err := readMQTTPacket(r)
+		if err != nil {
+			return err
+		}
+
+		switch typ >> 4 {
+		case mqttPublish:
+			topic, id, err := parseMQTTPublish(typ, body)
+			if err != nil {
+				return err
+			}
+			if id != 0 {
+				writeMu.Lock()
+				conn.SetWriteDeadline(time.Now().Add(keepAlive))
+				err = writeMQTTPacket(conn, mqttPuback<<4, []byte{byte(id >> 8), byte(id)})
+				writeMu.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+
+			retained := typ&0x1 != 0
+			if retained && first {
+				c.log.debug("Ignoring retained MQTT message on %q from initial subscription", topic)
+				continue
+			}
+			c.trigger(topic, retained)
+
+		case mqttSuback:
+			codes, err := parseMQTTSuback(body)
+			if err != nil {
+				return err
+			}
+			for _, code := range codes {
+				if code == 0x80 {
+					c.log.logf(levelWarn, "WARNING: MQTT broker %s refused a subscription", sanitizeURL(c.cfg.Broker))
+				}
+			}
+
+		case mqttPingresp:
+		default:
+			c.log.debug("Ignoring MQTT packet of type %d", typ>>4)
+		}
+	}
+}
+
+func (c *mqttClient) connectPayload(keepAlive time.Duration) []byte {
+	var flags byte = 0x02 // clean session
+	payload := mqttString(c.cfg.clientID(c.host))
+	if c.cfg.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(c.cfg.Username)...)
+		if c.cfg.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(c.cfg.Password)...)
+		}
+	}
+
+	ka := uint16(keepAlive / time.Second)
+	header := append(mqttString("MQTT"), 4, flags, byte(ka>>8), byte(ka))
+	return append(header, payload...)
+}
+
+func (c *mqttClient) subscribePayload() []byte {
+	body := []byte{0, 1} // packet id
+	for _, t := range c.topics {
+		body = append(body, mqttString(t)...)
+		body = append(body, 1) // QoS 1
+	}
+	return body
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func writeMQTTPacket(w io.Writer, typ byte, body []byte) error {
+	pkt := []byte{typ}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+
+	_, err := w.Write(append(pkt, body...))
+	return err
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("Malformed MQTT packet length")
+		}
+		mult *= 128
+	}
+
+	keep := length
+	if keep > mqttMaxBody {
+		keep = mqttMaxBody
+	}
+	body := make([]byte, keep)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(length-keep)); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+// parseMQTTSuback returns the return codes of the subscriptions
+func parseMQTTSuback(body []byte) ([]byte, error) {
+	if len(body) < 2 {
+		return nil, errors.New("Malformed MQTT SUBACK")
+	}
+	return body[2:], nil
+}
+
+// parseMQTTPublish returns the topic and the packet id (0 for QoS 0)
+func parseMQTTPublish(typ byte, body []byte) (string, uint16, error) {
+	if len(body) < 2 {
+		return "", 0, errors.New("Malformed MQTT PUBLISH")
+	}
+	l := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+l {
+		return "", 0, errors.New("Malformed MQTT PUBLISH")
+	}
+	topic := string(body[2 : 2+l])
+
+	if (typ>>1)&0x3 == 0 {
+		return topic, 0, nil
+	}
+	if len(body) < 4+l {
+		return "", 0, errors.New("Malformed MQTT PUBLISH")
+	}
+	return topic, binary.BigEndian.Uint16(body[2+l:]), nil
+}
+
+// mqttTopics returns the sorted distinct trigger topics of all entries
+func (c *configFile) mqttTopics() []string {
+	seen := map[string]bool{}
+	topics := []string{}
+	for _, fc := range c.Files {
+		if fc.TriggerTopic != "" && !seen[fc.TriggerTopic] {
+			seen[fc.TriggerTopic] = true
+			topics = append(topics, fc.TriggerTopic)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// updateMQTT (re)connects the MQTT client when the broker or the topics
+// changed. Needs to be called with the lock held.
+func (c *configFile) updateMQTT() {
+	topics := c.mqttTopics()
+
+	if c.mqtt != nil {
+		if c.MQTT != nil && c.mqtt.cfg == *c.MQTT && stringList(c.mqtt.topics).Equals(topics) {
+			return
+		}
+		go c.mqtt.Close()
+		c.mqtt = nil
+	}
+
+	if c.MQTT == nil || c.MQTT.Broker == "" {
+		return
+	}
+
+	c.mqtt = newMQTTClient(c.rootContext(), c.log, c.hostname, *c.MQTT, c.rootCAs, topics, c.mqttTrigger)
+}
+
+// mqttTrigger schedules the fetch of all entries with a trigger topic
+// matching the topic of the message
+func (c *configFile) mqttTrigger(topic string, retained bool) {
+	c.RLock()
+	var paths []string
+	for filePath, fc := range c.Files {
+		if fc.TriggerTopic != "" && mqttTopicMatch(fc.TriggerTopic, topic) {
+			paths = append(paths, filePath)
+		}
+	}
+	c.RUnlock()
+
+	for _, filePath := range paths {
+		if _, err := c.TriggerFetch(filePath); err != nil {
+			c.log.debug("Ignoring MQTT trigger on %q for '%s': %s", topic, filePath, err)
+			continue
+		}
+		c.log.logf(levelInfo, "Fetch of '%s' triggered by MQTT message on %q", filePath, topic)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build go1.18
+// +build go1.18
+
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func FuzzMQTTPacket(f *testing.F) {
+	f.Add([]byte{mqttSuback << 4, 0})
+	f.Add([]byte{mqttSuback << 4, 3, 0, 1, 0x80})
+	f.Add(mqttPacket(mqttPublish<<4|0x2, append(mqttString("a/b"), 0, 1, 'x')))
+	f.Add([]byte{mqttPublish << 4, 0xff, 0xff, 0xff, 0x7f})
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		typ, body, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(in)))
+		if err != nil {
+			return
+		}
+		if len(body) > mqttMaxBody {
+			t.Fatalf("kept %d bytes", len(body))
+		}
+		switch typ >> 4 {
+		case mqttPublish:
+			parseMQTTPublish(typ, body)
+		case mqttSuback:
+			parseMQTTSuback(body)
+		}
+	})
+}
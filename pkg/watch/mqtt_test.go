@@ -0,0 +1,171 @@
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// fakeMQTTBroker accepts one connection, answers CONNECT and SUBSCRIBE
+// and then sends the packets
+func fakeMQTTBroker(t *testing.T, suback []byte, packets ...[]byte) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		if _, _, err := readMQTTPacket(r); err != nil {
+			return
+		}
+		writeMQTTPacket(conn, mqttConnack<<4, []byte{0, 0})
+		if _, _, err := readMQTTPacket(r); err != nil {
+			return
+		}
+		writeMQTTPacket(conn, mqttSuback<<4, suback)
+		for _, p := range packets {
+			conn.Write(p)
+		}
+		// Keep the connection open until the client gives up
+		readMQTTPacket(r)
+	}()
+
+	return "tcp://" + l.Addr().String()
+}
+
+func mqttPacket(typ byte, body []byte) []byte {
+	var buf bytes.Buffer
+	writeMQTTPacket(&buf, typ, body)
+	return buf.Bytes()
+}
+
+func TestMQTTSessionDropsMalformedSuback(t *testing.T) {
+	for _, suback := range [][]byte{nil, {0}} {
+		c := &mqttClient{
+			cfg:     mqttConfig{Broker: fakeMQTTBroker(t, suback), KeepAlive: time.Second},
+			topics:  []string{"deploy/#"},
+			trigger: func(string, bool) {},
+		}
+
+		err := c.session(context.Background(), true)
+		if err == nil || !strings.Contains(err.Error(), "Malformed MQTT SUBACK") {
+			t.Errorf("SUBACK %v: expected the session to fail, got %v", suback, err)
+		}
+	}
+}
+
+func TestMQTTSessionTriggersOnLargePublish(t *testing.T) {
+	// A QoS 0 message with a payload above the kept part of the body
+	body := append(mqttString("deploy/app"), bytes.Repeat([]byte("x"), mqttMaxBody+1000)...)
+	broker := fakeMQTTBroker(t, []byte{0, 1, 1}, mqttPacket(mqttPublish<<4, body), mqttPacket(mqttPublish<<4, []byte{0}))
+
+	var topics []string
+	c := &mqttClient{
+		cfg:     mqttConfig{Broker: broker, KeepAlive: time.Second},
+		topics:  []string{"deploy/#"},
+		trigger: func(topic string, _ bool) { topics = append(topics, topic) },
+	}
+
+	err := c.session(context.Background(), false)
+	if err == nil || !strings.Contains(err.Error(), "Malformed MQTT PUBLISH") {
+		t.Errorf("expected the truncated PUBLISH to end the session, got %v", err)
+	}
+	if len(topics) != 1 || topics[0] != "deploy/app" {
+		t.Errorf("got triggers %v, want [deploy/app]", topics)
+	}
+}
+
+func TestReadMQTTPacketLimitsBody(t *testing.T) {
+	// Declares 256MB but the connection ends early
+	r := bufio.NewReader(bytes.NewReader([]byte{mqttPublish << 4, 0xff, 0xff, 0xff, 0x7f, 0, 1, 'a'}))
+	if _, _, err := readMQTTPacket(r); err == nil {
+		t.Fatal("expected an error for the truncated packet")
+	}
+
+	body := bytes.Repeat([]byte("y"), mqttMaxBody*2)
+	typ, got, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(mqttPacket(mqttPublish<<4, body))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ>>4 != mqttPublish || len(got) != mqttMaxBody {
+		t.Errorf("got type %d with %d bytes, want %d with %d", typ>>4, len(got), mqttPublish, mqttMaxBody)
+	}
+}
+
+func TestReadMQTTPacketMalformedLength(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader([]byte{mqttPublish << 4, 0xff, 0xff, 0xff, 0xff, 0x01}))
+	if _, _, err := readMQTTPacket(r); err == nil {
+		t.Fatal("expected an error for the 5 byte length")
+	}
+}
+
+func TestParseMQTTPublish(t *testing.T) {
+	for _, tc := range []struct {
+		typ   byte
+		body  []byte
+		topic string
+		id    uint16
+		ok    bool
+	}{
+		{typ: mqttPublish << 4, body: mqttString("a/b"), topic: "a/b", ok: true},
+		{typ: mqttPublish<<4 | 0x2, body: append(mqttString("a/b"), 0, 7), topic: "a/b", id: 7, ok: true},
+		{typ: mqttPublish<<4 | 0x2, body: mqttString("a/b")},
+		{typ: mqttPublish << 4, body: []byte{0, 5, 'a'}},
+		{typ: mqttPublish << 4, body: []byte{0}},
+		{typ: mqttPublish << 4},
+	} {
+		topic, id, err := parseMQTTPublish(tc.typ, tc.body)
+		if (err == nil) != tc.ok || topic != tc.topic || id != tc.id {
+			t.Errorf("%v: got %q, %d, %v", tc.body, topic, id, err)
+		}
+	}
+}
+
+func TestMQTTCloseAbortsDial(t *testing.T) {
+	// Accepts the connection but never answers the TLS handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	c := newMQTTClient(context.Background(), nil, "host", mqttConfig{Broker: "ssl://" + l.Addr().String()}, nil, nil, func(string, bool) {})
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the dial to time out")
+	}
+}
@@ -0,0 +1,225 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// partState is the persisted state of one part of an entry with urls,
+// Offset and Length locate the part in the installed file
+type partState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Offset       int64  `json:"offset"`
+	Length       int64  `json:"length"`
+	SHA256       string `json:"sha256"`
+}
+
+func (c *configFileSource) validateURLs() error {
+	if len(c.URLs) == 0 {
+		return nil
+	}
+
+	switch {
+	case c.URL != "" || c.URLCommand != "":
+		return errors.New("urls can't be used together with url or url_command")
+	case c.method() != http.MethodGet || c.AsyncPoll != nil:
+		return errors.New("urls only support GET requests without async_poll")
+	case c.CheckInterval > 0:
+		return errors.New("check_interval can't be used with urls")
+	case c.WatchOnly || c.appendMode():
+		return errors.New("urls can't be used with watch_only or mode append")
+	case c.OnMissing != "" && c.OnMissing != onMissingKeep:
+		return errors.New("on_missing can't be used with urls")
+	}
+
+	seen := map[string]bool{}
+	for _, u := range c.URLs {
+		if u == "" {
+			return errors.New("urls must not contain empty entries")
+		}
+		if seen[u] {
+			return fmt.Errorf("URL %q is listed twice in urls", u)
+		}
+		seen[u] = true
+	}
+	return nil
+}
+
+// fetchParts downloads all urls in order into out. Parts answering with
+// 304 are copied from the installed file after verifying their checksum,
+// a mismatch fetches the part again without validators. The returned
+// bool reports whether any part or their order changed.
+func (c *configFileSource) fetchParts(ctx context.Context, cancel context.CancelFunc, timeout time.Duration,
+	client *http.Client, targetPath, userAgent string, totalRate *rateLimiter, conditional bool,
+	out io.Writer, rec *FetchRecord) (map[string]partState, bool, error) {
+	var (
+		parts   = make(map[string]partState, len(c.URLs))
+		changed = len(c.parts) != len(c.URLs)
+		offset  int64
+	)
+
+	installed, err := os.Open(targetPath)
+	if err != nil {
+		// Without the installed file no part can be reused
+		installed, conditional, changed = nil, false, true
+	} else {
+		defer installed.Close()
+	}
+
+	for i, partURL := range c.URLs {
+		prev, hasPrev := c.parts[partURL]
+		hash := sha256.New()
+
+		var (
+			ps    partState
+			fresh bool
+		)
+		for attempt := 0; ; attempt++ {
+			useValidators := conditional && hasPrev && attempt == 0
+
+			req, err := http.NewRequest(http.MethodGet, partURL, nil)
+			if err != nil {
+				return nil, false, err
+			}
+			if err := c.prepareRequest(req, userAgent); err != nil {
+				return nil, false, err
+			}
+			if useValidators && prev.ETag != "" {
+				req.Header.Set("If-None-Match", prev.ETag)
+			}
+			if useValidators && prev.LastModified != "" {
+				req.Header.Set("If-Modified-Since", prev.LastModified)
+			}
+
+			res, err := ctxhttp.Do(ctx, client, req)
+			if err != nil {
+				return nil, false, fmt.Errorf("Part %d: %s", i+1, wrapTransportError(ctx, client, req, err))
+			}
+			rec.StatusCode = res.StatusCode
+
+			switch {
+			case res.StatusCode == http.StatusNotModified && useValidators:
+				res.Body.Close()
+				if ok, err := copyPart(c.buffers, installed, prev, io.MultiWriter(out, hash)); err != nil {
+					return nil, false, fmt.Errorf("Part %d: Could not reuse installed content: %s", i+1, err)
+				} else if !ok {
+					c.log.debug("Installed content of part %d of '%s' does not match, fetching it again", i+1, targetPath)
+					changed = true
+					continue
+				}
+				ps = prev
+			case res.StatusCode == http.StatusOK:
+				n, err := c.copyBody(ctx, cancel, timeout, targetPath, totalRate, res, io.MultiWriter(out, hash))
+				res.Body.Close()
+				rec.Bytes += n
+				if err != nil {
+					return nil, false, fmt.Errorf("Part %d: %s", i+1, err)
+				}
+				ps = partState{
+					ETag:         res.Header.Get("ETag"),
+					LastModified: res.Header.Get("Last-Modified"),
+					Length:       n,
+				}
+				fresh = true
+			default:
+				res.Body.Close()
+				return nil, false, fmt.Errorf("Part %d: Got status code %d", i+1, res.StatusCode)
+			}
+			break
+		}
+
+		ps.SHA256 = fmt.Sprintf("%x", hash.Sum(nil))
+		if fresh && (!hasPrev || ps.SHA256 != prev.SHA256) {
+			changed = true
+		}
+		if !hasPrev || prev.Offset != offset {
+			changed = true
+		}
+		ps.Offset = offset
+		offset += ps.Length
+		parts[partURL] = ps
+	}
+
+	return parts, changed, nil
+}
+
+// copyPart copies the byte range of the part from the installed file
+// and reports whether it still matches the checksum of the part
+func copyPart(buffers *bufferPool, installed *os.File, ps partState, out io.Writer) (bool, error) {
+	if installed == nil {
+		return false, nil
+	}
+
+	hash := sha256.New()
+	if _, err := buffers.pooledCopy(hash, io.NewSectionReader(installed, ps.Offset, ps.Length)); err != nil {
+		return false, err
+	}
+	if fmt.Sprintf("%x", hash.Sum(nil)) != ps.SHA256 {
+		return false, nil
+	}
+
+	_, err := buffers.pooledCopy(out, io.NewSectionReader(installed, ps.Offset, ps.Length))
+	return true, err
+}
+
+// executeMultiDownload concatenates all urls of the entry into a temp
+// file which is installed like a single download. Nothing is installed
+// if any part fails.
+func (c *configFile) executeMultiDownload(ctx context.Context, cancel context.CancelFunc, timeout time.Duration,
+	client *http.Client, targetPath string, targetConfig *configFileSource, userAgent string, totalRate *rateLimiter,
+	forceFetch, logDiff bool, rec *FetchRecord) error {
+	if err := mkdirAll(path.Dir(targetPath), targetConfig.dirMode()); err != nil {
+		return err
+	}
+
+	t, err := ioutil.TempFile(path.Dir(targetPath), path.Base(targetPath))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(t.Name())
+	defer t.Close()
+
+	hash := sha256.New()
+	copyStart := time.Now()
+	conditional := !targetConfig.IgnoreETag && !forceFetch
+
+	parts, changed, err := targetConfig.fetchParts(ctx, cancel, timeout, client, targetPath, userAgent, totalRate,
+		conditional, io.MultiWriter(t, hash), rec)
+	if err != nil {
+		return err
+	}
+
+	if !changed {
+		rec.Outcome = outcomeNotModified
+		targetConfig.Finish("", "")
+		c.saveState()
+		return nil
+	}
+	rec.BytesPerSecond = int64(effectiveRate(rec.Bytes, time.Since(copyStart)))
+	c.log.debug("Fetched %s of '%s' (%d parts) with %s/s", byteSize(rec.Bytes), targetPath, len(parts),
+		byteSize(rec.BytesPerSecond))
+
+	if err := t.Close(); err != nil {
+		return err
+	}
+
+	// An install failing afterwards is detected by the checksums of the
+	// parts in the next run
+	targetConfig.runMu.Lock()
+	targetConfig.parts = parts
+	targetConfig.runMu.Unlock()
+
+	result := downloadResult{SHA256: fmt.Sprintf("%x", hash.Sum(nil))}
+	return c.installDownload(targetPath, targetConfig, t.Name(), result, responseValidators{Length: -1}, logDiff, rec)
+}
@@ -0,0 +1,103 @@
+package watch
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const defaultNotificationsPerHour = 20
+
+// notificationsConfig contains the channels failure and recovery events
+// are sent to
+type notificationsConfig struct {
+	Slack *slackConfig `yaml:"slack"`
+	Email *emailConfig `yaml:"email"`
+	// MaxPerHour limits the messages sent per channel to not flood it
+	// with a flapping source
+	MaxPerHour int `yaml:"max_per_hour"`
+}
+
+// messageLimiter allows a maximum amount of messages within a sliding
+// window of one hour
+type messageLimiter struct {
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+func (l *messageLimiter) Allow(max int) bool {
+	if max <= 0 {
+		max = defaultNotificationsPerHour
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Hour)
+	for len(l.sent) > 0 && l.sent[0].Before(cutoff) {
+		l.sent = l.sent[1:]
+	}
+
+	if len(l.sent) >= max {
+		return false
+	}
+
+	l.sent = append(l.sent, time.Now())
+	return true
+}
+
+// notificationText formats the event as a single line message
+func notificationText(ev failureEvent) string {
+	if ev.Event == failureEventRecovered {
+		return fmt.Sprintf("%s on %s recovered after %d failures (outage %s)",
+			ev.Path, ev.Host, ev.Failures, ev.Duration.Round(time.Second))
+	}
+
+	if ev.Event == failureEventMirrorFailed {
+		return fmt.Sprintf("%s on %s could not be mirrored after %d attempts: %s",
+			ev.Path, ev.Host, ev.Failures, ev.Error)
+	}
+
+	source := ev.URL
+	if u, err := url.Parse(ev.URL); err == nil {
+		source = u.Host
+	}
+
+	return fmt.Sprintf("%s on %s failing for %s (%d failures): %s from %s",
+		ev.Path, ev.Host, ev.Duration.Round(time.Second), ev.Failures, ev.Error, source)
+}
+
+// notifyFailure sends the event to all configured notification channels
+// in the background
+func (c *configFile) notifyFailure(ev failureEvent) {
+	c.RLock()
+	n := c.Notifications
+	c.RUnlock()
+
+	if n.Slack != nil && n.Slack.WebhookURL != "" && c.slack != nil {
+		if !c.slackLimiter.Allow(n.MaxPerHour) {
+			c.log.logf(levelWarn, "Slack notification limit reached, dropping message for '%s'", ev.Path)
+		} else {
+			s := *n.Slack
+			c.slack.Enqueue(func() {
+				if err := s.Send(notificationText(ev)); err != nil {
+					c.log.logf(levelWarn, "Could not send Slack notification for '%s': %s", ev.Path, sanitizeText(err.Error()))
+				}
+			}, "message for '%s'", ev.Path)
+		}
+	}
+
+	if n.Email != nil && c.emails != nil {
+		c.emails.Add(ev, n.Email.BatchWindow)
+	}
+}
+
+// emailConfig returns the current email configuration for batches sent
+// after a reload
+func (c *configFile) emailConfig() *emailConfig {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.Notifications.Email
+}
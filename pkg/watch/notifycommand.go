@@ -0,0 +1,146 @@
+package watch
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultNotifyCommandTimeout = 30 * time.Second
+
+	notifyEventChanged   = "changed"
+	notifyEventFailed    = "failed"
+	notifyEventRecovered = "recovered"
+	notifyEventRemoved   = "removed"
+
+	notifyEventMirrorFailed = "mirror_failed"
+)
+
+// notifyEvent is the JSON document passed on stdin to the notify_command
+type notifyEvent struct {
+	Event     string    `json:"event"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	URL       string    `json:"url"`
+	SHA256    string    `json:"sha256,omitempty"`
+	OldSHA256 string    `json:"old_sha256,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Failures  int       `json:"failures,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type notifyCommandJob struct {
+	// ctx aborts the command on shutdown
+	ctx     context.Context
+	argv    []string
+	command string
+	timeout time.Duration
+	event   notifyEvent
+	env     []string
+}
+
+// commandNotifier runs the notify_command for every event in the
+// background, events for the same command are delivered serially
+type commandNotifier struct {
+	mu     sync.Mutex
+	queues map[string]*notifyQueue
+	closed bool
+	log    *logger
+}
+
+func newCommandNotifier(l *logger) *commandNotifier {
+	return &commandNotifier{queues: map[string]*notifyQueue{}, log: l}
+}
+
+// Enqueue schedules the event for the command and drops it if the queue
+// of the command is full
+func (n *commandNotifier) Enqueue(job notifyCommandJob) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	q, ok := n.queues[job.command]
+	if !ok {
+		q = newNotifyQueue(n.log, "Notify command")
+		n.queues[job.command] = q
+	}
+	n.mu.Unlock()
+
+	q.Enqueue(func() {
+		if err := job.execute(); err != nil {
+			n.log.logf(levelWarn, "Could not execute notify-command for %s event of '%s': %s", job.event.Event, job.event.Path, err)
+		}
+	}, "%s event for '%s'", job.event.Event, job.event.Path)
+}
+
+// Close stops the queues of all commands, later events are dropped
+func (n *commandNotifier) Close() {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, q := range n.queues {
+		q.Close()
+	}
+	n.closed = true
+}
+
+func (j notifyCommandJob) execute() error {
+	body, err := json.Marshal(j.event)
+	if err != nil {
+		return err
+	}
+
+	timeout := j.timeout
+	if timeout <= 0 {
+		timeout = defaultNotifyCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(j.ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, j.argv[0], j.argv[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = j.env
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return ctx.Err()
+	}
+	return err
+}
+
+// notifyCommand queues the event for the notify_command if one is
+// configured
+func (c *configFile) notifyCommand(ev notifyEvent) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.NotifyCommand == "" || c.notifiers == nil {
+		return
+	}
+
+	ev.Host = c.hostname
+	ev.Timestamp = time.Now()
+	ev.URL = c.log.mask(sanitizeURL(ev.URL))
+	ev.Error = c.log.mask(sanitizeText(ev.Error))
+	c.notifiers.Enqueue(notifyCommandJob{
+		ctx:     c.rootContext(),
+		argv:    c.commandLine(nil, c.NotifyCommand),
+		command: c.NotifyCommand,
+		timeout: c.NotifyCommandTimeout,
+		event:   ev,
+		env: c.commandEnv(nil,
+			"DW_NOTIFY_EVENT="+ev.Event,
+			"DW_PATH="+ev.Path,
+		),
+	})
+}
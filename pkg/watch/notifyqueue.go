@@ -0,0 +1,73 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+const notifyQueueSize = 100
+
+// notifyQueue delivers the notifications of one channel one after
+// another in the background so slow or failing endpoints never delay
+// downloads. While the queue is full further notifications are dropped.
+type notifyQueue struct {
+	name string
+	log  *logger
+
+	mu         sync.Mutex
+	deliveries chan func()
+}
+
+func newNotifyQueue(l *logger, name string) *notifyQueue {
+	q := &notifyQueue{name: name, deliveries: make(chan func(), notifyQueueSize), log: l}
+	go deliverNotifications(q.deliveries)
+	return q
+}
+
+func deliverNotifications(deliveries chan func()) {
+	for deliver := range deliveries {
+		deliver()
+	}
+}
+
+// Enqueue schedules the delivery and drops it if the queue is full or
+// closed, format and args describe the dropped notification in the log
+func (q *notifyQueue) Enqueue(deliver func(), format string, args ...interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.deliveries == nil {
+		q.log.debug("%s queue is closed, dropping %s", q.name, fmt.Sprintf(format, args...))
+		return
+	}
+
+	select {
+	case q.deliveries <- deliver:
+	default:
+		q.log.logf(levelWarn, "%s queue is full, dropping %s", q.name, fmt.Sprintf(format, args...))
+	}
+}
+
+// Close lets the goroutine exit once it delivered the queued
+// notifications
+func (q *notifyQueue) Close() {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.deliveries != nil {
+		close(q.deliveries)
+		q.deliveries = nil
+	}
+}
+
+// hostname is reported as host in all notifications, a Watcher looks it
+// up once
+func hostname() string {
+	host, _ := os.Hostname()
+	return host
+}
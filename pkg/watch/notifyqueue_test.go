@@ -0,0 +1,76 @@
+package watch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyQueueDeliversInOrderAndDropsWhenFull(t *testing.T) {
+	logs := captureLog(t)
+	q := newNotifyQueue(nil, "Test")
+
+	release := make(chan struct{})
+	delivered := make(chan int, notifyQueueSize+1)
+	q.Enqueue(func() { <-release }, "blocking notification")
+	// Wait until the blocking delivery left the queue
+	for len(q.deliveries) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i <= notifyQueueSize; i++ {
+		i := i
+		q.Enqueue(func() { delivered <- i }, "notification %d", i)
+	}
+	close(release)
+
+	for want := 0; want < notifyQueueSize; want++ {
+		select {
+		case got := <-delivered:
+			if got != want {
+				t.Fatalf("delivered notification %d, want %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notification %d was not delivered", want)
+		}
+	}
+
+	if want := "Test queue is full, dropping notification 100"; !strings.Contains(logs.String(), want) {
+		t.Errorf("log does not contain %q:\n%s", want, logs.String())
+	}
+}
+
+func TestNotifyQueueDeliversQueuedAfterClose(t *testing.T) {
+	q := newNotifyQueue(nil, "Test")
+
+	delivered := make(chan struct{})
+	q.Enqueue(func() { close(delivered) }, "queued notification")
+	q.Close()
+	// Dropped instead of panicking on the closed channel
+	q.Enqueue(func() { t.Error("notification delivered after Close") }, "late notification")
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification queued before Close was not delivered")
+	}
+}
+
+func TestShutdownClosesNotifyQueues(t *testing.T) {
+	w := newTestWatcher(t, "files: {}\n")
+	if w.config.hostname != hostname() {
+		t.Errorf("watcher reports host %q, want %q", w.config.hostname, hostname())
+	}
+
+	cancel, done := runWatcher(w)
+	cancel()
+	expectReturned(t, done)
+
+	for name, q := range map[string]*notifyQueue{"webhook": w.config.webhooks, "slack": w.config.slack, "email": w.config.emails.queue} {
+		q.mu.Lock()
+		if q.deliveries != nil {
+			t.Errorf("%s queue still open after Run returned", name)
+		}
+		q.mu.Unlock()
+	}
+}
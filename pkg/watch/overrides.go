@@ -0,0 +1,287 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// overrideComment marks the values set on the commandline in the output
+// of PrintConfig
+const overrideComment = "set by --set"
+
+// configOverride replaces the value at a dotted path of the
+// configuration, e.g. files./etc/app/x.json.fetch_interval=30s
+type configOverride struct {
+	spec  string
+	path  string
+	value *yamlv3.Node
+}
+
+// parseOverrides parses the "<dotted path>=<YAML value>" overrides of
+// Options
+func parseOverrides(specs []string) ([]configOverride, error) {
+	var res []configOverride
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid override %q, use <path>=<value>", spec)
+		}
+
+		value, err := overrideValue(spec[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value of override %q: %s", spec, err)
+		}
+		res = append(res, configOverride{spec: spec, path: spec[:i], value: value})
+	}
+
+	return res, nil
+}
+
+func overrideValue(raw string) (*yamlv3.Node, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(raw), &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!null"}, nil
+	}
+	return doc.Content[0], nil
+}
+
+// PrintConfig prints the configuration file with the overrides applied,
+// the overridden values are marked with a comment. Encrypted values are
+// printed as they are in the file.
+func PrintConfig(configPath string, opts Options) error {
+	raw, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := parseOverrides(opts.Overrides)
+	if err != nil {
+		return err
+	}
+
+	out, err := applyOverrides(raw, overrides, true)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(out))
+	return nil
+}
+
+// applyOverrides sets the values of the overrides in the YAML document,
+// with mark they get a comment naming their source
+func applyOverrides(raw []byte, overrides []configOverride, mark bool) ([]byte, error) {
+	if len(overrides) == 0 {
+		return raw, nil
+	}
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yamlv3.Node{Kind: yamlv3.DocumentNode, Content: []*yamlv3.Node{{Kind: yamlv3.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("Config is no mapping")
+	}
+
+	for _, o := range overrides {
+		if err := o.apply(doc.Content[0], mark); err != nil {
+			return nil, fmt.Errorf("Override %q: %s", o.spec, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	enc.Close()
+	return buf.Bytes(), nil
+}
+
+// apply resolves the path against the configuration types and the keys
+// present in the document and replaces the value at its end
+func (o configOverride) apply(root *yamlv3.Node, mark bool) error {
+	var (
+		node   = root
+		t      = reflect.TypeOf(configFile{})
+		rest   = o.path
+		walked []string
+	)
+
+	for {
+		// The type is checked before descending, so this is a struct or
+		// a map
+		var key string
+		switch {
+		case t.Kind() == reflect.Struct && !isYAMLLeaf(t):
+			key = rest
+			if i := strings.Index(rest, "."); i >= 0 {
+				key = rest[:i]
+			}
+			field, ok := yamlField(t, key)
+			if !ok {
+				return fmt.Errorf("Unknown option %q in %s, known are: %s", key, overridePlace(walked), strings.Join(yamlFields(t), ", "))
+			}
+			t = field
+
+		case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+			t = t.Elem()
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() != reflect.Struct || isYAMLLeaf(t) {
+				// Keys of simple maps like command_env may contain dots
+				key = rest
+				break
+			}
+			key = longestKey(node, rest)
+			if key == "" {
+				return fmt.Errorf("No entry of %s matches %q", overridePlace(walked), rest)
+			}
+		}
+
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		walked = append(walked, key)
+		rest = strings.TrimPrefix(strings.TrimPrefix(rest, key), ".")
+
+		if rest == "" {
+			if err := checkOverrideType(o.value, t); err != nil {
+				return fmt.Errorf("Invalid value for %s: %s", overridePlace(walked), err)
+			}
+			value := o.value
+			if mark {
+				copied := *value
+				copied.LineComment = overrideComment
+				value = &copied
+			}
+			setMappingValue(node, key, value)
+			return nil
+		}
+
+		if k := t.Kind(); (k != reflect.Struct || isYAMLLeaf(t)) && k != reflect.Map {
+			return fmt.Errorf("%s has no options", overridePlace(walked))
+		}
+		node = childMapping(node, key)
+		if node == nil {
+			return fmt.Errorf("%s is no mapping in the config", overridePlace(walked))
+		}
+	}
+}
+
+// checkOverrideType decodes the value into the Go type of the option to
+// report type mismatches before the whole configuration is parsed
+func checkOverrideType(value *yamlv3.Node, t reflect.Type) error {
+	raw, err := yamlv3.Marshal(value)
+	if err != nil {
+		return err
+	}
+	err = yaml.Unmarshal(raw, reflect.New(t).Interface())
+	if te, ok := err.(*yaml.TypeError); ok && len(te.Errors) > 0 {
+		return errors.New(strings.TrimPrefix(te.Errors[0], "line 1: "))
+	}
+	return err
+}
+
+// isYAMLLeaf reports whether the type decodes itself, like byteSize
+func isYAMLLeaf(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem())
+}
+
+func yamlField(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0] == name && name != "" {
+			return t.Field(i).Type, true
+		}
+	}
+	return nil, false
+}
+
+func yamlFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]; name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func overridePlace(walked []string) string {
+	if len(walked) == 0 {
+		return "the top level"
+	}
+	return "'" + strings.Join(walked, ".") + "'"
+}
+
+// longestKey returns the longest key of the mapping which is the path or
+// a prefix of it followed by a dot, paths like /etc/app/x.json contain
+// dots themselves
+func longestKey(m *yamlv3.Node, path string) string {
+	var key string
+	if m == nil || m.Kind != yamlv3.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		k := m.Content[i].Value
+		if (path == k || strings.HasPrefix(path, k+".")) && len(k) > len(key) {
+			key = k
+		}
+	}
+	return key
+}
+
+// childMapping returns the mapping below the key to descend into. It is
+// created if missing, aliases are replaced by a copy so the anchored
+// node stays unchanged.
+func childMapping(m *yamlv3.Node, key string) *yamlv3.Node {
+	child := mappingValue(m, key)
+	switch {
+	case child == nil || (child.Kind == yamlv3.ScalarNode && child.Tag == "!!null"):
+		child = &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		setMappingValue(m, key, child)
+	case child.Kind == yamlv3.AliasNode:
+		child = copyNode(child.Alias)
+		child.Anchor = ""
+		setMappingValue(m, key, child)
+	}
+
+	if child.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	child.Style = 0
+	return child
+}
+
+func setMappingValue(m *yamlv3.Node, key string, value *yamlv3.Node) {
+	if i := mappingIndex(m, key); i >= 0 {
+		m.Content[i+1] = value
+		return
+	}
+	m.Content = append(m.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func copyNode(n *yamlv3.Node) *yamlv3.Node {
+	res := *n
+	res.Content = make([]*yamlv3.Node, len(n.Content))
+	for i, c := range n.Content {
+		res.Content[i] = copyNode(c)
+	}
+	return &res
+}
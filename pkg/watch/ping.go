@@ -0,0 +1,99 @@
+package watch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const pingTimeout = 10 * time.Second
+
+// PingStatus is the result of the last request to the ping_url
+type PingStatus struct {
+	Time       time.Time `json:"time"`
+	Failure    bool      `json:"failure"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func validatePingURL(pingURL string) error {
+	if pingURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(pingURL)
+	if err != nil {
+		return fmt.Errorf("Invalid ping_url: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Invalid ping_url scheme %q, use http:// or https://", u.Scheme)
+	}
+	return nil
+}
+
+// failPingURL appends /fail to the path of the ping_url, the query string
+// is kept
+func failPingURL(pingURL string) string {
+	u, err := url.Parse(pingURL)
+	if err != nil {
+		return strings.TrimSuffix(pingURL, "/") + "/fail"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/fail"
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimSuffix(u.RawPath, "/") + "/fail"
+	}
+	return u.String()
+}
+
+func (c *configFileSource) setPingStatus(s PingStatus) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.ping = &s
+}
+
+func (c *configFileSource) getPingStatus() *PingStatus {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.ping == nil {
+		return nil
+	}
+	s := *c.ping
+	return &s
+}
+
+// sendPing requests the ping_url of the entry in the background after a
+// fetch cycle, failed cycles are only reported with ping_on_failure
+func (c *configFileSource) sendPing(targetPath string, failed bool) {
+	if c.PingURL == "" || (failed && !c.PingOnFailure) {
+		return
+	}
+
+	pingURL := c.PingURL
+	if failed {
+		pingURL = failPingURL(pingURL)
+	}
+
+	go func() {
+		status := PingStatus{Time: time.Now(), Failure: failed}
+
+		res, err := (&http.Client{Timeout: pingTimeout}).Get(pingURL)
+		if err == nil {
+			res.Body.Close()
+			status.StatusCode = res.StatusCode
+			if res.StatusCode < 200 || res.StatusCode >= 300 {
+				err = fmt.Errorf("Got status code %d", res.StatusCode)
+			}
+		}
+
+		if err != nil {
+			err = c.sanitizeError(err)
+			status.Error = err.Error()
+			c.log.debug("Could not ping for '%s': %s", targetPath, err)
+		}
+		c.setPingStatus(status)
+	}()
+}
@@ -0,0 +1,17 @@
+package watch
+
+import "testing"
+
+func TestFailPingURL(t *testing.T) {
+	for pingURL, want := range map[string]string{
+		"https://hc-ping.com/uuid":               "https://hc-ping.com/uuid/fail",
+		"https://hc-ping.com/uuid/":              "https://hc-ping.com/uuid/fail",
+		"https://hc-ping.com/uuid?rid=1&m=a%20b": "https://hc-ping.com/uuid/fail?rid=1&m=a%20b",
+		"https://example.com/a%2Fb?x=1":          "https://example.com/a%2Fb/fail?x=1",
+		"https://example.com":                    "https://example.com/fail",
+	} {
+		if got := failPingURL(pingURL); got != want {
+			t.Errorf("failPingURL(%q) = %q, want %q", pingURL, got, want)
+		}
+	}
+}
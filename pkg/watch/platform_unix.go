@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package watch
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+var defaultCommandShell = []string{"/bin/bash", "-c"}
+
+// replaceFile atomically moves src over dst
+func replaceFile(src, dst string) error {
+	return os.Rename(src, dst)
+}
+
+// listenUnix creates the unix socket with the mode right away: the umask
+// is narrowed while binding so no other user can connect before the
+// permissions are set. Files created by other goroutines meanwhile only
+// get stricter permissions.
+func listenUnix(socketPath string, mode os.FileMode) (net.Listener, error) {
+	old := syscall.Umask(int(^mode & 0777))
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", socketPath)
+}
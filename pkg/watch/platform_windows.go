@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+package watch
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	errorSharingViolation syscall.Errno = 32
+
+	replaceRetries    = 10
+	replaceRetryDelay = 200 * time.Millisecond
+)
+
+var defaultCommandShell = []string{"cmd", "/C"}
+
+// replaceFile moves src over dst. os.Rename uses MoveFileEx with
+// MOVEFILE_REPLACE_EXISTING but fails while another process has dst
+// open without FILE_SHARE_DELETE, so sharing violations are retried.
+func replaceFile(src, dst string) error {
+	var err error
+	for i := 0; i < replaceRetries; i++ {
+		if err = os.Rename(src, dst); err == nil || !isSharingViolation(err) {
+			return err
+		}
+		time.Sleep(replaceRetryDelay)
+	}
+	return err
+}
+
+func isSharingViolation(err error) bool {
+	if le, ok := err.(*os.LinkError); ok {
+		err = le.Err
+	}
+	return err == errorSharingViolation || err == syscall.ERROR_ACCESS_DENIED
+}
+
+// listenUnix creates the unix socket, access is controlled by the ACL of
+// its directory as Windows ignores the mode
+func listenUnix(socketPath string, mode os.FileMode) (net.Listener, error) {
+	return net.Listen("unix", socketPath)
+}
@@ -0,0 +1,201 @@
+package watch
+
+import (
+	"sync"
+)
+
+const (
+	defaultMaxConcurrentDownloads = 8
+)
+
+const (
+	poolStateQueued      = "queued"
+	poolStateWaitForHost = "waiting for host slot"
+	poolStateRunning     = "running"
+)
+
+type poolJob struct {
+	// Key identifies the job, only one job per key can be pending
+	Key string
+	// Host is used to limit the number of concurrent jobs per host
+	Host string
+	// Run executes the job inside a worker
+	Run func()
+	// Done is called after Run once the job is no longer pending
+	Done func()
+}
+
+// downloadPool executes queued jobs with a limited number of concurrent
+// workers and a limited number of concurrent jobs per host. Workers are
+// started on demand so the limits can be changed at any time.
+type downloadPool struct {
+	mu sync.Mutex
+	wg sync.WaitGroup
+
+	size          int
+	perHost       int
+	perHostByName map[string]int
+
+	queue      []poolJob
+	pending    map[string]string
+	active     int
+	activeHost map[string]int
+	closed     bool
+}
+
+func newDownloadPool(size int) *downloadPool {
+	p := &downloadPool{
+		pending:    make(map[string]string),
+		activeHost: make(map[string]int),
+	}
+	p.SetSize(size)
+	return p
+}
+
+// SetHostLimits changes the amount of concurrent jobs per host, 0 means
+// no limit. Overrides are keyed by the hostname.
+func (p *downloadPool) SetHostLimits(perHost int, overrides map[string]int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.perHost = perHost
+	p.perHostByName = overrides
+	p.dispatch()
+}
+
+func (p *downloadPool) hostLimit(host string) int {
+	if l, ok := p.perHostByName[host]; ok {
+		return l
+	}
+	return p.perHost
+}
+
+func (p *downloadPool) hostAvailable(host string) bool {
+	l := p.hostLimit(host)
+	return l <= 0 || p.activeHost[host] < l
+}
+
+// SetSize changes the amount of concurrent workers, running jobs are
+// not interrupted when the pool shrinks
+func (p *downloadPool) SetSize(size int) {
+	if size <= 0 {
+		size = defaultMaxConcurrentDownloads
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.size = size
+	p.dispatch()
+}
+
+// Enqueue adds the job to the queue and returns false if a job with the
+// same key is already queued or running
+func (p *downloadPool) Enqueue(job poolJob) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || p.pending[job.Key] != "" {
+		return false
+	}
+
+	p.pending[job.Key] = poolStateQueued
+	p.queue = append(p.queue, job)
+	p.dispatch()
+
+	return true
+}
+
+// IsPending reports whether a job with the given key is queued or running
+func (p *downloadPool) IsPending(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.pending[key] != ""
+}
+
+// State returns the state of the job with the given key or an empty
+// string if there is no such job
+func (p *downloadPool) State(key string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.pending[key]
+}
+
+// Size returns how many jobs run at the same time
+func (p *downloadPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.size
+}
+
+// Stats returns the amount of queued and running jobs
+func (p *downloadPool) Stats() (queued, active int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.queue), p.active
+}
+
+// Close drops all queued jobs and waits for the running ones to finish
+func (p *downloadPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	for _, job := range p.queue {
+		delete(p.pending, job.Key)
+	}
+	p.queue = nil
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// dispatch starts workers for queued jobs while there are free slots,
+// jobs for hosts without a free slot stay queued while jobs for other
+// hosts proceed. Needs to be called with the lock held.
+func (p *downloadPool) dispatch() {
+	var waiting []poolJob
+
+	for i, job := range p.queue {
+		if p.active >= p.size {
+			waiting = append(waiting, p.queue[i:]...)
+			break
+		}
+
+		if !p.hostAvailable(job.Host) {
+			p.pending[job.Key] = poolStateWaitForHost
+			waiting = append(waiting, job)
+			continue
+		}
+
+		p.pending[job.Key] = poolStateRunning
+		p.active++
+		p.activeHost[job.Host]++
+		p.wg.Add(1)
+
+		go p.run(job)
+	}
+
+	p.queue = waiting
+}
+
+func (p *downloadPool) run(job poolJob) {
+	defer p.wg.Done()
+
+	job.Run()
+
+	p.mu.Lock()
+	p.active--
+	if p.activeHost[job.Host]--; p.activeHost[job.Host] <= 0 {
+		delete(p.activeHost, job.Host)
+	}
+	delete(p.pending, job.Key)
+	p.dispatch()
+	p.mu.Unlock()
+
+	if job.Done != nil {
+		job.Done()
+	}
+}
@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package watch
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocateFile reserves size bytes on disk for the file so a lack of
+// space is reported before any data is written
+func preallocateFile(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		// Filesystem does not support it, just write the file as usual
+		return nil
+	}
+	return err
+}
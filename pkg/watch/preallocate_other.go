@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package watch
+
+import "os"
+
+// preallocateFile is a no-op on platforms without fallocate, the file
+// is written incrementally
+func preallocateFile(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,115 @@
+package watch
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// privateGuard refuses connections to private, loopback and link-local
+// addresses, only the hosts of allow_private_hosts may use them
+type privateGuard struct {
+	allowed urlAllowlist
+}
+
+func (c *configFile) privateGuard() *privateGuard {
+	if !c.BlockPrivateAddresses {
+		return nil
+	}
+	return &privateGuard{allowed: urlAllowlist{hosts: c.AllowPrivateHosts}}
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// dialer returns a copy of the dialer checking the address it connects
+// to for the host:port. The check runs after the name was resolved, right
+// before the connection is made, so a changed DNS answer can't bypass it.
+func (g *privateGuard) dialer(d *net.Dialer, hostPort string) *net.Dialer {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = hostPort
+	}
+	if len(g.allowed.hosts) > 0 && g.allowed.hostAllowed(host) {
+		return d
+	}
+
+	guarded := *d
+	guarded.Control = func(network, address string, _ syscall.RawConn) error {
+		ip, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if addr := net.ParseIP(ip); addr == nil || isPrivateIP(addr) {
+			return securityError{fmt.Errorf("Refusing to connect to %s for %s: private address (block_private_addresses)", address, host)}
+		}
+		return nil
+	}
+	return &guarded
+}
+
+// checkTarget refuses the host of the URL if it resolves to a private
+// address. A proxy resolves and connects to the host on its own, the
+// dialer only sees the address of the proxy.
+func (g *privateGuard) checkTarget(ctx context.Context, u *url.URL, resolve map[string]string) error {
+	host := u.Hostname()
+	if len(g.allowed.hosts) > 0 && g.allowed.hostAllowed(host) {
+		return nil
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = u.Scheme
+	}
+	target, _, err := net.SplitHostPort(resolveOverride(nil, resolve, net.JoinHostPort(host, port)))
+	if err != nil {
+		return err
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(target); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target)
+		if err != nil {
+			return securityError{fmt.Errorf("Refusing to fetch %s through the proxy: could not resolve it to check for private addresses (block_private_addresses): %s", host, err)}
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return securityError{fmt.Errorf("Refusing to fetch %s through the proxy: private address %s (block_private_addresses)", host, ip)}
+		}
+	}
+	return nil
+}
+
+// privateProxyTransport checks the target host of the requests sent
+// through a proxy
+type privateProxyTransport struct {
+	http.RoundTripper
+	guard   *privateGuard
+	proxy   func(*http.Request) (*url.URL, error)
+	resolve map[string]string
+}
+
+func (t privateProxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if proxy, err := t.proxy(req); err == nil && proxy != nil {
+		if err := t.guard.checkTarget(req.Context(), req.URL, t.resolve); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
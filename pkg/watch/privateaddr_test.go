@@ -0,0 +1,47 @@
+package watch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestBlockPrivateAddressesThroughProxy(t *testing.T) {
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		fmt.Fprint(w, "content")
+	}))
+	defer proxy.Close()
+
+	dir := t.TempDir()
+	private, public := filepath.Join(dir, "private"), filepath.Join(dir, "public")
+	// The proxy itself listens on loopback and has to be allowed
+	w := newTestWatcher(t, fmt.Sprintf(`block_private_addresses: true
+allow_private_hosts: ["127.0.0.1"]
+proxy: %s
+files:
+  %s:
+    url: http://10.1.2.3/file
+  %s:
+    url: http://93.184.216.34/file
+`, proxy.URL, private, public))
+
+	err := w.config.runFetch(context.Background(), private, w.lookup(private), false)
+	if err == nil || !strings.Contains(err.Error(), "block_private_addresses") || !isSecurityError(err) {
+		t.Errorf("fetch of a private address through the proxy returned %v", err)
+	}
+	if n := atomic.LoadInt32(&proxied); n != 0 {
+		t.Errorf("proxy got %d requests for the private address", n)
+	}
+
+	if err := w.config.runFetch(context.Background(), public, w.lookup(public), false); err != nil {
+		t.Errorf("fetch of a public address through the proxy: %s", err)
+	}
+}
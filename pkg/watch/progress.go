@@ -0,0 +1,78 @@
+package watch
+
+import (
+	"io"
+	"sync"
+)
+
+// DownloadProgress is the amount of bytes received by the running fetch
+// of an entry
+type DownloadProgress struct {
+	Bytes int64 `json:"bytes"`
+	// Total is the sum of the Content-Length of the responses, it is
+	// zero if one of them had none
+	Total int64 `json:"total,omitempty"`
+}
+
+// downloadProgress tracks the bodies read by the running fetch, a fetch
+// reading several bodies (parts, mirrored directories) adds them up
+type downloadProgress struct {
+	mu      sync.Mutex
+	bytes   int64
+	total   int64
+	unknown bool
+	active  bool
+}
+
+// reset starts tracking a new fetch
+func (p *downloadProgress) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.bytes, p.total, p.unknown, p.active = 0, 0, false, false
+}
+
+// track adds the body with its length (-1 if unknown) to the progress
+// and returns a reader counting the bytes read from it
+func (p *downloadProgress) track(body io.Reader, length int64) io.Reader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active = true
+	if length < 0 {
+		p.unknown = true
+	} else {
+		p.total += length
+	}
+	return &progressReader{r: body, p: p}
+}
+
+// get returns the progress or nil if no body was read yet
+func (p *downloadProgress) get() *DownloadProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.active {
+		return nil
+	}
+	res := &DownloadProgress{Bytes: p.bytes}
+	if !p.unknown {
+		res.Total = p.total
+	}
+	return res
+}
+
+type progressReader struct {
+	r io.Reader
+	p *downloadProgress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.p.mu.Lock()
+		r.p.bytes += int64(n)
+		r.p.mu.Unlock()
+	}
+	return n, err
+}
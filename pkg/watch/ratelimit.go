@@ -0,0 +1,138 @@
+package watch
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// Reads through a rate limited reader are split into chunks of at
+	// most this fraction of the rate to keep the throughput smooth
+	rateLimitChunkFraction = 10
+	rateLimitMinChunk      = 1024
+)
+
+// rateLimiter is a token bucket measured in bytes. Tokens are reserved
+// before waiting for them so concurrent users are served in order of
+// their requests instead of the fastest reader taking everything.
+type rateLimiter struct {
+	mu sync.Mutex
+
+	rate   float64 // bytes per second, 0 means unlimited
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate byteSize) *rateLimiter {
+	l := &rateLimiter{}
+	l.SetRate(rate)
+	return l
+}
+
+func (l *rateLimiter) burst() float64 {
+	b := l.rate / rateLimitChunkFraction
+	if b < rateLimitMinChunk {
+		b = rateLimitMinChunk
+	}
+	return b
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if b := l.burst(); l.tokens > b {
+		l.tokens = b
+	}
+	l.last = now
+}
+
+// SetRate changes the rate of the limiter. Pending reservations are
+// kept so readers currently waiting are not disturbed.
+func (l *rateLimiter) SetRate(rate byteSize) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate > 0 {
+		l.refill(time.Now())
+	} else {
+		l.tokens = 0
+		l.last = time.Now()
+	}
+	l.rate = float64(rate)
+}
+
+// ChunkSize returns the maximum amount of bytes which should be read at
+// once, 0 means no limit
+func (l *rateLimiter) ChunkSize() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+	return int(l.burst())
+}
+
+// Wait blocks until n bytes may be transferred or the context is done
+func (l *rateLimiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	l.refill(now)
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedReader throttles reads from the underlying reader through
+// all given limiters
+type rateLimitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func newRateLimitedReader(ctx context.Context, r io.Reader, limiters ...*rateLimiter) io.Reader {
+	return &rateLimitedReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	for _, l := range r.limiters {
+		if c := l.ChunkSize(); c > 0 && len(p) > c {
+			p = p[:c]
+		}
+	}
+
+	n, err := r.r.Read(p)
+	for _, l := range r.limiters {
+		if werr := l.Wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,141 @@
+package watch
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxVersionLength limits the json_path value used as version
+const maxVersionLength = 256
+
+func (c *configFileSource) validateRejectOlder() error {
+	if c.VersionHeader != "" && !c.RejectOlder {
+		return errors.New("version_header needs reject_older")
+	}
+	if c.RejectOlder && c.appendMode() {
+		return errors.New("reject_older can't be used with mode append")
+	}
+	return nil
+}
+
+// upstreamVersion returns the version of the downloaded content: the
+// version_header of the response or the value selected by json_path. It
+// is empty if the entry has no such version.
+func (c *configFileSource) upstreamVersion(val responseValidators, installPath string) string {
+	switch {
+	case c.VersionHeader != "":
+		return val.Version
+	case c.JSONPath != "":
+		raw, err := ioutil.ReadFile(installPath)
+		if err != nil || len(raw) > maxVersionLength {
+			return ""
+		}
+		return strings.TrimSpace(string(raw))
+	}
+	return ""
+}
+
+// isOlder reports whether the downloaded content is strictly older than
+// the installed one. Versions are compared if both are known, otherwise
+// the Last-Modified headers. Without both values nothing is rejected.
+func (c *configFileSource) isOlder(version, lastModified string) (bool, string, string) {
+	run := c.snapshot()
+
+	if version != "" && run.lastVersion != "" {
+		return compareVersions(version, run.lastVersion) < 0, version, run.lastVersion
+	}
+
+	if lastModified == "" || run.lastModified == "" {
+		return false, "", ""
+	}
+	got, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false, "", ""
+	}
+	installed, err := http.ParseTime(run.lastModified)
+	if err != nil {
+		return false, "", ""
+	}
+	return got.Before(installed), lastModified, run.lastModified
+}
+
+// rejectOlder checks the download against the installed content and
+// reports whether it has to be skipped, a forced fetch is never skipped
+func (c *configFileSource) rejectOlder(targetPath, version string, val responseValidators) bool {
+	if !c.RejectOlder {
+		return false
+	}
+
+	older, got, installed := c.isOlder(version, val.LastModified)
+	if !older {
+		return false
+	}
+
+	if c.isForced() {
+		c.log.logf(levelWarn, "WARNING: Installing older content of '%s' (%s, installed %s) as the fetch was forced",
+			targetPath, got, installed)
+		return false
+	}
+
+	c.log.logf(levelWarn, "WARNING: Refusing to install '%s': upstream content %s is older than the installed %s (reject_older)",
+		targetPath, got, installed)
+	return true
+}
+
+// compareVersions compares runs of digits numerically and all other
+// characters lexically, so 1.10 is newer than 1.9
+func compareVersions(a, b string) int {
+	as, bs := versionParts(a), versionParts(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil && an == bn:
+			continue
+		case aErr == nil && bErr == nil && an < bn:
+			return -1
+		case aErr == nil && bErr == nil:
+			return 1
+		case as[i] < bs[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+func versionParts(v string) []string {
+	var (
+		parts []string
+		cur   strings.Builder
+		digit bool
+	)
+	for _, r := range v {
+		if d := unicode.IsDigit(r); cur.Len() > 0 && d != digit {
+			parts = append(parts, cur.String())
+			cur.Reset()
+		}
+		digit = unicode.IsDigit(r)
+		cur.WriteRune(r)
+	}
+	if cur.Len() > 0 {
+		parts = append(parts, cur.String())
+	}
+	return parts
+}
@@ -0,0 +1,115 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (c *configFileSource) validateRequest() error {
+	switch c.method() {
+	case http.MethodGet:
+		if c.RequestBody != "" || c.RequestBodyFile != "" {
+			return errors.New("request_body and request_body_file need method POST, PUT or PATCH")
+		}
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		if c.CheckInterval > 0 {
+			return errors.New("check_interval needs method GET")
+		}
+	default:
+		return fmt.Errorf("Invalid method %q, use GET, POST, PUT or PATCH", c.Method)
+	}
+
+	if c.RequestBody != "" && c.RequestBodyFile != "" {
+		return errors.New("request_body and request_body_file can't be used together")
+	}
+	if c.ContentType != "" && c.RequestBody == "" && c.RequestBodyFile == "" {
+		return errors.New("content_type needs request_body or request_body_file")
+	}
+	return nil
+}
+
+// method returns the HTTP method to fetch the file with
+func (c *configFileSource) method() string {
+	if c.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(c.Method)
+}
+
+// idempotent reports whether repeating the request has no side effects
+// on the upstream
+func (c *configFileSource) idempotent() bool {
+	m := c.method()
+	return m == http.MethodGet || m == http.MethodPut
+}
+
+// conditionalRequests reports whether If-None-Match / If-Modified-Since
+// are sent, for other methods than GET only when enabled
+func (c *configFileSource) conditionalRequests() bool {
+	return c.method() == http.MethodGet || c.ConditionalRequests
+}
+
+// requestBody returns the body to send, the request_body_file is read
+// for every attempt to pick up changes
+func (c *configFileSource) requestBody() (io.Reader, error) {
+	switch {
+	case c.RequestBodyFile != "":
+		raw, err := ioutil.ReadFile(c.RequestBodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read request_body_file: %s", err)
+		}
+		return bytes.NewReader(raw), nil
+	case c.RequestBody != "":
+		return strings.NewReader(c.RequestBody), nil
+	}
+	return nil, nil
+}
+
+// newFetchRequest creates the request for the configured method and
+// body, bodies are sent with the content_type or as JSON
+func (c *configFileSource) newFetchRequest(fetchURL string) (*http.Request, error) {
+	body, err := c.requestBody()
+	if err != nil {
+		return nil, err
+	}
+
+	if body == nil {
+		return http.NewRequest(c.method(), fetchURL, nil)
+	}
+
+	req, err := http.NewRequest(c.method(), fetchURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := c.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
+}
+
+// nextNonIdempotentRun returns when a failed non-idempotent request may
+// be sent again: not before the next fetch_interval unless
+// retry_non_idempotent is set. The second return value is false if the
+// regular schedule applies.
+func (c *configFileSource) nextNonIdempotentRun() (time.Time, bool) {
+	if c.idempotent() || c.RetryNonIdempotent {
+		return time.Time{}, false
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.errorState.ConsecutiveFailures == 0 {
+		return time.Time{}, false
+	}
+	return c.lastAttempt.Add(c.FetchInterval), true
+}
@@ -0,0 +1,117 @@
+package watch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// runWatcher runs the Watcher until the returned cancel is called, the
+// returned channel is closed once Run returned
+func runWatcher(w *Watcher) (context.CancelFunc, <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	return cancel, done
+}
+
+// waitFor polls until the file exists
+func waitFor(t *testing.T, path string, timeout time.Duration) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+	}
+	t.Fatalf("%s was not created within %s", path, timeout)
+}
+
+func expectReturned(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after its context was cancelled")
+	}
+}
+
+func TestRunCancelDuringDownload(t *testing.T) {
+	srv, started, release := slowServer(t)
+	defer close(release)
+	target := filepath.Join(t.TempDir(), "file")
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s\n    fetch_interval: 1h\n", target, srv.URL))
+
+	cancel, done := runWatcher(w)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download was not started")
+	}
+	cancel()
+	expectReturned(t, done)
+
+	if _, err := os.Lstat(target); !os.IsNotExist(err) {
+		t.Errorf("aborted download was installed: %v", err)
+	}
+}
+
+func TestRunCancelDuringSuccessCommand(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	target, started, finished := filepath.Join(dir, "file"), filepath.Join(dir, "started"), filepath.Join(dir, "finished")
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s\n    fetch_interval: 1h\n    success_command: touch %s; sleep 1; touch %s\n",
+		target, srv.URL, started, finished))
+
+	cancel, done := runWatcher(w)
+	waitFor(t, started, 5*time.Second)
+	cancel()
+	expectReturned(t, done)
+
+	// The command would have finished by now if it was not killed
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(finished); !os.IsNotExist(err) {
+		t.Errorf("success_command was not killed: %v", err)
+	}
+}
+
+func TestRunStopsHookDelivery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	target := filepath.Join(t.TempDir(), "file")
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s\n    fetch_interval: 1h\n", target, srv.URL))
+	changed := make(chan struct{}, 1)
+	w.OnChange(func(ChangeEvent) { changed <- struct{}{} })
+
+	cancel, done := runWatcher(w)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnChange was not called")
+	}
+	cancel()
+	expectReturned(t, done)
+
+	w.config.hooks.mu.Lock()
+	defer w.config.hooks.mu.Unlock()
+	if w.config.hooks.queue != nil {
+		t.Error("hook delivery still running after Run returned")
+	}
+}
@@ -0,0 +1,163 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// runAsAccount is the account the daemon switches to with --run-as
+type runAsAccount struct {
+	Name   string
+	UID    int
+	GID    int
+	Groups []int
+}
+
+// lookupRunAs resolves a "user[:group]" specification, without a group
+// the primary group of the user is used
+func lookupRunAs(spec string) (*runAsAccount, error) {
+	parts := strings.SplitN(spec, ":", 2)
+
+	u, err := user.Lookup(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	acc := &runAsAccount{Name: spec}
+	if acc.UID, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, fmt.Errorf("Invalid uid %q for user %s", u.Uid, u.Username)
+	}
+
+	gid := u.Gid
+	if len(parts) == 2 {
+		g, err := user.LookupGroup(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		gid = g.Gid
+	}
+	if acc.GID, err = strconv.Atoi(gid); err != nil {
+		return nil, fmt.Errorf("Invalid gid %q", gid)
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list groups of %s: %s", u.Username, err)
+	}
+	acc.Groups = []int{acc.GID}
+	for _, g := range groupIDs {
+		id, err := strconv.Atoi(g)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid gid %q", g)
+		}
+		if id != acc.GID {
+			acc.Groups = append(acc.Groups, id)
+		}
+	}
+
+	return acc, nil
+}
+
+func (a *runAsAccount) inGroup(gid int) bool {
+	for _, g := range a.Groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// canWrite reports whether the account is able to write the file or
+// directory based on its owner and permission bits
+func (a *runAsAccount) canWrite(fi os.FileInfo) bool {
+	uid, gid, ok := fileOwner(fi)
+	if !ok {
+		return true
+	}
+
+	mode := fi.Mode().Perm()
+	switch {
+	case a.UID == 0:
+		return true
+	case uid == a.UID:
+		return mode&0200 != 0
+	case a.inGroup(gid):
+		return mode&0020 != 0
+	default:
+		return mode&0002 != 0
+	}
+}
+
+// prepareRunAs creates the parent directories of all targets and checks
+// the account will be able to replace the targets after dropping the
+// privileges
+func (c *configFile) prepareRunAs(acc *runAsAccount, stateFile string) error {
+	c.RLock()
+	defer c.RUnlock()
+
+	paths := []string{}
+	for filePath := range c.Files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+	if stateFile != "" {
+		paths = append(paths, stateFile)
+	}
+
+	for _, p := range paths {
+		dir := path.Dir(p)
+		if err := mkdirAllOwned(dir, acc); err != nil {
+			return fmt.Errorf("File '%s': %s", p, err)
+		}
+
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("File '%s': %s", p, err)
+		}
+		if !acc.canWrite(fi) {
+			return fmt.Errorf("File '%s': Directory %s is not writable by %s", p, dir, acc.Name)
+		}
+
+		fi, err = os.Stat(p)
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return fmt.Errorf("File '%s': %s", p, err)
+		}
+		if uid, _, ok := fileOwner(fi); ok && acc.UID != 0 && uid != acc.UID {
+			return fmt.Errorf("File '%s': Owned by uid %d, replacing it as %s would change its owner", p, uid, acc.Name)
+		}
+	}
+
+	return nil
+}
+
+// mkdirAllOwned creates the directory and its missing parents and hands
+// the created directories over to the account
+func mkdirAllOwned(dir string, acc *runAsAccount) error {
+	var missing []string
+	for d := dir; ; d = path.Dir(d) {
+		if _, err := os.Stat(d); err == nil || d == path.Dir(d) {
+			break
+		}
+		missing = append(missing, d)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, d := range missing {
+		if err := chownPath(d, acc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
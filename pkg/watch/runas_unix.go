@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+package watch
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func fileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+func chownPath(p string, acc *runAsAccount) error {
+	return os.Chown(p, acc.UID, acc.GID)
+}
+
+// dropPrivileges switches the process to the account. Groups are changed
+// first as the permission to do so is lost with the uid.
+func dropPrivileges(acc *runAsAccount) error {
+	if err := syscall.Setgroups(acc.Groups); err != nil {
+		return fmt.Errorf("Unable to set supplementary groups: %s", err)
+	}
+
+	if err := syscall.Setgid(acc.GID); err != nil {
+		return fmt.Errorf("Unable to set gid %d: %s", acc.GID, err)
+	}
+
+	if err := syscall.Setuid(acc.UID); err != nil {
+		return fmt.Errorf("Unable to set uid %d: %s", acc.UID, err)
+	}
+
+	if os.Getuid() != acc.UID || os.Geteuid() != acc.UID || os.Getgid() != acc.GID || os.Getegid() != acc.GID {
+		return fmt.Errorf("Privileges were not dropped to %s", acc.Name)
+	}
+
+	return nil
+}
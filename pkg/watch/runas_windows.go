@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package watch
+
+import (
+	"errors"
+	"os"
+)
+
+// fileOwner is not available on Windows, permissions are not checked
+func fileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+func chownPath(p string, acc *runAsAccount) error {
+	return nil
+}
+
+func dropPrivileges(acc *runAsAccount) error {
+	return errors.New("Switching the account is not supported on Windows, use the service account instead")
+}
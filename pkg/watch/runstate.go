@@ -0,0 +1,207 @@
+package watch
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// errRetired aborts the fetch of an entry which a reload removed or
+// pointed to another source
+var errRetired = errors.New("Entry was removed or its URL changed by a reload")
+
+// runState is the runtime state of an entry guarded by the runMu of its
+// configFileSource. Only the goroutine which claimed the entry with TryLock
+// changes it while the fetch runs and may read it without the mutex, all
+// other goroutines use snapshot. Maps are replaced, never changed in
+// place.
+type runState struct {
+	lastCall     time.Time
+	lastSeenETag string
+	lastModified string
+	lastSHA256   string
+	lastVersion  string
+	lastDownload time.Time
+	lastLength   int64
+	lastHost     string
+	lastCheck    time.Time
+	parts        map[string]partState
+	syncFiles    map[string]dirMirrorFile
+	// inProgress is when the running fetch started, it is zero while no
+	// fetch runs. deadline is when the fetch exceeds its timeout.
+	inProgress time.Time
+	deadline   time.Time
+	hangWarned bool
+	// headCheck is set when the current run only needs the HEAD
+	// pre-check, headUnsupported once the upstream can't answer it
+	headCheck       bool
+	headUnsupported bool
+	// missing is set after on_missing was applied to not act again
+	// until the file reappeared
+	missing bool
+	// ctx is the context of the running fetch, retire cancels it and
+	// marks the entry as retired
+	ctx     context.Context
+	cancel  context.CancelFunc
+	retired bool
+}
+
+// finish records a successful run, runMu must be held
+func (r *runState) finish(eTag, lastModified string) {
+	r.lastCall = time.Now()
+	r.lastSeenETag = eTag
+	r.lastModified = lastModified
+}
+
+// finishWith records a successful download and the validators of its
+// response, runMu must be held
+func (r *runState) finishWith(val responseValidators) {
+	r.lastLength = val.Length
+	r.lastHost = val.Host
+	r.finish(val.ETag, val.LastModified)
+}
+
+// snapshot returns a copy of the runtime state
+func (c *configFileSource) snapshot() runState {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	return c.runState
+}
+
+// TryLock marks the entry as being fetched and returns false if a fetch
+// of it is already running. The fetch uses a context derived from ctx,
+// headCheck tells it whether it only needs the HEAD pre-check. The fetch
+// has to call Unlock when it returns.
+func (c *configFileSource) TryLock(ctx context.Context, headCheck bool) bool {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	if !c.inProgress.IsZero() {
+		return false
+	}
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	if c.retired {
+		c.cancel()
+	}
+	c.inProgress = time.Now()
+	c.deadline = c.inProgress.Add(c.fetchTimeout())
+	c.hangWarned = false
+	c.headCheck = headCheck
+	c.progress.reset()
+	return true
+}
+
+func (c *configFileSource) Unlock() {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	c.inProgress = time.Time{}
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.ctx, c.cancel = nil, nil
+}
+
+// IsLocked reports whether a fetch of the entry is running
+func (c *configFileSource) IsLocked() bool {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	return !c.inProgress.IsZero()
+}
+
+// fetchTimeout returns the overall timeout of a fetch
+func (c *configFileSource) fetchTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return defaultFetchTimeout
+	}
+	return c.Timeout
+}
+
+// hangCheckAt returns when the running fetch is reported as hanging and
+// false if no fetch runs or it was reported already
+func (c *configFileSource) hangCheckAt() (time.Time, bool) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	if c.inProgress.IsZero() || c.hangWarned {
+		return time.Time{}, false
+	}
+	return c.deadline.Add(hangGrace), true
+}
+
+// checkHang warns once when the running fetch takes longer than its
+// timeout, e.g. blocked by a step which does not honor it
+func (c *configFileSource) checkHang(targetPath string) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	if c.inProgress.IsZero() || c.hangWarned || time.Now().Before(c.deadline.Add(hangGrace)) {
+		return
+	}
+
+	c.hangWarned = true
+	c.logf(levelWarn, "WARNING: Fetch of '%s' is running for %s, longer than its timeout of %s",
+		targetPath, time.Since(c.inProgress).Round(time.Second), c.fetchTimeout())
+}
+
+func (c *configFileSource) Finish(eTag, lastModified string) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	c.finish(eTag, lastModified)
+}
+
+// fetchContext returns the context of the running fetch, the steps of
+// the fetch derive their timeouts from it
+func (c *configFileSource) fetchContext() context.Context {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// retire cancels the running fetch of the entry after a reload removed
+// it or changed its source, the fetch neither installs its download nor
+// runs commands
+func (c *configFileSource) retire() {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	c.retired = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// isRetired reports whether retire was called
+func (c *configFileSource) isRetired() bool {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	return c.retired
+}
+
+// sameSource reports whether the entry fetches from the same URLs as in,
+// a running fetch survives a reload which changed other options
+func (c *configFileSource) sameSource(in *configFileSource) bool {
+	return c.URL == in.URL &&
+		c.URLs.Equals(in.URLs) &&
+		c.URLCommand == in.URLCommand &&
+		dirMirrorEqual(c.Mirror, in.Mirror)
+}
+
+// isCurrent reports whether the entry was not replaced or removed by a
+// reload since it was looked up
+func (c *configFile) isCurrent(filePath string, fc *configFileSource) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.Files[filePath] == fc
+}
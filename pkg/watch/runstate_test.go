@@ -0,0 +1,253 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestReloadDuringFetches reloads the configuration over and over while
+// the entries are fetched, run it with -race
+func TestReloadDuringFetches(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		fmt.Fprintf(w, "%s %d", r.URL.Path, time.Now().UnixNano())
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	paths := make([]string, 8)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("file%d", i))
+	}
+
+	// configs alternate between all entries and every second entry with
+	// a changed URL and interval
+	config := func(variant int) Config {
+		var b strings.Builder
+		b.WriteString("files:\n")
+		for i, p := range paths {
+			if variant == 1 && i%2 == 1 {
+				continue
+			}
+			fmt.Fprintf(&b, "  %s:\n    url: %s/%d/%d\n    fetch_interval: %ds\n", p, srv.URL, variant, i, variant+1)
+		}
+		return testConfig(t, b.String())
+	}
+
+	w, err := New(config(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for _, p := range paths {
+		p := p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if fc := w.lookup(p); fc != nil {
+					w.config.runFetch(ctx, p, fc, false)
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			w.Status()
+			w.Summary()
+			w.TriggerFetch("")
+		}
+	}()
+
+	for i := 0; i < 40; i++ {
+		if err := w.Reload(config(i % 2)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+
+	if err := w.Reload(config(0)); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range paths {
+		if err := w.config.runFetch(ctx, p, w.lookup(p), false); err != nil {
+			t.Fatalf("fetch of %s after the reloads: %s", p, err)
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("/0/%s ", strings.TrimPrefix(filepath.Base(p), "file")); !strings.HasPrefix(string(content), want) {
+			t.Errorf("%s contains %q, want the content of the final URL %q", p, content, want)
+		}
+	}
+}
+
+// slowServer answers with a partial body and finishes it once release
+// is closed, started receives a value for every request
+func slowServer(t *testing.T) (srv *httptest.Server, started chan struct{}, release chan struct{}) {
+	started, release = make(chan struct{}, 10), make(chan struct{})
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "8")
+		fmt.Fprint(w, "cont")
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		fmt.Fprint(w, "ent\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, started, release
+}
+
+func TestReloadRetiresRunningDownload(t *testing.T) {
+	for name, reloaded := range map[string]func(target, url, marker string) string{
+		"removed": func(string, string, string) string { return "files: {}\n" },
+		"url changed": func(target, url, marker string) string {
+			return fmt.Sprintf("files:\n  %s:\n    url: %s/other\n    success_command: touch %s\n", target, url, marker)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, started, release := slowServer(t)
+			dir := t.TempDir()
+			target, marker := filepath.Join(dir, "file"), filepath.Join(dir, "marker")
+
+			w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s/file\n    success_command: touch %s\n", target, srv.URL, marker))
+			fc := w.lookup(target)
+			res := make(chan error)
+			go func() { res <- w.config.runFetch(context.Background(), target, fc, false) }()
+
+			<-started
+			if err := w.Reload(testConfig(t, reloaded(target, srv.URL, marker))); err != nil {
+				t.Fatal(err)
+			}
+			close(release)
+
+			select {
+			case err := <-res:
+				if err == nil {
+					t.Error("retired download succeeded")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("retired download did not return")
+			}
+			if _, err := os.Lstat(target); !os.IsNotExist(err) {
+				t.Errorf("retired download was installed: %v", err)
+			}
+			// The success_command would run in the background
+			time.Sleep(200 * time.Millisecond)
+			if _, err := os.Lstat(marker); !os.IsNotExist(err) {
+				t.Errorf("success_command of the retired download ran: %v", err)
+			}
+		})
+	}
+}
+
+// fetcherFunc adapts a function to the Fetcher interface
+type fetcherFunc func(ctx context.Context, src Source, dest io.Writer, prev PrevState) (Result, error)
+
+func (f fetcherFunc) Fetch(ctx context.Context, src Source, dest io.Writer, prev PrevState) (Result, error) {
+	return f(ctx, src, dest, prev)
+}
+
+func TestTryLockWithoutTimeout(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "file")
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: http://127.0.0.1:1/\n", target))
+	fc := w.lookup(target)
+
+	if !fc.TryLock(context.Background(), false) {
+		t.Fatal("TryLock of an idle entry failed")
+	}
+	// Without a timeout the lock must not expire on its own
+	time.Sleep(10 * time.Millisecond)
+	if !fc.IsLocked() {
+		t.Error("entry without timeout is not locked while its fetch runs")
+	}
+	if fc.TryLock(context.Background(), false) {
+		t.Error("entry without timeout was locked twice")
+	}
+
+	fc.Unlock()
+	if fc.IsLocked() {
+		t.Error("entry is locked after Unlock")
+	}
+	if !fc.TryLock(context.Background(), false) {
+		t.Error("TryLock after Unlock failed")
+	}
+	fc.Unlock()
+}
+
+func TestLockReleasedWhenFetchFinishesEarly(t *testing.T) {
+	w, target := fetchedWatcher(t, "    timeout: 1h\n")
+	fc := w.lookup(target)
+
+	// The fetch returned long before its timeout, a retry is possible
+	// right away
+	if fc.IsLocked() {
+		t.Fatal("entry is locked after its fetch returned")
+	}
+	if err := w.config.runFetch(context.Background(), target, fc, false); err != nil {
+		t.Errorf("fetch right after the previous one: %s", err)
+	}
+}
+
+func TestLockReleasedAfterFailures(t *testing.T) {
+	fetchers := map[string]Fetcher{
+		"test-fail": fetcherFunc(func(context.Context, Source, io.Writer, PrevState) (Result, error) {
+			return Result{}, errors.New("broken")
+		}),
+		"test-panic": fetcherFunc(func(context.Context, Source, io.Writer, PrevState) (Result, error) {
+			panic("broken fetcher")
+		}),
+	}
+
+	for scheme, fetcher := range fetchers {
+		scheme, fetcher := scheme, fetcher
+		t.Run(scheme, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "file")
+			w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s://host/file\n    timeout: 1h\n", target, scheme))
+			w.RegisterFetcher(scheme, fetcher)
+			fc := w.lookup(target)
+
+			if err := w.config.runFetch(context.Background(), target, fc, false); err == nil {
+				t.Fatal("broken fetch succeeded")
+			}
+			if fc.IsLocked() {
+				t.Error("entry is locked after its fetch failed")
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package watch
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveParams are query parameters carrying credentials, compared
+// case-insensitively
+var sensitiveParams = map[string]bool{
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+	"x-goog-signature":     true,
+	"x-goog-credential":    true,
+	"signature":            true,
+	"sig":                  true,
+	"token":                true,
+	"access_token":         true,
+	"api_key":              true,
+	"apikey":               true,
+	"key":                  true,
+	"password":             true,
+	"secret":               true,
+}
+
+var (
+	urlPattern        = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+	credentialPattern = regexp.MustCompile(`(?i)\b(authorization:\s*|bearer\s+|basic\s+)[^\s"',;]+`)
+)
+
+// sanitizeURL masks the userinfo, which may be a token without a
+// password, and the values of sensitive query parameters
+func sanitizeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<unparsable URL>"
+	}
+
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		masked := false
+		for k := range query {
+			if sensitiveParams[strings.ToLower(k)] {
+				query.Set(k, redacted)
+				masked = true
+			}
+		}
+		if masked {
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	return u.String()
+}
+
+// sanitizeText sanitizes all URLs within the text and masks Authorization
+// header values and bearer / basic credentials
+func sanitizeText(s string) string {
+	s = urlPattern.ReplaceAllStringFunc(s, sanitizeURL)
+	return credentialPattern.ReplaceAllString(s, "${1}"+redacted)
+}
+
+// sanitizeMintedURL sanitizes a URL printed by a url_command and masks
+// its whole query string, which carries the signature of presigned URLs
+func sanitizeMintedURL(raw string) string {
+	u, err := url.Parse(sanitizeURL(raw))
+	if err != nil {
+		return "<unparsable URL>"
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	return u.String()
+}
+
+// sanitizeMintedURLError hides the minted URL in the errors of the HTTP
+// client
+func sanitizeMintedURLError(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		ue.URL = sanitizeMintedURL(ue.URL)
+	}
+	return err
+}
+
+// sanitizedError is an error with a sanitized message which still
+// matches the wrapped error with errors.Is and errors.As
+type sanitizedError struct {
+	err error
+	msg string
+}
+
+func (e sanitizedError) Error() string { return e.msg }
+func (e sanitizedError) Unwrap() error { return e.err }
+
+// sanitizeError returns the error with a sanitized message, secrets of
+// the entry are masked as well
+func (c *configFileSource) sanitizeError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := c.log.mask(sanitizeText(err.Error()))
+	if _, _, ok := keyringRef(c.BasicAuth); !ok {
+		if parts := strings.SplitN(c.BasicAuth, ":", 2); len(parts) == 2 && parts[1] != "" {
+			msg = strings.Replace(msg, parts[1], redacted, -1)
+		}
+	}
+	if _, _, ok := keyringRef(c.BearerToken); !ok && c.BearerToken != "" {
+		msg = strings.Replace(msg, c.BearerToken, redacted, -1)
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return sanitizedError{err: err, msg: msg}
+}
+
+// displayURL returns the url of the entry for logs, notifications and
+// the status
+func (c *configFileSource) displayURL() string {
+	return c.log.mask(sanitizeURL(c.sourceURL()))
+}
+
+// sourceURL returns the url of the entry, for url_command entries the
+// redacted URL printed by the last run of the command
+func (c *configFileSource) sourceURL() string {
+	if c.URL == "" && c.URLCommand != "" {
+		return c.getResolvedURL()
+	}
+	return c.URL
+}
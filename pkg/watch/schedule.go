@@ -0,0 +1,326 @@
+package watch
+
+import (
+	"container/heap"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// minRetryDelay keeps failing entries and entries without a
+	// fetch_interval, which are due again right after their last attempt,
+	// from being fetched in a tight loop
+	minRetryDelay = time.Second
+	// hangGrace is how long a fetch may exceed its timeout before it is
+	// reported as hanging
+	hangGrace = time.Minute
+	// maxScheduleSleep limits the sleep of the scheduler so due times
+	// restored from the state file, which are wall clock times, are at
+	// most delayed this long by an adjustment of the clock
+	maxScheduleSleep = time.Minute
+)
+
+// nextRun returns when the entry is due for the next fetch
+func (c *configFileSource) nextRun(targetPath string) time.Time {
+	if c.isTriggered() {
+		return time.Time{}
+	}
+
+	if next, ok := c.nextNonIdempotentRun(); ok {
+		return next
+	}
+
+	if c.BootstrapRetryInterval > 0 && c.isBootstrapping(targetPath) {
+		return c.nextBootstrapRun()
+	}
+
+	lastCall := c.snapshot().lastCall
+	next := lastCall.Add(c.FetchInterval)
+	if c.checkEnabled() && !lastCall.IsZero() {
+		if check := c.nextCheck(); check.Before(next) {
+			return check
+		}
+	}
+	return next
+}
+
+// isBootstrapping reports whether the entry never succeeded or its
+// file is not present locally. A file removed by on_missing is gone on
+// purpose and keeps the fetch_interval.
+func (c *configFileSource) isBootstrapping(targetPath string) bool {
+	state := c.snapshot()
+	if state.lastCall.IsZero() || state.missing || c.WatchOnly {
+		return state.lastCall.IsZero()
+	}
+
+	_, err := os.Stat(targetPath)
+	return os.IsNotExist(err)
+}
+
+// nextBootstrapRun retries with bootstrap_retry_interval, doubling the
+// delay with every consecutive failure up to the fetch_interval
+func (c *configFileSource) nextBootstrapRun() time.Time {
+	c.stateMu.Lock()
+	lastAttempt := c.lastAttempt
+	failures := c.errorState.ConsecutiveFailures
+	c.stateMu.Unlock()
+
+	if lastAttempt.IsZero() {
+		return time.Time{}
+	}
+
+	delay := c.BootstrapRetryInterval
+	for i := 1; i < failures && delay < c.FetchInterval; i++ {
+		delay *= 2
+	}
+	if c.FetchInterval > 0 && delay > c.FetchInterval {
+		delay = c.FetchInterval
+	}
+
+	return lastAttempt.Add(delay)
+}
+
+// dueAt returns when the scheduler has to look at the entry next, for
+// its next run or its max_age check. Queued, running and paused entries
+// only have the max_age check and running ones the check for a hanging
+// fetch, they are rescheduled once they finished or got resumed. False
+// is returned if nothing is due.
+func (c *configFileSource) dueAt(targetPath string, paused, pending bool) (time.Time, bool) {
+	due, ok := c.nextMaxAgeCheck(targetPath)
+	if hang, running := c.hangCheckAt(); running && (!ok || hang.Before(due)) {
+		due, ok = hang, true
+	}
+	if paused || pending || c.isPaused() || c.IsLocked() {
+		return due, ok
+	}
+
+	run := c.nextRun(targetPath)
+	if limit := time.Now().Add(c.FetchInterval); c.FetchInterval > 0 && run.After(limit) {
+		// Only a wall clock time from before the clock was set back can
+		// be further away than one interval
+		c.log.logf(levelWarn, "WARNING: Next run of '%s' at %s is more than %s away, the clock was set back, rescheduling",
+			targetPath, run.Round(0), c.FetchInterval)
+		run = limit
+	}
+	if !c.isTriggered() {
+		c.stateMu.Lock()
+		retry := c.lastAttempt.Add(minRetryDelay)
+		failing := c.errorState.ConsecutiveFailures > 0
+		c.stateMu.Unlock()
+		if (failing || c.FetchInterval <= 0) && retry.After(run) {
+			run = retry
+		}
+	}
+
+	if !ok || run.Before(due) {
+		return run, true
+	}
+	return due, true
+}
+
+// scheduleQueue is a min-heap of the entries ordered by the time the
+// scheduler has to look at them. Changes only mark the entries as dirty,
+// their due times are computed by the scheduler goroutine alone so an
+// outdated computation can't overwrite a newer one.
+type scheduleQueue struct {
+	mu       sync.Mutex
+	items    scheduleHeap
+	byPath   map[string]*scheduleItem
+	dirty    map[string]bool
+	allDirty bool
+}
+
+type scheduleItem struct {
+	path  string
+	due   time.Time
+	index int
+}
+
+func newScheduleQueue() *scheduleQueue {
+	return &scheduleQueue{
+		byPath:   make(map[string]*scheduleItem),
+		dirty:    make(map[string]bool),
+		allDirty: true,
+	}
+}
+
+// markDirty flags the entries for a new computation of their due time
+func (q *scheduleQueue) markDirty(paths ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, p := range paths {
+		q.dirty[p] = true
+	}
+}
+
+// markAllDirty flags all entries including the ones added by a reload
+func (q *scheduleQueue) markAllDirty() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.allDirty = true
+}
+
+// takeDirty returns the flagged entries and clears the flags, all is
+// true if every entry needs a new computation
+func (q *scheduleQueue) takeDirty() (paths []string, all bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for p := range q.dirty {
+		paths = append(paths, p)
+	}
+	all = q.allDirty
+	q.dirty = make(map[string]bool)
+	q.allDirty = false
+	return paths, all
+}
+
+// set schedules the entry at due or removes it if ok is false
+func (q *scheduleQueue) set(path string, due time.Time, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, exists := q.byPath[path]
+	switch {
+	case !ok && exists:
+		heap.Remove(&q.items, item.index)
+		delete(q.byPath, path)
+	case !ok:
+	case exists:
+		item.due = due
+		heap.Fix(&q.items, item.index)
+	default:
+		item = &scheduleItem{path: path, due: due}
+		heap.Push(&q.items, item)
+		q.byPath[path] = item
+	}
+}
+
+// paths returns all scheduled entries
+func (q *scheduleQueue) paths() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	paths := make([]string, 0, len(q.byPath))
+	for p := range q.byPath {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// next returns the earliest due time and false if nothing is scheduled
+func (q *scheduleQueue) next() (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return time.Time{}, false
+	}
+	return q.items[0].due, true
+}
+
+// popDue removes the entries due at the given time and returns their
+// paths, they have to be marked dirty once they were handled
+func (q *scheduleQueue) popDue(now time.Time) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []string
+	for len(q.items) > 0 && !q.items[0].due.After(now) {
+		item := heap.Pop(&q.items).(*scheduleItem)
+		delete(q.byPath, item.path)
+		due = append(due, item.path)
+	}
+	return due
+}
+
+type scheduleHeap []*scheduleItem
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+
+func (h scheduleHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *scheduleHeap) Push(x interface{}) {
+	item := x.(*scheduleItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// reschedule flags the entries for a new computation of their due time
+// and wakes the scheduler
+func (c *configFile) reschedule(paths ...string) {
+	if c.schedule == nil || len(paths) == 0 {
+		return
+	}
+
+	c.schedule.markDirty(paths...)
+	c.wake()
+}
+
+// rescheduleAll flags all entries, e.g. after a reload
+func (c *configFile) rescheduleAll() {
+	if c.schedule == nil {
+		return
+	}
+
+	c.schedule.markAllDirty()
+	c.wake()
+}
+
+// refreshSchedule computes the due times of the dirty entries and drops
+// the ones removed by a reload
+func (c *configFile) refreshSchedule() {
+	paths, all := c.schedule.takeDirty()
+
+	c.RLock()
+	paused, pool := c.paused, c.pool
+	files := c.Files
+	if all {
+		known := make(map[string]bool, len(files))
+		for _, filePath := range c.schedule.paths() {
+			known[filePath] = true
+		}
+		for filePath := range files {
+			known[filePath] = true
+		}
+		paths = paths[:0]
+		for filePath := range known {
+			paths = append(paths, filePath)
+		}
+	}
+
+	type dirtyEntry struct {
+		path string
+		fc   *configFileSource
+	}
+	entries := make([]dirtyEntry, 0, len(paths))
+	for _, filePath := range paths {
+		entries = append(entries, dirtyEntry{filePath, files[filePath]})
+	}
+	c.RUnlock()
+
+	for _, e := range entries {
+		if e.fc == nil {
+			c.schedule.set(e.path, time.Time{}, false)
+			continue
+		}
+		due, ok := e.fc.dueAt(e.path, paused, pool != nil && pool.IsPending(e.path))
+		c.schedule.set(e.path, due, ok)
+	}
+}
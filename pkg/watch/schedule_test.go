@@ -0,0 +1,199 @@
+package watch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// fetchedWatcher returns a Watcher with one entry fetched from a local
+// server, the entry has the given options in addition to its url
+func fetchedWatcher(t *testing.T, options string) (*Watcher, string) {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "content")
+	}))
+	t.Cleanup(srv.Close)
+
+	target := filepath.Join(t.TempDir(), "file")
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s\n%s", target, srv.URL, options))
+	if err := w.config.runFetch(context.Background(), target, w.lookup(target), false); err != nil {
+		t.Fatal(err)
+	}
+	return w, target
+}
+
+// expectWakeup fails unless the scheduler sends within the timeout
+func expectWakeup(t *testing.T, waiter <-chan time.Time, timeout time.Duration) {
+	t.Helper()
+
+	select {
+	case <-waiter:
+	case <-time.After(timeout):
+		t.Fatalf("scheduler did not wake up within %s", timeout)
+	}
+}
+
+// expectNoWakeup fails if the scheduler sends within the duration
+func expectNoWakeup(t *testing.T, waiter <-chan time.Time, d time.Duration) {
+	t.Helper()
+
+	select {
+	case at := <-waiter:
+		t.Fatalf("scheduler woke up at %s although nothing is due", at)
+	case <-time.After(d):
+	}
+}
+
+func TestSchedulerWaitsWithEmptyFileMap(t *testing.T) {
+	w := newTestWatcher(t, "files: {}\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	waiter := w.config.WaitNextExecution(ctx)
+	expectNoWakeup(t, waiter, 100*time.Millisecond)
+	if err := w.config.ExecuteExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reload adding an entry wakes the scheduler right away
+	target := filepath.Join(t.TempDir(), "file")
+	if err := w.Reload(testConfig(t, fmt.Sprintf("files:\n  %s:\n    url: http://127.0.0.1:1/\n    fetch_interval: 1h\n", target))); err != nil {
+		t.Fatal(err)
+	}
+	expectWakeup(t, waiter, time.Second)
+}
+
+func TestSchedulerWakesOnTrigger(t *testing.T) {
+	w, target := fetchedWatcher(t, "    fetch_interval: 1h\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	waiter := w.config.WaitNextExecution(ctx)
+	expectNoWakeup(t, waiter, 100*time.Millisecond)
+
+	if _, err := w.TriggerFetch(target); err != nil {
+		t.Fatal(err)
+	}
+	expectWakeup(t, waiter, time.Second)
+}
+
+func TestSchedulerWakesOnReloadWithShorterInterval(t *testing.T) {
+	w, target := fetchedWatcher(t, "    fetch_interval: 1h\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	waiter := w.config.WaitNextExecution(ctx)
+	expectNoWakeup(t, waiter, 100*time.Millisecond)
+
+	// The changed entry starts over and is due immediately
+	if err := w.Reload(testConfig(t, fmt.Sprintf("files:\n  %s:\n    url: http://127.0.0.1:1/\n    fetch_interval: 1m\n", target))); err != nil {
+		t.Fatal(err)
+	}
+	expectWakeup(t, waiter, time.Second)
+}
+
+func TestDueAtWithZeroInterval(t *testing.T) {
+	w, target := fetchedWatcher(t, "")
+	fc := w.lookup(target)
+
+	// Without fetch_interval the entry is due right after its last
+	// fetch, minRetryDelay keeps it from running in a tight loop
+	due, ok := fc.dueAt(target, false, false)
+	if !ok {
+		t.Fatal("entry without fetch_interval is not scheduled")
+	}
+	if wait := time.Until(due); wait < minRetryDelay/2 || wait > minRetryDelay {
+		t.Errorf("entry without fetch_interval is due in %s, want about %s", wait, minRetryDelay)
+	}
+}
+
+func TestDueAtAfterClockSetBack(t *testing.T) {
+	w, target := fetchedWatcher(t, "    fetch_interval: 1h\n")
+	fc := w.lookup(target)
+
+	// A last call restored from the state file, recorded before the
+	// clock was set back by a day
+	fc.runMu.Lock()
+	fc.lastCall = time.Now().Add(24 * time.Hour).Round(0)
+	fc.runMu.Unlock()
+
+	due, ok := fc.dueAt(target, false, false)
+	if !ok {
+		t.Fatal("entry is not scheduled")
+	}
+	if limit := time.Now().Add(time.Hour); due.After(limit) {
+		t.Errorf("entry is due at %s, more than one fetch_interval away", due)
+	}
+}
+
+func TestDueAtSkipsPausedEntries(t *testing.T) {
+	w, target := fetchedWatcher(t, "    fetch_interval: 1h\n")
+
+	if _, ok := w.lookup(target).dueAt(target, true, false); ok {
+		t.Error("paused entry without max_age is scheduled")
+	}
+}
+
+func TestScheduleQueueOrder(t *testing.T) {
+	q := newScheduleQueue()
+	now := time.Now()
+	q.set("c", now.Add(3*time.Second), true)
+	q.set("a", now.Add(time.Second), true)
+	q.set("b", now.Add(2*time.Second), true)
+	q.set("a", now.Add(4*time.Second), true)
+	q.set("b", time.Time{}, false)
+
+	if next, _ := q.next(); !next.Equal(now.Add(3 * time.Second)) {
+		t.Errorf("next is %s, want the due time of c", next)
+	}
+	if due := q.popDue(now.Add(3 * time.Second)); len(due) != 1 || due[0] != "c" {
+		t.Errorf("due entries are %v, want [c]", due)
+	}
+	if due := q.popDue(now.Add(time.Hour)); len(due) != 1 || due[0] != "a" {
+		t.Errorf("due entries are %v, want [a]", due)
+	}
+	if _, ok := q.next(); ok {
+		t.Error("empty queue has a next due time")
+	}
+}
+
+func TestDueAtKeepsIntervalAfterOnMissingDelete(t *testing.T) {
+	var gone int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&gone) == 1 {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	target := filepath.Join(t.TempDir(), "file")
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: %s\n    fetch_interval: 1h\n    bootstrap_retry_interval: 1s\n    on_missing: delete\n", target, srv.URL))
+	fc := w.lookup(target)
+	for _, status := range []int32{0, 1} {
+		atomic.StoreInt32(&gone, status)
+		if err := w.config.runFetch(context.Background(), target, fc, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("on_missing did not delete the file: %v", err)
+	}
+	if fc.isBootstrapping(target) {
+		t.Error("entry deleted by on_missing is bootstrapping")
+	}
+	if next := fc.nextRun(target); time.Until(next) < 30*time.Minute {
+		t.Errorf("next run at %s, want the fetch_interval", next)
+	}
+}
@@ -0,0 +1,43 @@
+package watch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const slackTimeout = 10 * time.Second
+
+// slackConfig describes a Slack or Mattermost incoming webhook
+type slackConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+	Channel    string `yaml:"channel"`
+	Username   string `yaml:"username"`
+}
+
+type slackMessage struct {
+	Text     string `json:"text"`
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// Send posts the text to the incoming webhook
+func (s slackConfig) Send(text string) error {
+	body, err := json.Marshal(slackMessage{Text: text, Channel: s.Channel, Username: s.Username})
+	if err != nil {
+		return err
+	}
+
+	res, err := (&http.Client{Timeout: slackTimeout}).Post(s.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Got status code %d", res.StatusCode)
+	}
+	return nil
+}
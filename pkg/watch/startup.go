@@ -0,0 +1,113 @@
+package watch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultStartupDeadline = 5 * time.Minute
+	requiredRetryInterval  = 5 * time.Second
+)
+
+// FetchRequired fetches all entries marked as required and retries them
+// until they succeeded or the startup deadline passed. An error listing
+// the entries which could not be fetched is returned after the deadline,
+// the error of ctx once it is done.
+func (c *configFile) FetchRequired(ctx context.Context) error {
+	c.RLock()
+	deadline := c.StartupDeadline
+	if deadline <= 0 {
+		deadline = defaultStartupDeadline
+	}
+
+	required := map[string]*configFileSource{}
+	for filePath, fc := range c.Files {
+		if fc.Required {
+			required[filePath] = fc
+		}
+	}
+	c.RUnlock()
+
+	if len(required) == 0 {
+		return nil
+	}
+
+	c.log.debug("Fetching %d required files (deadline %s)", len(required), deadline)
+
+	var (
+		mu     sync.Mutex
+		failed = map[string]error{}
+		wg     sync.WaitGroup
+		done   = make(chan struct{})
+		expiry = time.Now().Add(deadline)
+	)
+
+	for filePath, fc := range required {
+		failed[filePath] = fmt.Errorf("No attempt finished before the deadline")
+
+		wg.Add(1)
+		go func(filePath string, fc *configFileSource) {
+			defer wg.Done()
+
+			retry := requiredRetryInterval
+			if fc.BootstrapRetryInterval > 0 {
+				retry = fc.BootstrapRetryInterval
+			}
+
+			for {
+				err := c.runFetch(ctx, filePath, fc, false)
+				c.reschedule(filePath)
+
+				mu.Lock()
+				if err == nil {
+					delete(failed, filePath)
+				} else {
+					failed[filePath] = err
+				}
+				mu.Unlock()
+
+				if err == nil || time.Now().Add(retry).After(expiry) || (!fc.idempotent() && !fc.RetryNonIdempotent) {
+					return
+				}
+				select {
+				case <-time.After(retry):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(filePath, fc)
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(deadline):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	msgs := []string{}
+	for filePath, err := range failed {
+		msgs = append(msgs, fmt.Sprintf("'%s': %s", filePath, err))
+	}
+	sort.Strings(msgs)
+
+	return fmt.Errorf("Required files could not be fetched within %s: %s", deadline, strings.Join(msgs, "; "))
+}
@@ -0,0 +1,218 @@
+package watch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// daemonState is the runtime state persisted between restarts
+type daemonState struct {
+	Files     map[string]fileState          `json:"files"`
+	Checksums map[string]checksumCacheEntry `json:"checksums,omitempty"`
+}
+
+// fileState is the persisted state of one entry, it is only restored
+// if the URL of the entry did not change
+type fileState struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	LastSuccess  time.Time `json:"last_success"`
+	LastDownload time.Time `json:"last_download"`
+	SHA256       string    `json:"sha256,omitempty"`
+	Version      string    `json:"version,omitempty"`
+	Length       int64     `json:"length,omitempty"`
+	Host         string    `json:"host,omitempty"`
+	Accept       string    `json:"accept,omitempty"`
+	Missing      bool      `json:"missing,omitempty"`
+
+	// Parts of entries with urls keyed by the URL of the part
+	Parts map[string]partState `json:"parts,omitempty"`
+	// SyncFiles of mirror entries keyed by their relative path
+	SyncFiles map[string]dirMirrorFile `json:"sync_files,omitempty"`
+}
+
+// stateFile reads and atomically writes the daemon state
+type stateFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newStateFile(filePath string) *stateFile {
+	return &stateFile{path: filePath}
+}
+
+// Load reads the state file, a missing file results in an empty state
+func (s *stateFile) Load() (*daemonState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := &daemonState{}
+
+	raw, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return res, err
+	}
+
+	if err := json.Unmarshal(raw, res); err != nil {
+		return &daemonState{}, err
+	}
+
+	return res, nil
+}
+
+// Save writes the state into a temp file and moves it over the state file
+func (s *stateFile) Save(state *daemonState) error {
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(path.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	t, err := ioutil.TempFile(path.Dir(s.path), path.Base(s.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(t.Name())
+	defer t.Close()
+
+	if _, err := t.Write(raw); err != nil {
+		return err
+	}
+
+	if err := t.Sync(); err != nil {
+		return err
+	}
+
+	if err := t.Close(); err != nil {
+		return err
+	}
+
+	return replaceFile(t.Name(), s.path)
+}
+
+// saveState persists the state of all entries if a state file is
+// configured, failures are only logged
+func (c *configFile) saveState() {
+	c.RLock()
+	if c.state == nil {
+		c.RUnlock()
+		return
+	}
+
+	sf := c.state
+	state := &daemonState{
+		Files:     make(map[string]fileState),
+		Checksums: c.checksums.Export(),
+	}
+	for filePath, fc := range c.Files {
+		run := fc.snapshot()
+		if run.lastCall.IsZero() {
+			continue
+		}
+		state.Files[filePath] = fileState{
+			URL:          fc.URL,
+			ETag:         run.lastSeenETag,
+			LastModified: run.lastModified,
+			LastSuccess:  run.lastCall,
+			LastDownload: run.lastDownload,
+			SHA256:       run.lastSHA256,
+			Version:      run.lastVersion,
+			Length:       run.lastLength,
+			Host:         run.lastHost,
+			Accept:       fc.Accept,
+			Parts:        run.parts,
+			SyncFiles:    run.syncFiles,
+			Missing:      run.missing,
+		}
+	}
+	c.RUnlock()
+
+	if err := sf.Save(state); err != nil {
+		c.log.logf(levelWarn, "Could not write state file: %s", err)
+	}
+}
+
+// RestoreState applies the persisted state to all entries without
+// runtime state whose URL did not change since the state was written
+func (c *configFile) RestoreState() error {
+	c.RLock()
+	sf := c.state
+	c.RUnlock()
+
+	if sf == nil {
+		return nil
+	}
+
+	state, err := sf.Load()
+	if err != nil {
+		return err
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	for filePath, fs := range state.Files {
+		fc, ok := c.Files[filePath]
+		if !ok || fc.URL != fs.URL {
+			continue
+		}
+		fc.restore(c.clock(), filePath, fs)
+	}
+
+	c.checksums.Import(state.Checksums)
+
+	return nil
+}
+
+// restore applies the persisted state unless the entry already has
+// runtime state or a fetch of it is running
+func (c *configFileSource) restore(clk clock, filePath string, fs fileState) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	if !c.lastCall.IsZero() || !c.inProgress.IsZero() {
+		return
+	}
+
+	c.log.debug("Restoring state of '%s' (last success %s)", filePath, fs.LastSuccess)
+	// The persisted times only have a wall clock reading, the schedule
+	// continues from them with monotonic durations
+	var future bool
+	if c.lastCall, future = withMonotonic(clk, fs.LastSuccess); future {
+		c.log.logf(levelWarn, "WARNING: Last success of '%s' at %s lies in the future, the clock was set back, counting from now",
+			filePath, fs.LastSuccess)
+	}
+	c.lastDownload, _ = withMonotonic(clk, fs.LastDownload)
+	c.lastSeenETag = fs.ETag
+	c.lastModified = fs.LastModified
+	c.lastSHA256 = fs.SHA256
+	c.lastVersion = fs.Version
+	c.lastLength = fs.Length
+	c.lastHost = fs.Host
+	c.parts = fs.Parts
+	c.syncFiles = fs.SyncFiles
+	c.missing = fs.Missing
+
+	if c.Accept != fs.Accept {
+		// The validators belong to another representation, fetch it
+		// right away
+		c.log.debug("Accept of '%s' changed, dropping validators", filePath)
+		c.lastCall = time.Time{}
+		c.lastSeenETag, c.lastModified, c.lastLength = "", "", 0
+		c.parts, c.syncFiles = nil, nil
+	}
+}
@@ -0,0 +1,189 @@
+package watch
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// FileStatus is the externally visible state of one entry
+type FileStatus struct {
+	Path                string        `json:"path"`
+	URL                 string        `json:"url"`
+	State               string        `json:"state"`
+	LastSuccess         time.Time     `json:"last_success"`
+	LastError           string        `json:"last_error,omitempty"`
+	LastErrorAt         time.Time     `json:"last_error_at"`
+	FailingSince        time.Time     `json:"failing_since"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	Escalated           bool          `json:"escalated"`
+	Healthy             bool          `json:"healthy"`
+	History             []FetchRecord `json:"history"`
+	Mirror              *MirrorStatus `json:"mirror,omitempty"`
+	Ping                *PingStatus   `json:"ping,omitempty"`
+	Sync                *SyncStatus   `json:"sync,omitempty"`
+	ResolvedURL         string        `json:"resolved_url,omitempty"`
+	// NextRun is when the next fetch is due, it is zero while the entry
+	// is paused, queued or running
+	NextRun time.Time `json:"next_run"`
+	// Progress is only set while the entry is being downloaded
+	Progress *DownloadProgress `json:"progress,omitempty"`
+}
+
+// DaemonStats are the counters of the whole daemon
+type DaemonStats struct {
+	// Queued downloads wait for one of the MaxConcurrent workers, Active
+	// ones are running
+	Queued        int `json:"queued"`
+	Active        int `json:"active"`
+	MaxConcurrent int `json:"max_concurrent_downloads"`
+	// RejectedTriggers counts pushes to the webhook listener with an
+	// invalid signature
+	RejectedTriggers int64 `json:"rejected_triggers"`
+}
+
+// Stats returns the counters of the download pool and the webhook
+// listener
+func (c *configFile) Stats() DaemonStats {
+	c.RLock()
+	pool := c.pool
+	stats := DaemonStats{RejectedTriggers: c.rejectedTriggers}
+	c.RUnlock()
+
+	if pool != nil {
+		stats.Queued, stats.Active = pool.Stats()
+		stats.MaxConcurrent = pool.Size()
+	}
+	return stats
+}
+
+func (c *configFile) countRejectedTrigger() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.rejectedTriggers++
+}
+
+// fileErrorState tracks the failures of an entry since its last success
+type fileErrorState struct {
+	LastError           string
+	LastErrorAt         time.Time
+	FirstErrorAt        time.Time
+	ConsecutiveFailures int
+}
+
+// recordFailure stores the error and returns the updated error state
+func (c *configFileSource) recordFailure(err error) fileErrorState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.lastAttempt = time.Now()
+	c.errorState.LastError = err.Error()
+	c.errorState.LastErrorAt = c.lastAttempt
+	if c.errorState.ConsecutiveFailures == 0 {
+		c.errorState.FirstErrorAt = c.lastAttempt
+	}
+	c.errorState.ConsecutiveFailures++
+
+	return c.errorState
+}
+
+// recordSuccess resets the error state and returns the one before this
+// success
+func (c *configFileSource) recordSuccess() fileErrorState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	prev := c.errorState
+	c.lastAttempt = time.Now()
+	c.errorState = fileErrorState{}
+
+	return prev
+}
+
+func (c *configFileSource) getErrorState() fileErrorState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.errorState
+}
+
+// Status returns the state of all entries sorted by path
+func (c *configFile) Status() []FileStatus {
+	c.RLock()
+	defer c.RUnlock()
+
+	res := []FileStatus{}
+	for filePath, fc := range c.Files {
+		es := fc.getErrorState()
+
+		state := "idle"
+		if c.paused || fc.isPaused() {
+			state = poolStatePaused
+		}
+		if c.pool != nil {
+			if s := c.pool.State(filePath); s != "" {
+				state = s
+			}
+		}
+
+		var (
+			next     time.Time
+			progress *DownloadProgress
+		)
+		switch state {
+		case "idle":
+			next = fc.nextRun(filePath)
+		case poolStateRunning:
+			progress = fc.progress.get()
+		}
+
+		res = append(res, FileStatus{
+			Path:                filePath,
+			URL:                 fc.displayURL(),
+			State:               state,
+			LastSuccess:         fc.snapshot().lastCall,
+			LastError:           es.LastError,
+			LastErrorAt:         es.LastErrorAt,
+			FailingSince:        es.FirstErrorAt,
+			ConsecutiveFailures: es.ConsecutiveFailures,
+			Escalated:           fc.escalated(es.ConsecutiveFailures),
+			Healthy:             !fc.isStale(filePath),
+			History:             fc.getHistory(),
+			Mirror:              fc.getMirrorStatus(),
+			Ping:                fc.getPingStatus(),
+			Sync:                fc.getSyncStatus(),
+			ResolvedURL:         fc.log.mask(sanitizeURL(fc.getResolvedURL())),
+			NextRun:             next,
+			Progress:            progress,
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Path < res[j].Path })
+	return res
+}
+
+// statusSummary returns a one-line summary of the entries suitable for
+// the systemd STATUS field
+func (c *configFile) statusSummary() string {
+	ok, failing := 0, 0
+	for _, s := range c.Status() {
+		if s.ConsecutiveFailures > 0 {
+			failing++
+		} else {
+			ok++
+		}
+	}
+
+	summary := fmt.Sprintf("%d files ok, %d failing", ok, failing)
+	if c.IsStarting() {
+		summary = "starting, first fetches held off"
+	}
+	if stats := c.Stats(); stats.Active > 0 || stats.Queued > 0 {
+		summary += fmt.Sprintf(", %d downloading, %d queued", stats.Active, stats.Queued)
+	}
+	if c.IsPaused() {
+		summary += ", scheduling paused"
+	}
+	return summary
+}
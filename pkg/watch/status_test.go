@@ -0,0 +1,61 @@
+package watch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsReportPool(t *testing.T) {
+	srv, started, release := slowServer(t)
+	dir := t.TempDir()
+	w := newTestWatcher(t, fmt.Sprintf("max_concurrent_downloads: 1\nfiles:\n  %s:\n    url: %s/a\n  %s:\n    url: %s/b\n",
+		filepath.Join(dir, "a"), srv.URL, filepath.Join(dir, "b"), srv.URL))
+
+	cancel, done := runWatcher(w)
+	defer func() {
+		close(release)
+		cancel()
+		<-done
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download was not started")
+	}
+
+	want := DaemonStats{Queued: 1, Active: 1, MaxConcurrent: 1}
+	for start := time.Now(); w.Stats() != want && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := w.Stats(); got != want {
+		t.Errorf("stats are %+v, want %+v", got, want)
+	}
+	if summary := w.Summary(); !strings.Contains(summary, "1 downloading, 1 queued") {
+		t.Errorf("summary %q does not report the pool", summary)
+	}
+}
+
+func TestStatsCountRejectedTriggers(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "file")
+	w := newTestWatcher(t, fmt.Sprintf("trigger_secret: secret\nfiles:\n  %s:\n    url: http://127.0.0.1:1/\n", target))
+	s := &triggerServer{config: w.config}
+
+	for _, sig := range []string{"", "sha256=00"} {
+		req := httptest.NewRequest(http.MethodPost, "/trigger"+target, strings.NewReader("{}"))
+		req.Header.Set(triggerSignatureHeader, sig)
+		rec := httptest.NewRecorder()
+		s.handleTrigger(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("trigger with signature %q got status %d", sig, rec.Code)
+		}
+	}
+
+	if got := w.Stats().RejectedTriggers; got != 2 {
+		t.Errorf("%d rejected triggers counted, want 2", got)
+	}
+}
@@ -0,0 +1,35 @@
+package watch
+
+// stringList can be specified in the configuration file either as a
+// single string or as a list of strings
+type stringList []string
+
+func (s *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*s = stringList{single}
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+
+	*s = list
+	return nil
+}
+
+func (s stringList) Equals(in stringList) bool {
+	if len(s) != len(in) {
+		return false
+	}
+
+	for i := range s {
+		if s[i] != in[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,74 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func validateTargetRoots(roots []string) error {
+	for _, r := range roots {
+		if !filepath.IsAbs(r) {
+			return fmt.Errorf("allowed_target_roots entry %q is no absolute path", r)
+		}
+	}
+	return nil
+}
+
+// checkTargetRoot returns an error if the path is not inside one of the
+// roots, an empty list allows every path. Symlinks in the existing parent
+// directories are resolved so they can't lead out of the root.
+func checkTargetRoot(roots []string, p string) error {
+	if len(roots) == 0 {
+		return nil
+	}
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	real := resolveExisting(abs)
+
+	for _, r := range roots {
+		root := filepath.Clean(r)
+		realRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			realRoot = resolveExisting(root)
+		}
+		if isWithin(root, abs) && isWithin(realRoot, real) {
+			return nil
+		}
+	}
+	return securityError{fmt.Errorf("Target '%s' is outside of allowed_target_roots", p)}
+}
+
+// checkTargetRoots checks all paths the entry writes to
+func (c *configFileSource) checkTargetRoots(roots []string, targetPath string) error {
+	if err := checkTargetRoot(roots, targetPath); err != nil {
+		return err
+	}
+	if c.ExtractTo != "" {
+		return checkTargetRoot(roots, c.ExtractTo)
+	}
+	return nil
+}
+
+// resolveExisting resolves the symlinks of the longest existing parent
+// of the path. The last element is not resolved as the file is replaced
+// by a rename which does not follow a symlink.
+func resolveExisting(p string) string {
+	dir, rest := filepath.Dir(p), filepath.Base(p)
+	for {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(real, rest)
+		} else if !os.IsNotExist(err) {
+			return p
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return p
+		}
+		dir, rest = parent, filepath.Join(filepath.Base(dir), rest)
+	}
+}
@@ -0,0 +1,155 @@
+package watch
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMinThroughputWindow = 30 * time.Second
+	throughputSampleInterval   = time.Second
+)
+
+// throughputMonitor counts the bytes read through it and aborts the
+// transfer by calling cancel when the throughput over the sliding
+// window drops below the minimum after the grace period
+type throughputMonitor struct {
+	r      io.Reader
+	bytes  int64
+	cancel func()
+
+	min    byteSize
+	window time.Duration
+	grace  time.Duration
+
+	mu   sync.Mutex
+	err  error
+	stop chan struct{}
+}
+
+type throughputSample struct {
+	at    time.Time
+	bytes int64
+}
+
+func newThroughputMonitor(r io.Reader, cancel func(), min byteSize, window, grace time.Duration) *throughputMonitor {
+	if window <= 0 {
+		window = defaultMinThroughputWindow
+	}
+	if grace <= 0 {
+		grace = window
+	}
+
+	m := &throughputMonitor{
+		r:      r,
+		cancel: cancel,
+		min:    min,
+		window: window,
+		grace:  grace,
+		stop:   make(chan struct{}),
+	}
+	go m.watch()
+
+	return m
+}
+
+func (m *throughputMonitor) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	atomic.AddInt64(&m.bytes, int64(n))
+	return n, err
+}
+
+// Stop ends the monitoring, needs to be called when the transfer is done
+func (m *throughputMonitor) Stop() {
+	close(m.stop)
+}
+
+// Err returns the reason the transfer was aborted or nil
+func (m *throughputMonitor) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
+func (m *throughputMonitor) watch() {
+	start := time.Now()
+	samples := []throughputSample{{at: start}}
+
+	t := time.NewTicker(throughputSampleInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-t.C:
+			samples = append(samples, throughputSample{at: now, bytes: atomic.LoadInt64(&m.bytes)})
+
+			// Keep the newest sample older than the window as reference
+			for len(samples) > 2 && now.Sub(samples[1].at) >= m.window {
+				samples = samples[1:]
+			}
+
+			if now.Sub(start) < m.grace || now.Sub(samples[0].at) < m.window {
+				continue
+			}
+
+			first, last := samples[0], samples[len(samples)-1]
+			rate := effectiveRate(last.bytes-first.bytes, last.at.Sub(first.at))
+			if rate >= m.min {
+				continue
+			}
+
+			m.mu.Lock()
+			m.err = fmt.Errorf("Transfer aborted: throughput of %s/s over the last %s is below min_throughput of %s/s",
+				rate, last.at.Sub(first.at).Round(time.Second), m.min)
+			m.mu.Unlock()
+
+			m.cancel()
+			return
+		}
+	}
+}
+
+// idleTimeoutReader aborts the transfer by calling cancel when no data
+// was received within the timeout
+type idleTimeoutReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	fired   int32
+}
+
+func newIdleTimeoutReader(r io.Reader, cancel func(), timeout time.Duration) *idleTimeoutReader {
+	i := &idleTimeoutReader{r: r, timeout: timeout}
+	i.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&i.fired, 1)
+		cancel()
+	})
+	return i
+}
+
+func (i *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := i.r.Read(p)
+	if n > 0 {
+		i.timer.Reset(i.timeout)
+	}
+	return n, err
+}
+
+// Stop ends the monitoring, needs to be called when the transfer is done
+func (i *idleTimeoutReader) Stop() {
+	i.timer.Stop()
+}
+
+// Err returns the reason the transfer was aborted or nil
+func (i *idleTimeoutReader) Err() error {
+	if atomic.LoadInt32(&i.fired) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Idle read timeout: no data received for %s (idle_read_timeout)", i.timeout)
+}
@@ -0,0 +1,133 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultClientCertExpiryWarning = 30 * 24 * time.Hour
+
+// loadCertPool reads the CA certificates from the given file and all
+// *.pem / *.crt files inside the given directory. Returns nil if both
+// are empty so the system roots are used.
+func loadCertPool(caFile, caDir string) (*x509.CertPool, error) {
+	if caFile == "" && caDir == "" {
+		return nil, nil
+	}
+
+	var files []string
+	if caFile != "" {
+		files = append(files, caFile)
+	}
+
+	if caDir != "" {
+		entries, err := ioutil.ReadDir(caDir)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read CA directory '%s': %s", caDir, err)
+		}
+		for _, e := range entries {
+			if e.IsDir() || !(strings.HasSuffix(e.Name(), ".pem") || strings.HasSuffix(e.Name(), ".crt")) {
+				continue
+			}
+			files = append(files, path.Join(caDir, e.Name()))
+		}
+	}
+
+	pool := x509.NewCertPool()
+	for _, f := range files {
+		raw, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read CA file '%s': %s", f, err)
+		}
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("CA file '%s' does not contain any PEM certificate", f)
+		}
+	}
+
+	return pool, nil
+}
+
+// loadClientCertificate reads the certificate and key for client
+// authentication. Returns nil if neither is configured.
+func loadClientCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("client_cert_file and client_key_file need to be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load client certificate '%s' with key '%s': %s", certFile, keyFile, err)
+	}
+
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("Could not parse client certificate '%s': %s", certFile, err)
+	}
+
+	return &cert, nil
+}
+
+func warnCertificateExpiry(l *logger, name string, cert *tls.Certificate, window time.Duration) {
+	if cert == nil || cert.Leaf == nil {
+		return
+	}
+
+	if window <= 0 {
+		window = defaultClientCertExpiryWarning
+	}
+
+	if left := time.Until(cert.Leaf.NotAfter); left < window {
+		l.logf(levelWarn, "WARNING: Client certificate for '%s' expires at %s (in %s)",
+			name, cert.Leaf.NotAfter.Format(time.RFC3339), left.Round(time.Minute))
+	}
+}
+
+func validatePins(pins []string) error {
+	for _, p := range pins {
+		raw, err := base64.StdEncoding.DecodeString(p)
+		if err != nil || len(raw) != sha256.Size {
+			return fmt.Errorf("Invalid pin_sha256 %q, needs to be a base64 encoded SHA256", p)
+		}
+	}
+	return nil
+}
+
+func spkiFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPins returns a callback for tls.Config.VerifyPeerCertificate
+// accepting the connection if the public key of any presented
+// certificate matches one of the pins
+func verifyPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		var seen []string
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("Could not parse server certificate: %s", err)
+			}
+
+			fp := spkiFingerprint(cert)
+			for _, p := range pins {
+				if fp == p {
+					return nil
+				}
+			}
+			seen = append(seen, fp)
+		}
+
+		return securityError{fmt.Errorf("No certificate matches pin_sha256, server presented: %s", strings.Join(seen, ", "))}
+	}
+}
@@ -0,0 +1,345 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"golang.org/x/term"
+)
+
+const (
+	topRefreshInterval = time.Second
+	// topHeaderLines are the lines above the rows of the table
+	topHeaderLines = 3
+	// topMaxErrorLength shortens long errors in the last column
+	topMaxErrorLength = 120
+)
+
+type topKey int
+
+const (
+	topKeyNone topKey = iota
+	topKeyUp
+	topKeyDown
+	topKeyForce
+	topKeyQuit
+)
+
+// Top shows the status of the running daemon as a table refreshed every
+// second until ctx is done or q is pressed. The arrow keys select an
+// entry and f force-fetches it. When stdin or stdout is no terminal a
+// single snapshot is printed instead.
+func Top(ctx context.Context, socketPath, adminAddr, adminToken string) error {
+	call, via := daemonCaller(socketPath, adminAddr, adminToken)
+	if call == nil {
+		return errors.New("--control-socket or --listen-admin is required")
+	}
+
+	res, err := call(controlRequest{Command: "status"})
+	if err != nil {
+		return fmt.Errorf("Could not get status from the daemon via %s: %s", via, err)
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		for _, line := range formatColumns(topRows(res.Status, time.Now())) {
+			fmt.Println(line)
+		}
+		return nil
+	}
+
+	return runTop(ctx, &topView{call: call, via: via, status: res})
+}
+
+// topView is the state of the interactive table
+type topView struct {
+	call   daemonCall
+	via    string
+	status controlResponse
+	// err is the error of the last refresh, the previous status is
+	// shown meanwhile
+	err      error
+	message  string
+	selected int
+	offset   int
+}
+
+func runTop(ctx context.Context, v *topView) error {
+	fd := int(os.Stdin.Fd())
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, old)
+
+	// Alternate screen without cursor, the previous screen is restored
+	// on exit
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan topKey)
+	go readTopKeys(os.Stdin, keys)
+
+	ticker := time.NewTicker(topRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		v.render(os.Stdout)
+
+		select {
+		case <-ticker.C:
+			v.refresh()
+		case k, ok := <-keys:
+			switch {
+			case !ok, k == topKeyQuit:
+				return nil
+			case k == topKeyUp:
+				v.selected--
+			case k == topKeyDown:
+				v.selected++
+			case k == topKeyForce:
+				v.forceFetch()
+				v.refresh()
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// refresh fetches the status and keeps the selected entry selected
+func (v *topView) refresh() {
+	res, err := v.call(controlRequest{Command: "status"})
+	if err != nil {
+		v.err = err
+		return
+	}
+	v.err = nil
+
+	if selected := v.selectedPath(); selected != "" {
+		for i, fs := range res.Status {
+			if fs.Path == selected {
+				v.selected = i
+			}
+		}
+	}
+	v.status = res
+}
+
+func (v *topView) selectedPath() string {
+	if v.selected < 0 || v.selected >= len(v.status.Status) {
+		return ""
+	}
+	return v.status.Status[v.selected].Path
+}
+
+func (v *topView) forceFetch() {
+	filePath := v.selectedPath()
+	if filePath == "" {
+		return
+	}
+
+	if _, err := v.call(controlRequest{Command: "force-fetch", Path: filePath}); err != nil {
+		v.message = fmt.Sprintf("Could not force-fetch '%s': %s", filePath, err)
+		return
+	}
+	v.message = fmt.Sprintf("Force-fetching '%s'", filePath)
+}
+
+// scroll keeps the selection within the entries and visible in the
+// given amount of rows
+func (v *topView) scroll(rows int) {
+	n := len(v.status.Status)
+	if v.selected >= n {
+		v.selected = n - 1
+	}
+	if v.selected < 0 {
+		v.selected = 0
+	}
+
+	if v.selected < v.offset {
+		v.offset = v.selected
+	}
+	if rows > 0 && v.selected >= v.offset+rows {
+		v.offset = v.selected - rows + 1
+	}
+	if v.offset > n-rows {
+		v.offset = n - rows
+	}
+	if v.offset < 0 {
+		v.offset = 0
+	}
+}
+
+func (v *topView) render(w io.Writer) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	rows := height - topHeaderLines
+	v.scroll(rows)
+
+	title := fmt.Sprintf("download-watch top - %d files via %s - %s", len(v.status.Status), v.via, time.Now().Format("15:04:05"))
+	if v.status.Starting {
+		title += " - starting"
+	}
+	if v.status.Paused {
+		title += " - scheduling paused"
+	}
+	if s := v.status.Stats; s != nil && (s.Active > 0 || s.Queued > 0) {
+		title += fmt.Sprintf(" - %d downloading, %d queued", s.Active, s.Queued)
+	}
+
+	help := "up/down select, f force-fetch, q quit"
+	switch {
+	case v.err != nil:
+		help = fmt.Sprintf("Daemon unreachable, showing the last status: %s", v.err)
+	case v.message != "":
+		help = v.message
+	}
+
+	lines := formatColumns(topRows(v.status.Status, time.Now()))
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString(truncate(title, width) + "\r\n")
+	b.WriteString(truncate(help, width) + "\r\n")
+	b.WriteString("\x1b[1m" + truncate(lines[0], width) + "\x1b[0m")
+	for i := v.offset; i < len(lines)-1 && i < v.offset+rows; i++ {
+		line := truncate(lines[i+1], width)
+		if i == v.selected {
+			line = "\x1b[7m" + line + strings.Repeat(" ", width-utf8.RuneCountInString(line)) + "\x1b[0m"
+		}
+		b.WriteString("\r\n" + line)
+	}
+
+	io.WriteString(w, b.String())
+}
+
+// topRows returns the header and one row per entry
+func topRows(status []FileStatus, now time.Time) [][]string {
+	rows := [][]string{{"PATH", "STATE", "LAST SUCCESS", "NEXT RUN", "PROGRESS", "LAST ERROR"}}
+	for _, fs := range status {
+		last := "never"
+		if !fs.LastSuccess.IsZero() {
+			last = now.Sub(fs.LastSuccess).Round(time.Second).String() + " ago"
+		}
+
+		next := "-"
+		switch {
+		case fs.State != "idle":
+		case fs.NextRun.After(now):
+			next = "in " + fs.NextRun.Sub(now).Round(time.Second).String()
+		default:
+			next = "due"
+		}
+
+		progress := "-"
+		if p := fs.Progress; p != nil {
+			progress = byteSize(p.Bytes).String()
+			if p.Total > 0 {
+				progress = fmt.Sprintf("%s / %s (%d%%)", progress, byteSize(p.Total), p.Bytes*100/p.Total)
+			}
+		}
+
+		lastError := "-"
+		if fs.ConsecutiveFailures > 0 {
+			lastError = truncate(fmt.Sprintf("%dx %s", fs.ConsecutiveFailures, fs.LastError), topMaxErrorLength)
+		}
+
+		rows = append(rows, []string{fs.Path, fs.State, last, next, progress, lastError})
+	}
+	return rows
+}
+
+// formatColumns pads the cells to the width of their column, the last
+// column is not padded
+func formatColumns(rows [][]string) []string {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if l := utf8.RuneCountInString(cell); l > widths[i] {
+				widths[i] = l
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		var b strings.Builder
+		for i, cell := range row {
+			b.WriteString(cell)
+			if i < len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+2))
+			}
+		}
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// truncate shortens the text to at most max runes
+func truncate(s string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:max-1]) + "…"
+}
+
+func readTopKeys(r io.Reader, keys chan<- topKey) {
+	defer close(keys)
+
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for in := buf[:n]; len(in) > 0; {
+			k, size := parseTopKey(in)
+			in = in[size:]
+			if k != topKeyNone {
+				keys <- k
+			}
+		}
+	}
+}
+
+// parseTopKey returns the key at the start of the input and its length
+func parseTopKey(in []byte) (topKey, int) {
+	// Arrow keys in normal and application cursor mode
+	if len(in) >= 3 && in[0] == 0x1b && (in[1] == '[' || in[1] == 'O') {
+		switch in[2] {
+		case 'A':
+			return topKeyUp, 3
+		case 'B':
+			return topKeyDown, 3
+		}
+		return topKeyNone, 3
+	}
+
+	switch in[0] {
+	case 'k':
+		return topKeyUp, 1
+	case 'j':
+		return topKeyDown, 1
+	case 'f', 'F':
+		return topKeyForce, 1
+	case 'q', 'Q', 0x03:
+		return topKeyQuit, 1
+	}
+	return topKeyNone, 1
+}
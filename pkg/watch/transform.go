@@ -0,0 +1,141 @@
+package watch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultTransformTimeout = time.Minute
+	defaultTransformMaxSize = byteSize(1 << 30)
+
+	checksumOfSource      = "source"
+	checksumOfTransformed = "transformed"
+)
+
+var errTransformTooLarge = errors.New("Output exceeds transform_max_size")
+
+func (c *configFileSource) validateTransform() error {
+	switch c.ChecksumOf {
+	case "", checksumOfSource, checksumOfTransformed:
+	default:
+		return fmt.Errorf("Invalid checksum_of %q, use source or transformed", c.ChecksumOf)
+	}
+
+	if c.ChecksumOf != "" && c.TransformCommand == "" {
+		return errors.New("checksum_of needs a transform_command")
+	}
+	return nil
+}
+
+// checksumOfInstalled reports whether the sha256 option refers to the
+// installed file instead of the downloaded one before transformation
+func (c *configFileSource) checksumOfInstalled() bool {
+	return c.TransformCommand == "" || c.ChecksumOf == checksumOfTransformed
+}
+
+// limitedHashWriter writes to a file while hashing and fails when the
+// output gets larger than allowed
+type limitedHashWriter struct {
+	w        io.Writer
+	hash     hash.Hash
+	n        int64
+	max      int64
+	exceeded bool
+}
+
+func (l *limitedHashWriter) Write(p []byte) (int, error) {
+	if l.n+int64(len(p)) > l.max {
+		l.exceeded = true
+		return 0, errTransformTooLarge
+	}
+	l.n += int64(len(p))
+	l.hash.Write(p)
+	return l.w.Write(p)
+}
+
+// transformFile pipes src through the transform_command and writes its
+// output to a temp file next to src. The path and SHA256 of the output
+// are returned, a failing command aborts the install.
+func (c *configFile) transformFile(targetPath string, fc *configFileSource, src string) (string, string, error) {
+	c.RLock()
+	argv := c.commandLine(fc, fc.TransformCommand)
+	env := c.commandEnv(fc,
+		"DW_PATH="+targetPath,
+		"DW_URL="+fc.sourceURL(),
+	)
+	c.RUnlock()
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", "", err
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(src), ".transform-")
+	if err != nil {
+		return "", "", err
+	}
+
+	timeout := fc.TransformTimeout
+	if timeout <= 0 {
+		timeout = defaultTransformTimeout
+	}
+	maxSize := fc.TransformMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultTransformMaxSize
+	}
+
+	ctx, cancel := context.WithTimeout(fc.fetchContext(), timeout)
+	defer cancel()
+
+	stdout := &limitedHashWriter{w: out, hash: sha256.New(), max: int64(maxSize)}
+	stderr := new(bytes.Buffer)
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = in
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = env
+
+	err = cmd.Run()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		err = fmt.Errorf("Timeout of %s exceeded", timeout)
+	case stdout.exceeded:
+		err = errTransformTooLarge
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, firstLine(msg))
+		}
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", "", err
+	}
+
+	return out.Name(), fmt.Sprintf("%x", stdout.hash.Sum(nil)), nil
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,332 @@
+package watch
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	proxyDirect = "direct"
+
+	// Same as the default of the net/http client
+	defaultMaxRedirects = 10
+
+	// Same as the dialer of the default net/http transport
+	defaultConnectTimeout = 30 * time.Second
+
+	ipFamilyAny = "any"
+	ipFamilyV4  = "v4"
+	ipFamilyV6  = "v6"
+)
+
+// proxyError marks failures which happened while talking to the proxy
+// instead of the origin server
+type proxyError struct {
+	proxy string
+	err   error
+}
+
+func (p proxyError) Error() string {
+	return fmt.Sprintf("Proxy connection to %s failed: %s", p.proxy, p.err)
+}
+
+func (p proxyError) Unwrap() error { return p.err }
+
+func validateProxyURL(raw string) error {
+	if raw == "" || raw == proxyDirect {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("Invalid proxy URL: %s", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return nil
+	default:
+		return fmt.Errorf("Unsupported proxy scheme %q, use http, https or socks5", u.Scheme)
+	}
+}
+
+func validateDialOptions(ipFamily, bindAddress string) error {
+	switch ipFamily {
+	case "", ipFamilyAny, ipFamilyV4, ipFamilyV6:
+	default:
+		return fmt.Errorf("Invalid ip_family %q, use v4, v6 or any", ipFamily)
+	}
+
+	if bindAddress == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(bindAddress)
+	if ip == nil {
+		return fmt.Errorf("Invalid bind_address %q, needs to be an IP address", bindAddress)
+	}
+
+	if (ipFamily == ipFamilyV4 && ip.To4() == nil) || (ipFamily == ipFamilyV6 && ip.To4() != nil) {
+		return fmt.Errorf("bind_address %s does not match ip_family %s", bindAddress, ipFamily)
+	}
+
+	return nil
+}
+
+func validateResolve(resolve map[string]string) error {
+	for hostPort, addr := range resolve {
+		if _, _, err := net.SplitHostPort(hostPort); err != nil {
+			return fmt.Errorf("Invalid resolve key %q, needs format host:port", hostPort)
+		}
+		if addr == "" {
+			return fmt.Errorf("Empty resolve address for %q", hostPort)
+		}
+	}
+	return nil
+}
+
+// resolveOverride returns the address to connect to instead of the
+// given host:port. The override may omit the port to keep the original.
+func resolveOverride(l *logger, resolve map[string]string, addr string) string {
+	override, ok := resolve[addr]
+	if !ok {
+		return addr
+	}
+
+	if _, _, err := net.SplitHostPort(override); err != nil {
+		_, port, _ := net.SplitHostPort(addr)
+		override = net.JoinHostPort(override, port)
+	}
+
+	l.debug("Connecting to %s instead of %s (resolve override)", override, addr)
+	return override
+}
+
+// dialContext creates the dial function restricting the connections to
+// the configured IP family, binding them to the configured address and
+// applying the static resolve overrides. A guard refuses private
+// addresses.
+func dialContext(l *logger, ipFamily, bindAddress string, resolve map[string]string, timeout time.Duration,
+	guard *privateGuard) (func(context.Context, string, string) (net.Conn, error), error) {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	network := ""
+	switch ipFamily {
+	case ipFamilyV4:
+		network = "tcp4"
+	case ipFamilyV6:
+		network = "tcp6"
+	}
+
+	if bindAddress != "" {
+		ip := net.ParseIP(bindAddress)
+		if err := checkLocalAddress(ip); err != nil {
+			return nil, err
+		}
+
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		if ip.To4() != nil {
+			network = "tcp4"
+		} else {
+			network = "tcp6"
+		}
+	}
+
+	return func(ctx context.Context, n, addr string) (net.Conn, error) {
+		if network != "" {
+			n = network
+		}
+		d := dialer
+		if guard != nil {
+			d = guard.dialer(dialer, addr)
+		}
+		return d.DialContext(ctx, n, resolveOverride(l, resolve, addr))
+	}, nil
+}
+
+// checkLocalAddress ensures the address is assigned to this host to
+// report a misconfiguration instead of generic dial errors
+func checkLocalAddress(ip net.IP) error {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("Could not list local addresses: %s", err)
+	}
+
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("bind_address %s is not assigned to any interface of this host", ip)
+}
+
+// proxyFunc selects the proxy for a source: the source specific proxy
+// wins over the global one, the global one honors no_proxy and without
+// any configured proxy the environment is used
+func (c *configFile) proxyFunc(src *configFileSource) func(*http.Request) (*url.URL, error) {
+	switch {
+	case src.Proxy == proxyDirect:
+		return nil
+
+	case src.Proxy != "":
+		u, _ := url.Parse(src.Proxy)
+		return http.ProxyURL(u)
+
+	case c.Proxy == proxyDirect:
+		return nil
+
+	case c.Proxy != "":
+		pf := (&httpproxy.Config{
+			HTTPProxy:  c.Proxy,
+			HTTPSProxy: c.Proxy,
+			NoProxy:    strings.Join(c.NoProxy, ","),
+		}).ProxyFunc()
+		return func(r *http.Request) (*url.URL, error) { return pf(r.URL) }
+
+	default:
+		return http.ProxyFromEnvironment
+	}
+}
+
+// newHTTPClient creates a client with a transport configured for the
+// source, needs to be called with the config lock held
+func (c *configFile) newHTTPClient(src *configFileSource) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = c.proxyFunc(src)
+
+	ipFamily, bindAddress := c.IPFamily, c.BindAddress
+	if src.IPFamily != "" {
+		ipFamily = src.IPFamily
+	}
+	if src.BindAddress != "" {
+		bindAddress = src.BindAddress
+	}
+
+	connectTimeout := defaultConnectTimeout
+	if src.ConnectTimeout > 0 {
+		connectTimeout = src.ConnectTimeout
+		transport.TLSHandshakeTimeout = src.ConnectTimeout
+	}
+	transport.ResponseHeaderTimeout = src.ResponseHeaderTimeout
+
+	guard := c.privateGuard()
+	dial, err := dialContext(c.log, ipFamily, bindAddress, c.Resolve, connectTimeout, guard)
+	if err != nil {
+		return nil, err
+	}
+	transport.DialContext = dial
+
+	tlsConfig := &tls.Config{
+		RootCAs: c.rootCAs,
+		// Only available per source to not accidentally disable the
+		// verification for everything
+		InsecureSkipVerify: src.InsecureSkipVerify,
+	}
+	if src.rootCAs != nil {
+		tlsConfig.RootCAs = src.rootCAs
+	}
+	if len(src.PinSHA256) > 0 {
+		tlsConfig.VerifyPeerCertificate = verifyPins(src.PinSHA256)
+	}
+	if src.clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*src.clientCert}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	var rt http.RoundTripper = transport
+	if guard != nil && transport.Proxy != nil {
+		rt = privateProxyTransport{RoundTripper: rt, guard: guard, proxy: transport.Proxy, resolve: c.Resolve}
+	}
+	if allow := c.allowlist(); len(allow.hosts) > 0 || len(allow.schemes) > 0 {
+		rt = allowlistTransport{RoundTripper: rt, allow: allow}
+	}
+
+	return &http.Client{
+		Transport:     rt,
+		CheckRedirect: checkRedirect(src),
+	}, nil
+}
+
+// checkRedirect enforces the redirect policy of the source
+func checkRedirect(src *configFileSource) func(*http.Request, []*http.Request) error {
+	maxRedirects := defaultMaxRedirects
+	if src.MaxRedirects != nil {
+		maxRedirects = *src.MaxRedirects
+	}
+	sameHostOnly := src.RedirectSameHostOnly
+
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("Not following redirect to %s: more than %d redirects (max_redirects)",
+				req.URL.Redacted(), maxRedirects)
+		}
+
+		if req.URL.Host != via[0].URL.Host {
+			if sameHostOnly {
+				return fmt.Errorf("Not following redirect to %s: host differs from %s (redirect_same_host_only)",
+					req.URL.Redacted(), via[0].URL.Host)
+			}
+
+			// Credentials are meant for the original host only
+			req.Header.Del("Authorization")
+		}
+
+		return nil
+	}
+}
+
+// wrapTransportError makes errors caused by the proxy distinguishable
+// from errors caused by the origin server and names the timed out phase
+func wrapTransportError(ctx context.Context, client *http.Client, req *http.Request, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Overall timeout exceeded (timeout): %s", err)
+	}
+
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		switch {
+		case strings.Contains(err.Error(), "timeout awaiting response headers"):
+			return fmt.Errorf("Response header timeout (response_header_timeout): %s", err)
+		case strings.Contains(err.Error(), "TLS handshake timeout"):
+			return fmt.Errorf("TLS handshake timeout (connect_timeout): %s", err)
+		}
+		return err
+	}
+
+	if opErr.Op == "dial" && opErr.Timeout() {
+		return fmt.Errorf("Connect timeout (connect_timeout): %s", err)
+	}
+
+	if opErr.Op != "proxyconnect" && opErr.Op != "socks connect" {
+		return err
+	}
+
+	proxy := "proxy"
+	rt := client.Transport
+	if a, ok := rt.(allowlistTransport); ok {
+		rt = a.RoundTripper
+	}
+	if p, ok := rt.(privateProxyTransport); ok {
+		rt = p.RoundTripper
+	}
+	if t, ok := rt.(*http.Transport); ok && t.Proxy != nil {
+		if u, perr := t.Proxy(req); perr == nil && u != nil {
+			proxy = u.Redacted()
+		}
+	}
+
+	return proxyError{proxy: proxy, err: opErr}
+}
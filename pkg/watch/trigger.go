@@ -0,0 +1,135 @@
+package watch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	triggerSignatureHeader = "X-Signature-256"
+	triggerMaxBody         = 1 << 20
+)
+
+// triggerServer accepts signed pushes which trigger an immediate fetch
+// of an entry
+type triggerServer struct {
+	server *http.Server
+	config *configFile
+}
+
+func listenTrigger(addr string, config *configFile) (*triggerServer, error) {
+	config.RLock()
+	secret := config.TriggerSecret
+	config.RUnlock()
+	if secret == "" {
+		return nil, errors.New("The webhook listener needs a trigger_secret in the configuration")
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &triggerServer{config: config}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/trigger/", s.handleTrigger)
+
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+
+	go func() {
+		if err := s.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			config.log.logf(levelWarn, "Webhook listener stopped: %s", err)
+		}
+	}()
+
+	return s, nil
+}
+
+// Close stops the webhook listener
+func (s *triggerServer) Close() error {
+	return s.server.Close()
+}
+
+// verifyTriggerSignature checks the signature header has the format
+// "sha256=<hex HMAC-SHA256 of the body>"
+func verifyTriggerSignature(secret string, body []byte, header string) error {
+	if secret == "" {
+		return errors.New("No trigger_secret configured")
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil || !strings.HasPrefix(header, "sha256=") {
+		return errors.New("Malformed signature")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("Signature mismatch")
+	}
+
+	return nil
+}
+
+// resolveTrigger returns the path of the entry with the given alias or
+// path
+func (c *configFile) resolveTrigger(name string) (string, string) {
+	c.RLock()
+	defer c.RUnlock()
+
+	for filePath, fc := range c.Files {
+		if fc.Alias != "" && fc.Alias == name {
+			return filePath, c.TriggerSecret
+		}
+	}
+
+	return "/" + strings.TrimPrefix(name, "/"), c.TriggerSecret
+}
+
+func (s *triggerServer) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(s.config.log, w, r, http.MethodPost) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, triggerMaxBody))
+	if err != nil {
+		writeAdminResponse(s.config.log, w, http.StatusBadRequest, controlResponse{Error: "Could not read body"})
+		return
+	}
+
+	filePath, secret := s.config.resolveTrigger(strings.TrimPrefix(r.URL.Path, "/trigger/"))
+
+	if err := verifyTriggerSignature(secret, body, r.Header.Get(triggerSignatureHeader)); err != nil {
+		s.config.log.logf(levelWarn, "WARNING: Rejected trigger for '%s' from %s: %s", filePath, r.RemoteAddr, err)
+		s.config.countRejectedTrigger()
+		writeAdminResponse(s.config.log, w, http.StatusForbidden, controlResponse{Error: "Invalid signature"})
+		return
+	}
+
+	_, err = s.config.TriggerFetch(filePath)
+	switch err {
+	case nil:
+		s.config.log.logf(levelInfo, "Fetch of '%s' triggered by webhook from %s", filePath, r.RemoteAddr)
+		writeAdminResponse(s.config.log, w, http.StatusAccepted, controlResponse{OK: true, Message: "Fetch triggered"})
+	case ErrFetchInProgress:
+		writeAdminResponse(s.config.log, w, http.StatusAccepted, controlResponse{OK: true, Message: err.Error()})
+	case ErrUnknownFile:
+		writeAdminResponse(s.config.log, w, http.StatusNotFound, controlResponse{Error: err.Error()})
+	case ErrFilePaused, ErrPaused:
+		writeAdminResponse(s.config.log, w, http.StatusConflict, controlResponse{Error: err.Error()})
+	default:
+		writeAdminResponse(s.config.log, w, http.StatusInternalServerError, controlResponse{Error: err.Error()})
+	}
+}
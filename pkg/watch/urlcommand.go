@@ -0,0 +1,81 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const defaultURLCommandTimeout = 30 * time.Second
+
+func (c *configFileSource) validateURLCommand() error {
+	if c.URL == "" && c.URLCommand == "" && len(c.URLs) == 0 {
+		return errors.New("Needs url, urls or url_command")
+	}
+	if c.URLCommandTimeout != 0 && c.URLCommand == "" {
+		return errors.New("url_command_timeout needs a url_command")
+	}
+	return nil
+}
+
+// fetchURL returns the URL to request in this attempt: the static url
+// or the one printed by the url_command
+func (c *configFile) fetchURL(targetPath string, fc *configFileSource) (string, error) {
+	if fc.URLCommand == "" {
+		return fc.resolveURL(time.Now(), 0)
+	}
+
+	c.RLock()
+	argv := c.commandLine(fc, fc.URLCommand)
+	env := c.commandEnv(fc,
+		"DW_PATH="+targetPath,
+		"DW_URL="+fc.URL,
+	)
+	c.RUnlock()
+
+	timeout := fc.URLCommandTimeout
+	if timeout <= 0 {
+		timeout = defaultURLCommandTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(fc.fetchContext(), timeout)
+	defer cancel()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = env
+
+	err := cmd.Run()
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return "", fmt.Errorf("url_command: Timeout of %s exceeded", timeout)
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, firstLine(msg))
+		}
+		return "", fmt.Errorf("url_command: %s", err)
+	}
+
+	// Do not put the output into the error, it might be a signed URL
+	raw := strings.TrimSpace(stdout.String())
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("url_command: Output is no http(s) URL")
+	}
+
+	// Events and per-host limits refer to the last URL, the entry has none
+	fc.stateMu.Lock()
+	fc.resolvedURL = sanitizeMintedURL(raw)
+	fc.stateMu.Unlock()
+
+	return raw, nil
+}
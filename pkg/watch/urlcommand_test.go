@@ -0,0 +1,45 @@
+package watch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestURLCommandEntryReportsMintedURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	target := filepath.Join(t.TempDir(), "file")
+	w := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url_command: echo '%s/file?X-Amz-Signature=secret'\n", target, srv.URL))
+	changes := make(chan ChangeEvent, 1)
+	w.OnChange(func(ev ChangeEvent) { changes <- ev })
+
+	fc := w.lookup(target)
+	if err := w.config.runFetch(context.Background(), target, fc, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if host := fc.host(); host != "127.0.0.1" {
+		t.Errorf("host for per-host limits is %q, want the host of the minted URL", host)
+	}
+	want := srv.URL + "/file?REDACTED"
+	if u := fc.displayURL(); u != want {
+		t.Errorf("displayed url is %q, want %q", u, want)
+	}
+	select {
+	case ev := <-changes:
+		if ev.URL != want {
+			t.Errorf("change event url is %q, want %q", ev.URL, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change event")
+	}
+}
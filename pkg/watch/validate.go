@@ -0,0 +1,233 @@
+package watch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// urlCheckTimeout limits each check of Validate
+const urlCheckTimeout = 30 * time.Second
+
+// urlCheck is the result of checking one source URL of an entry
+type urlCheck struct {
+	path string
+	// url is empty for the URL of the entry, it is resolved from the
+	// date template or the url_command when checking
+	url      string
+	resolved bool
+
+	status     int
+	length     int64
+	validators []string
+	skipped    string
+	err        error
+}
+
+// Validate loads and validates the configuration file. With checkURLs
+// every source URL is requested with HEAD, or with a GET of the first
+// byte where HEAD is refused, using the settings of its entry. At most
+// concurrency URLs are checked at once and nothing is written. It prints
+// the results and returns whether everything is valid and reachable.
+func Validate(configPath string, opts Options, checkURLs bool, concurrency int) bool {
+	config, err := loadConfigFile(configPath, opts)
+	if err != nil {
+		fmt.Printf("INVALID: %s\n", err)
+		return false
+	}
+
+	if !checkURLs {
+		fmt.Printf("OK: %d files configured\n", len(config.Files))
+		return true
+	}
+
+	// Set up by New for the daemon, needed to run the url_commands
+	if len(config.CommandShell) == 0 {
+		config.CommandShell = defaultCommandShell
+	}
+
+	var checks []*urlCheck
+	for filePath, fc := range config.Files {
+		urls := []string{""}
+		if len(fc.URLs) > 0 {
+			urls = append([]string(nil), fc.URLs...)
+		}
+		for _, u := range append(urls, fc.FallbackURLs...) {
+			checks = append(checks, &urlCheck{path: filePath, url: u})
+		}
+	}
+	sort.SliceStable(checks, func(i, j int) bool { return checks[i].path < checks[j].path })
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		sem = make(chan struct{}, concurrency)
+		wg  sync.WaitGroup
+	)
+	for _, chk := range checks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(chk *urlCheck) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			config.checkURL(chk, config.Files[chk.path])
+		}(chk)
+	}
+	wg.Wait()
+
+	failed := 0
+	rows := [][]string{{"RESULT", "PATH", "URL", "STATUS", "LENGTH", "DETAILS"}}
+	for _, chk := range checks {
+		row := []string{"OK", chk.path, chk.displayURL(config.Files[chk.path]), "-", "-", "-"}
+		switch {
+		case chk.err != nil:
+			failed++
+			row[0], row[5] = "FAIL", chk.err.Error()
+		case chk.skipped != "":
+			row[0], row[5] = "SKIP", chk.skipped
+		default:
+			row[5] = "no ETag or Last-Modified"
+			if len(chk.validators) > 0 {
+				row[5] = strings.Join(chk.validators, ", ")
+			}
+		}
+		if chk.status > 0 {
+			row[3] = strconv.Itoa(chk.status)
+		}
+		if chk.length >= 0 && chk.status > 0 {
+			row[4] = byteSize(chk.length).String()
+		}
+		rows = append(rows, row)
+	}
+	for _, line := range formatColumns(rows) {
+		fmt.Println(line)
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAILED: %d of %d URLs of %d files not reachable\n", failed, len(checks), len(config.Files))
+		return false
+	}
+	fmt.Printf("OK: %d URLs of %d files reachable\n", len(checks), len(config.Files))
+	return true
+}
+
+// displayURL hides URLs returned by a url_command as they might be
+// signed
+func (chk *urlCheck) displayURL(fc *configFileSource) string {
+	if chk.url == "" {
+		return "-"
+	}
+	if chk.resolved && fc.URLCommand != "" {
+		if u, err := url.Parse(chk.url); err == nil {
+			return fmt.Sprintf("url_command (%s://%s)", u.Scheme, u.Host)
+		}
+		return "url_command"
+	}
+	return fc.log.mask(sanitizeURL(chk.url))
+}
+
+// checkURL requests the URL of the check with the client of the entry
+// and records the response
+func (c *configFile) checkURL(chk *urlCheck, fc *configFileSource) {
+	if chk.url == "" {
+		u, err := c.fetchURL(chk.path, fc)
+		if err != nil {
+			chk.err = err
+			return
+		}
+		chk.url, chk.resolved = u, true
+	}
+
+	c.RLock()
+	allow := c.allowlist()
+	userAgent := c.userAgent(fc)
+	client, err := c.newHTTPClient(fc)
+	c.RUnlock()
+	if err != nil {
+		chk.err = err
+		return
+	}
+	defer client.CloseIdleConnections()
+
+	if err := allow.check(chk.url); err != nil {
+		chk.err = err
+		return
+	}
+	fetcher, err := c.fetchers.fetcherFor(chk.url)
+	if err != nil {
+		chk.err = err
+		return
+	}
+	if _, ok := fetcher.(httpFetcher); !ok {
+		chk.skipped = "Scheme is not checked"
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), urlCheckTimeout)
+	defer cancel()
+
+	res, err := fc.probe(ctx, client, http.MethodHead, chk.url, userAgent)
+	if err == nil && (res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented) {
+		res, err = fc.probe(ctx, client, http.MethodGet, chk.url, userAgent)
+	}
+	if err != nil {
+		chk.err = fc.sanitizeError(err)
+		return
+	}
+
+	chk.status = res.StatusCode
+	chk.length = res.ContentLength
+	if res.StatusCode == http.StatusPartialContent {
+		// Content-Range: bytes 0-0/<total>
+		chk.length = -1
+		if i := strings.LastIndex(res.Header.Get("Content-Range"), "/"); i >= 0 {
+			if total, err := strconv.ParseInt(res.Header.Get("Content-Range")[i+1:], 10, 64); err == nil {
+				chk.length = total
+			}
+		}
+	}
+	if res.Header.Get("ETag") != "" {
+		chk.validators = append(chk.validators, "ETag")
+	}
+	if res.Header.Get("Last-Modified") != "" {
+		chk.validators = append(chk.validators, "Last-Modified")
+	}
+
+	if res.StatusCode >= 300 {
+		chk.err = fmt.Errorf("Got error status code %d", res.StatusCode)
+	}
+}
+
+// probe sends a HEAD request or a GET of the first byte with the headers
+// and credentials of the source, the body is not read
+func (c *configFileSource) probe(ctx context.Context, client *http.Client, method, rawURL, userAgent string) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.prepareRequest(req, userAgent); err != nil {
+		return nil, err
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+
+	res, err := ctxhttp.Do(ctx, client, req)
+	if err != nil {
+		if c.URLCommand != "" {
+			err = sanitizeMintedURLError(err)
+		}
+		return nil, wrapTransportError(ctx, client, req, err)
+	}
+	res.Body.Close()
+	return res, nil
+}
@@ -0,0 +1,307 @@
+// Package watch keeps local files in sync with their upstream URLs as
+// described by a download-watch configuration file. It is the engine of
+// the download-watch daemon and can be embedded into other programs:
+//
+//	cfg, err := watch.LoadConfig("/etc/download-watch/files.yaml", watch.Options{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	w, err := watch.New(cfg)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+//	defer cancel()
+//	w.Run(ctx)
+package watch
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// Version is reported in the default User-Agent of all requests
+var Version = "dev"
+
+// Options are the settings given on the commandline of the daemon
+// instead of in the configuration file
+type Options struct {
+	// AgeIdentity is the age identity file used to decrypt the values
+	// tagged with !encrypted
+	AgeIdentity string
+	// Overrides replace values of the configuration before it is
+	// validated, given as "<dotted path>=<YAML value>". The paths are
+	// resolved against the loaded file, unknown paths and values of the
+	// wrong type fail the load.
+	Overrides []string
+	// Debug enables debug logging, Quiet suppresses all messages below
+	// warn unless Debug is set. A Watcher keeps the log level of the
+	// Config passed to New, see SetDebug.
+	Debug bool
+	Quiet bool
+}
+
+// Config is a loaded and validated configuration. Create it with
+// LoadConfig, the zero value contains no files.
+type Config struct {
+	// StateFile persists validators and the schedule of all files to
+	// this path so a restart does not fetch everything again. Only the
+	// value passed to New is used.
+	StateFile string
+
+	file *configFile
+}
+
+// LoadConfig reads and validates the configuration file
+func LoadConfig(filePath string, opts Options) (Config, error) {
+	file, err := loadConfigFile(filePath, opts)
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{file: file}, nil
+}
+
+// Watcher schedules the fetches of the files of its configuration. All
+// methods are safe for concurrent use.
+type Watcher struct {
+	config    *configFile
+	stateFile string
+
+	// controlSockets are handed over to the RunAs account
+	controlSockets []string
+}
+
+// New creates a Watcher for the configuration and restores the state
+// of the files from the state file. Nothing is fetched before Run or
+// FetchRequired are called.
+func New(cfg Config) (*Watcher, error) {
+	l := newLogger(false, false)
+	if cfg.file != nil {
+		l = cfg.file.log
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	config := newConfigFile(l)
+	config.CommandShell = defaultCommandShell
+	config.hostname = hostname()
+	config.hooks = newHookQueue(l)
+	config.schedule = newScheduleQueue()
+	config.wakeup = make(chan struct{}, 1)
+	config.ctx, config.stop = ctx, stop
+
+	w := &Watcher{config: config, stateFile: cfg.StateFile}
+	if cfg.StateFile != "" {
+		w.config.state = newStateFile(cfg.StateFile)
+	}
+
+	if err := w.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Reload applies a new configuration. Entries which did not change keep
+// their state, changed entries start over and removed ones are dropped.
+func (w *Watcher) Reload(cfg Config) error {
+	if cfg.file == nil {
+		cfg.file = newConfigFile(nil)
+	}
+
+	w.config.Lock()
+	err := w.config.Patch(cfg.file)
+	w.config.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := w.config.RestoreState(); err != nil {
+		w.config.log.logf(levelWarn, "Could not restore state, continuing without: %s", err)
+	}
+	w.config.rescheduleAll()
+
+	w.config.emitEvent(streamEvent{Event: eventConfigReloaded, Files: len(cfg.file.Files)})
+	return nil
+}
+
+// FetchRequired fetches all files marked as required and returns an
+// error if one of them could not be fetched within the startup deadline
+// or ctx is done before
+func (w *Watcher) FetchRequired(ctx context.Context) error {
+	return w.config.FetchRequired(ctx)
+}
+
+// Run executes the fetches when they are due until the context is done.
+// It then cancels the running downloads and commands and waits for the
+// downloads to finish before it returns.
+func (w *Watcher) Run(ctx context.Context) {
+	waiter := w.config.WaitNextExecution(ctx)
+	for {
+		select {
+		case <-waiter:
+			w.config.ExecuteExpired(ctx)
+		case <-ctx.Done():
+			w.config.log.debug("Shutting down, waiting for running downloads")
+			w.config.stop()
+			w.config.Shutdown()
+			w.config.hooks.stop()
+			return
+		}
+	}
+}
+
+// OnChange registers a callback invoked after a file was written
+// successfully. The callbacks of OnChange and OnError are invoked one
+// after another on a separate goroutine in the order the events
+// happened, so the events of one file never overtake each other. A
+// callback must not block for long: while the queue of 100 events is
+// full further events are dropped. Panics are recovered and logged.
+func (w *Watcher) OnChange(fn func(ChangeEvent)) {
+	w.config.hooks.addChange(fn)
+}
+
+// OnError registers a callback invoked after a failed fetch attempt, it
+// is delivered like the callbacks of OnChange
+func (w *Watcher) OnError(fn func(ErrorEvent)) {
+	w.config.hooks.addError(fn)
+}
+
+// RegisterFetcher makes the Fetcher handle all URLs with the scheme for
+// this Watcher, replacing the previous Fetcher of the scheme. The
+// built-in Fetcher handles http and https. Options like urls, mirror,
+// fallback_urls and check_interval are only supported by the built-in
+// Fetcher.
+func (w *Watcher) RegisterFetcher(scheme string, f Fetcher) {
+	w.config.fetchers.register(scheme, f)
+}
+
+// EnableEvents starts writing the events of the Watcher in the given
+// format to out
+func (w *Watcher) EnableEvents(format string, out io.Writer) error {
+	return w.config.events.enable(format, out)
+}
+
+// SetDebug enables or disables the debug logging of the Watcher, it can
+// be toggled at runtime
+func (w *Watcher) SetDebug(enabled bool) {
+	w.config.log.setDebug(enabled)
+}
+
+// DebugEnabled reports whether the Watcher logs debug messages
+func (w *Watcher) DebugEnabled() bool {
+	return w.config.log.debugEnabled()
+}
+
+// SetQuiet suppresses all messages below warn unless debug logging is
+// enabled
+func (w *Watcher) SetQuiet(enabled bool) {
+	w.config.log.setQuiet(enabled)
+}
+
+// QuietEnabled reports whether messages below warn are suppressed
+func (w *Watcher) QuietEnabled() bool {
+	return w.config.log.quietEnabled()
+}
+
+// LogLevel returns the name of the current log level: debug, info or
+// warn
+func (w *Watcher) LogLevel() string {
+	return w.config.log.level()
+}
+
+// TriggerFetch fetches the file with the given path as soon as possible,
+// an empty path triggers all files which are not paused or running. It
+// returns the paths of the triggered files.
+func (w *Watcher) TriggerFetch(filePath string) ([]string, error) {
+	return w.config.TriggerFetch(filePath)
+}
+
+// ForceFetch triggers the fetch like TriggerFetch and installs the
+// content even if reject_older would refuse it as older
+func (w *Watcher) ForceFetch(filePath string) ([]string, error) {
+	return w.config.ForceFetch(filePath)
+}
+
+// SetPaused pauses or resumes the scheduling of the file with the given
+// path or of all files if the path is empty. Running fetches are not
+// interrupted.
+func (w *Watcher) SetPaused(filePath string, paused bool) error {
+	return w.config.SetPaused(filePath, paused)
+}
+
+// Status returns the state of all files sorted by their path
+func (w *Watcher) Status() []FileStatus {
+	return w.config.Status()
+}
+
+// Stats returns the queue depth and active workers of the download pool
+// and the number of rejected webhook triggers
+func (w *Watcher) Stats() DaemonStats {
+	return w.config.Stats()
+}
+
+// Summary returns a one-line summary of the state of all files
+func (w *Watcher) Summary() string {
+	return w.config.statusSummary()
+}
+
+// RunAs creates the directories of all files and the state file, checks
+// the account given as "user[:group]" is able to write them and drops
+// the privileges of the process to that account
+func (w *Watcher) RunAs(spec string) error {
+	acc, err := lookupRunAs(spec)
+	if err != nil {
+		return err
+	}
+
+	if err := w.config.prepareRunAs(acc, w.stateFile); err != nil {
+		return err
+	}
+
+	// Sockets are created before the privileges are dropped, the account
+	// has to be able to connect to them
+	for _, p := range w.controlSockets {
+		if err := chownPath(p, acc); err != nil {
+			return fmt.Errorf("Control socket %s: %s", p, err)
+		}
+	}
+
+	if err := dropPrivileges(acc); err != nil {
+		return err
+	}
+
+	w.config.log.debug("Switched to %s (uid %d, gid %d)", spec, acc.UID, acc.GID)
+	return nil
+}
+
+// ListenControlSocket accepts the commands of RunControlClient on a unix
+// socket, reload is called for the reload command
+func (w *Watcher) ListenControlSocket(socketPath string, reload func() error) (io.Closer, error) {
+	s, err := listenControlSocket(socketPath, w.config, reload)
+	if err != nil {
+		return nil, err
+	}
+	w.controlSockets = append(w.controlSockets, socketPath)
+	return s, nil
+}
+
+// ListenAdmin serves the HTTP admin API on the address, reload is
+// called for POST /reload
+func (w *Watcher) ListenAdmin(addr, token string, allow []string, enablePprof bool, reload func() error) (io.Closer, error) {
+	s, err := listenAdmin(addr, token, allow, enablePprof, w.config, reload)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListenTrigger accepts signed fetch triggers on the address
+func (w *Watcher) ListenTrigger(addr string) (io.Closer, error) {
+	s, err := listenTrigger(addr, w.config)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
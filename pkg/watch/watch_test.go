@@ -0,0 +1,109 @@
+package watch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// testConfig parses the YAML configuration like LoadConfig
+func testConfig(t *testing.T, raw string) Config {
+	t.Helper()
+
+	file, err := parseConfig([]byte(raw), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Config{file: file}
+}
+
+// newTestWatcher creates a Watcher for the YAML configuration
+func newTestWatcher(t *testing.T, raw string) *Watcher {
+	t.Helper()
+
+	w, err := New(testConfig(t, raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// lookup returns the current entry of the path, nil if there is none
+func (w *Watcher) lookup(filePath string) *configFileSource {
+	w.config.RLock()
+	defer w.config.RUnlock()
+
+	return w.config.Files[filePath]
+}
+
+// logBuffer collects the log output of a test
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// captureLog collects the log output until the test finished
+func captureLog(t *testing.T) *logBuffer {
+	b := &logBuffer{}
+	log.SetOutput(b)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return b
+}
+
+func TestWatchersAreIndependent(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: test://host/file\n", filepath.Join(dir, "first")))
+	second := newTestWatcher(t, fmt.Sprintf("files:\n  %s:\n    url: test://host/file\n", filepath.Join(dir, "second")))
+
+	// Like a value decrypted while loading the configuration
+	first.config.log.addSecret("first-secret")
+	first.SetDebug(true)
+	if second.DebugEnabled() {
+		t.Error("debug logging of one watcher enabled it for the other")
+	}
+
+	if got := second.config.log.mask("first-secret"); got != "first-secret" {
+		t.Errorf("secret of one watcher masked by the other: %q", got)
+	}
+	if got := first.config.log.mask("first-secret"); got == "first-secret" {
+		t.Error("secret not masked by its own watcher")
+	}
+
+	first.RegisterFetcher("test", fetcherFunc(func(_ context.Context, _ Source, dest io.Writer, _ PrevState) (Result, error) {
+		_, err := io.WriteString(dest, "content")
+		return Result{}, err
+	}))
+	var events bytes.Buffer
+	if err := first.EnableEvents("json", &events); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "second")
+	if err := second.config.runFetch(context.Background(), target, second.lookup(target), false); err == nil {
+		t.Error("fetcher of one watcher used by the other")
+	}
+	if events.Len() != 0 {
+		t.Errorf("events of one watcher written to the stream of the other:\n%s", events.String())
+	}
+}
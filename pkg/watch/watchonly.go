@@ -0,0 +1,66 @@
+package watch
+
+import (
+	"errors"
+	"time"
+)
+
+func (c *configFileSource) validateWatchOnly() error {
+	if !c.WatchOnly {
+		return nil
+	}
+
+	switch {
+	case c.SHA256 != "":
+		return errors.New("sha256 can't be used with watch_only")
+	case c.Extract != "" || c.JSONPath != "" || c.TransformCommand != "":
+		return errors.New("extract, json_path and transform_command can't be used with watch_only")
+	case c.appendMode():
+		return errors.New("mode append can't be used with watch_only")
+	case c.MirrorTo != "":
+		return errors.New("mirror_to can't be used with watch_only")
+	case c.OnMissing != "" && c.OnMissing != onMissingKeep:
+		return errors.New("on_missing can't delete or empty a watch_only entry")
+	}
+	return nil
+}
+
+// finishWatchOnly records the hash of the discarded body and announces a
+// change compared to the previous hash. The first hash is only recorded
+// as there is nothing to compare it with.
+func (c *configFile) finishWatchOnly(targetPath string, targetConfig *configFileSource, val responseValidators, result downloadResult, rec *FetchRecord) {
+	oldSHA256 := targetConfig.lastSHA256
+
+	rec.Outcome = outcomeChanged
+	if result.SHA256 == oldSHA256 {
+		rec.Outcome = outcomeUnchanged
+	}
+
+	targetConfig.runMu.Lock()
+	targetConfig.lastSHA256 = result.SHA256
+	targetConfig.lastDownload = time.Now()
+	targetConfig.finishWith(val)
+	targetConfig.runMu.Unlock()
+	c.saveState()
+
+	if rec.Outcome != outcomeChanged {
+		return
+	}
+	if oldSHA256 == "" {
+		targetConfig.logf(levelInfo, "Recorded initial sha256 %s of watched '%s'", result.SHA256, targetPath)
+		return
+	}
+
+	targetConfig.logf(levelInfo, "Watched '%s' changed (sha256 %s)", targetPath, result.SHA256)
+	c.notifyChange(targetPath, oldSHA256, result, rec)
+	c.notifyCommand(notifyEvent{
+		Event:     notifyEventChanged,
+		Path:      targetPath,
+		URL:       targetConfig.URL,
+		SHA256:    result.SHA256,
+		OldSHA256: oldSHA256,
+	})
+
+	c.streamFetch(targetPath, targetConfig, rec)
+	c.announceSuccess(targetPath, targetConfig, result)
+}
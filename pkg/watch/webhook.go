@@ -0,0 +1,148 @@
+package watch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout = 10 * time.Second
+	webhookAttempts       = 3
+	webhookRetryDelay     = 2 * time.Second
+)
+
+// webhook describes the endpoint change notifications are sent to
+type webhook struct {
+	URL           string        `yaml:"url"`
+	Authorization string        `yaml:"authorization"`
+	Timeout       time.Duration `yaml:"timeout"`
+}
+
+func webhookEqual(a, b *webhook) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// changeEvent is the payload sent to the webhook after a file changed
+type changeEvent struct {
+	Host      string        `json:"host"`
+	Path      string        `json:"path"`
+	URL       string        `json:"url"`
+	OldSHA256 string        `json:"old_sha256"`
+	NewSHA256 string        `json:"new_sha256"`
+	Event     string        `json:"event,omitempty"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+type webhookDelivery struct {
+	hook  webhook
+	event changeEvent
+}
+
+// deliver sends the notification, retrying failed attempts
+func (d webhookDelivery) deliver(l *logger) {
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := d.send()
+		if err == nil {
+			l.debug("Sent change notification for '%s' to %s", d.event.Path, sanitizeURL(d.hook.URL))
+			return
+		}
+
+		if attempt == webhookAttempts {
+			l.logf(levelWarn, "Could not send change notification for '%s' to %s: %s", d.event.Path, sanitizeURL(d.hook.URL),
+				sanitizeText(err.Error()))
+			return
+		}
+
+		l.debug("Change notification for '%s' failed (attempt %d), retrying in %s: %s", d.event.Path, attempt, delay,
+			sanitizeText(err.Error()))
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (d webhookDelivery) send() error {
+	body, err := json.Marshal(d.event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", d.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "download-watch/"+Version)
+	if d.hook.Authorization != "" {
+		req.Header.Set("Authorization", d.hook.Authorization)
+	}
+
+	timeout := d.hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	res, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Got status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// webhookFor returns the webhook configured for the entry, a per-file
+// webhook replaces the global one and disables it with an empty URL
+func (c *configFile) webhookFor(src *configFileSource) *webhook {
+	hook := c.NotifyWebhook
+	if src.NotifyWebhook != nil {
+		hook = src.NotifyWebhook
+	}
+
+	if hook == nil || hook.URL == "" {
+		return nil
+	}
+	return hook
+}
+
+// notifyChange queues the change notification of the entry if a
+// webhook is configured
+func (c *configFile) notifyChange(targetPath string, oldSHA256 string, result downloadResult, rec *FetchRecord) {
+	c.RLock()
+	defer c.RUnlock()
+
+	src, ok := c.Files[targetPath]
+	if !ok || c.webhooks == nil {
+		return
+	}
+
+	hook := c.webhookFor(src)
+	if hook == nil {
+		return
+	}
+
+	d := webhookDelivery{hook: *hook, event: changeEvent{
+		Host:      c.hostname,
+		Path:      targetPath,
+		URL:       src.displayURL(),
+		OldSHA256: oldSHA256,
+		NewSHA256: result.SHA256,
+		Event:     result.Event,
+		Bytes:     rec.Bytes,
+		Duration:  time.Since(rec.Time),
+		Timestamp: time.Now(),
+	}}
+	l := c.log
+	c.webhooks.Enqueue(func() { d.deliver(l) }, "notification for '%s'", targetPath)
+}
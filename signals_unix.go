@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReload sends to the channel whenever the configuration should
+// be reloaded
+func notifyReload(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
+
+// notifyPause sends to the channels when scheduling should be paused
+// (SIGTSTP) or resumed (SIGCONT)
+func notifyPause(pause, resume chan<- os.Signal) {
+	signal.Notify(pause, syscall.SIGTSTP)
+	signal.Notify(resume, syscall.SIGCONT)
+}
+
+// notifyFetchAll sends to the channel when all entries should be
+// fetched immediately (SIGUSR1)
+func notifyFetchAll(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
+
+// signalReload asks the daemon with the PID to reload its configuration
+func signalReload(pid int) error {
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
+
+// notifyToggleDebug sends to the channel when debug logging should be
+// toggled (SIGUSR2)
+func notifyToggleDebug(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
@@ -0,0 +1,52 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+const configPollInterval = 5 * time.Second
+
+// notifyReload sends to the channel whenever the configuration should
+// be reloaded. There is no SIGHUP on Windows so the configuration file
+// is polled for changes instead.
+func notifyReload(c chan<- os.Signal) {
+	go func() {
+		var last time.Time
+		if stat, err := os.Stat(cfg.ConfigFile); err == nil {
+			last = stat.ModTime()
+		}
+
+		for range time.Tick(configPollInterval) {
+			stat, err := os.Stat(cfg.ConfigFile)
+			if err != nil || stat.ModTime().Equal(last) {
+				continue
+			}
+
+			last = stat.ModTime()
+			select {
+			case c <- syscall.SIGHUP:
+			default:
+			}
+		}
+	}()
+}
+
+// notifyPause does nothing as there are no signals to pause on Windows,
+// the control socket or admin API can be used instead
+func notifyPause(pause, resume chan<- os.Signal) {}
+
+// notifyFetchAll does nothing as there is no SIGUSR1 on Windows, the
+// control socket or admin API can be used instead
+func notifyFetchAll(c chan<- os.Signal) {}
+
+// notifyToggleDebug does nothing as there is no SIGUSR2 on Windows
+func notifyToggleDebug(c chan<- os.Signal) {}
+
+// signalReload does nothing as the daemon polls the configuration file
+// for changes on Windows
+func signalReload(pid int) error { return nil }